@@ -0,0 +1,390 @@
+// Package config loads the server settings from an optional JSON file and the
+// environment, with environment variables taking precedence over the file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+type Config struct {
+	Addr      string `json:"addr"`
+	PublicDir string `json:"publicDir"`
+
+	// Storage selects the repository backend: firestore, memory or file.
+	Storage string `json:"storage"`
+	// DataPath is the BoltDB file the file backend keeps everything in.
+	DataPath string `json:"dataPath"`
+	// SearchIndexPath is where the search index is saved. When empty it is
+	// kept in memory and rebuilt from storage on every start.
+	SearchIndexPath string `json:"searchIndexPath"`
+
+	CredentialsPath string      `json:"credentialsPath"`
+	ProjectId       string      `json:"projectId"`
+	Collections     Collections `json:"collections"`
+
+	SessionSecret string `json:"sessionSecret"`
+	Cookie        Cookie `json:"cookie"`
+
+	// BaseURL is where users reach the site, for links in emails.
+	BaseURL string `json:"baseUrl"`
+	Mail    Mail   `json:"mail"`
+	// PasswordResetMinutes is how long a password reset link works.
+	PasswordResetMinutes int `json:"passwordResetMinutes"`
+	// EmailVerificationHours is how long an email confirmation link works.
+	EmailVerificationHours int `json:"emailVerificationHours"`
+	// TOTPIssuer names the site in authenticator apps.
+	TOTPIssuer string `json:"totpIssuer"`
+
+	// UsernameRedirectDays is how long an old username keeps redirecting
+	// to its account, and stays reserved for it, after a change.
+	UsernameRedirectDays int `json:"usernameRedirectDays"`
+
+	// RateLimits throttles routes by name: login, signup, add-post,
+	// forgot-password and verify-email. A route left out is not limited.
+	RateLimits   map[string]RouteLimit `json:"rateLimits"`
+	LoginBackoff LoginBackoff          `json:"loginBackoff"`
+}
+
+// RouteLimit limits a route per client address and per account.
+type RouteLimit struct {
+	PerIP      Limit `json:"perIp"`
+	PerAccount Limit `json:"perAccount"`
+}
+
+// Limit allows Requests per PeriodSeconds on average and up to Burst at
+// once, or Requests at once when Burst is zero. Zero Requests means no limit.
+type Limit struct {
+	Requests      int `json:"requests"`
+	PeriodSeconds int `json:"periodSeconds"`
+	Burst         int `json:"burst"`
+}
+
+// LoginBackoff slows down guessing an account's password. It counts
+// failures per account and client address. After FreeFailures failed logins
+// each further one makes the next wait twice as long, from BaseDelaySeconds
+// up to MaxDelaySeconds, and LockoutFailures in a row lock the account for
+// LockoutMinutes from that address.
+type LoginBackoff struct {
+	FreeFailures     int `json:"freeFailures"`
+	BaseDelaySeconds int `json:"baseDelaySeconds"`
+	MaxDelaySeconds  int `json:"maxDelaySeconds"`
+	LockoutFailures  int `json:"lockoutFailures"`
+	LockoutMinutes   int `json:"lockoutMinutes"`
+}
+
+// Collections names the Firestore collection behind each repository.
+type Collections struct {
+	Users string `json:"users"`
+	Posts string `json:"posts"`
+	Likes string `json:"likes"`
+	// Usernames holds one document per reserved username, keyed by it.
+	Usernames     string `json:"usernames"`
+	Notifications string `json:"notifications"`
+	Conversations string `json:"conversations"`
+	Tokens        string `json:"tokens"`
+	Sessions      string `json:"sessions"`
+}
+
+// empty lists the collections that have no name.
+func (c Collections) empty() []string {
+	var empty []string
+	for name, collection := range map[string]string{
+		"users":         c.Users,
+		"posts":         c.Posts,
+		"likes":         c.Likes,
+		"usernames":     c.Usernames,
+		"notifications": c.Notifications,
+		"conversations": c.Conversations,
+		"tokens":        c.Tokens,
+		"sessions":      c.Sessions,
+	} {
+		if collection == "" {
+			empty = append(empty, name)
+		}
+	}
+	sort.Strings(empty)
+	return empty
+}
+
+// Mail picks how emails are sent: "smtp" through an SMTP server, or "file"
+// to write each one into Dir instead.
+type Mail struct {
+	Backend      string `json:"backend"`
+	From         string `json:"from"`
+	Dir          string `json:"dir"`
+	SMTPAddr     string `json:"smtpAddr"`
+	SMTPUsername string `json:"smtpUsername"`
+	SMTPPassword string `json:"smtpPassword"`
+}
+
+type Cookie struct {
+	Name   string `json:"name"`
+	MaxAge int    `json:"maxAge"`
+	Secure bool   `json:"secure"`
+}
+
+func defaults() Config {
+	return Config{
+		Addr:            ":8000",
+		PublicDir:       "public",
+		Storage:         "firestore",
+		DataPath:        "data/store.db",
+		SearchIndexPath: "data/search.db",
+		Collections: Collections{
+			Users:         "users",
+			Posts:         "posts",
+			Likes:         "likes",
+			Usernames:     "usernames",
+			Notifications: "notifications",
+			Conversations: "conversations",
+			Tokens:        "tokens",
+			Sessions:      "sessions",
+		},
+		Cookie: Cookie{
+			Name:   "login",
+			MaxAge: 60 * 60 * 24 * 7,
+			Secure: true,
+		},
+		UsernameRedirectDays: 30,
+		BaseURL:              "http://localhost:8000",
+		Mail: Mail{
+			Backend: "file",
+			From:    "no-reply@localhost",
+			Dir:     "data/mail",
+		},
+		PasswordResetMinutes:   60,
+		EmailVerificationHours: 48,
+		TOTPIssuer:             "Posts",
+		RateLimits: map[string]RouteLimit{
+			"login": {
+				PerIP:      Limit{Requests: 20, PeriodSeconds: 60},
+				PerAccount: Limit{Requests: 10, PeriodSeconds: 60},
+			},
+			"signup": {
+				PerIP: Limit{Requests: 5, PeriodSeconds: 60 * 60},
+			},
+			"add-post": {
+				PerIP:      Limit{Requests: 60, PeriodSeconds: 60},
+				PerAccount: Limit{Requests: 30, PeriodSeconds: 60, Burst: 10},
+			},
+			// Each of these sends an email, so they are kept well below
+			// what would make the site a spam relay.
+			"forgot-password": {
+				PerIP:      Limit{Requests: 10, PeriodSeconds: 60 * 60},
+				PerAccount: Limit{Requests: 3, PeriodSeconds: 60 * 60},
+			},
+			"verify-email": {
+				PerIP:      Limit{Requests: 10, PeriodSeconds: 60 * 60},
+				PerAccount: Limit{Requests: 3, PeriodSeconds: 60 * 60},
+			},
+		},
+		LoginBackoff: LoginBackoff{
+			FreeFailures:     3,
+			BaseDelaySeconds: 1,
+			MaxDelaySeconds:  60,
+			LockoutFailures:  10,
+			LockoutMinutes:   15,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the JSON file at path (if
+// path is not empty) and the environment, then validates the result.
+func Load(path string) (*Config, error) {
+	config := defaults()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("config: parsing %s: %w", path, err)
+		}
+	}
+
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func (c *Config) loadEnv() error {
+	setString(&c.Addr, "LISTEN_ADDR")
+	setString(&c.PublicDir, "PUBLIC_DIR")
+	setString(&c.Storage, "STORAGE_BACKEND")
+	setString(&c.DataPath, "DATA_PATH")
+	setString(&c.SearchIndexPath, "SEARCH_INDEX_PATH")
+	setString(&c.CredentialsPath, "GOOGLE_APPLICATION_CREDENTIALS")
+	setString(&c.ProjectId, "FIRESTORE_PROJECT_ID")
+	setString(&c.Collections.Users, "USERS_COLLECTION")
+	setString(&c.Collections.Posts, "POSTS_COLLECTION")
+	setString(&c.Collections.Likes, "LIKES_COLLECTION")
+	setString(&c.Collections.Usernames, "USERNAMES_COLLECTION")
+	setString(&c.Collections.Notifications, "NOTIFICATIONS_COLLECTION")
+	setString(&c.Collections.Conversations, "CONVERSATIONS_COLLECTION")
+	setString(&c.SessionSecret, "SESSION_SECRET")
+	setString(&c.Cookie.Name, "COOKIE_NAME")
+	setString(&c.Collections.Tokens, "TOKENS_COLLECTION")
+	setString(&c.Collections.Sessions, "SESSIONS_COLLECTION")
+	setString(&c.BaseURL, "BASE_URL")
+	setString(&c.TOTPIssuer, "TOTP_ISSUER")
+	setString(&c.Mail.Backend, "MAIL_BACKEND")
+	setString(&c.Mail.From, "MAIL_FROM")
+	setString(&c.Mail.Dir, "MAIL_DIR")
+	setString(&c.Mail.SMTPAddr, "SMTP_ADDR")
+	setString(&c.Mail.SMTPUsername, "SMTP_USERNAME")
+	setString(&c.Mail.SMTPPassword, "SMTP_PASSWORD")
+
+	if err := setInt(&c.Cookie.MaxAge, "COOKIE_MAX_AGE"); err != nil {
+		return err
+	}
+	if err := setInt(&c.UsernameRedirectDays, "USERNAME_REDIRECT_DAYS"); err != nil {
+		return err
+	}
+	if err := setInt(&c.PasswordResetMinutes, "PASSWORD_RESET_MINUTES"); err != nil {
+		return err
+	}
+	if err := setInt(&c.EmailVerificationHours, "EMAIL_VERIFICATION_HOURS"); err != nil {
+		return err
+	}
+	return setBool(&c.Cookie.Secure, "COOKIE_SECURE")
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Addr == "" {
+		errs = append(errs, errors.New("listen address is empty"))
+	}
+	if c.PublicDir == "" {
+		errs = append(errs, errors.New("public directory is empty"))
+	}
+
+	switch c.Storage {
+	case "firestore":
+		if c.CredentialsPath == "" {
+			errs = append(errs, errors.New("firestore storage needs a credentials path (GOOGLE_APPLICATION_CREDENTIALS)"))
+		}
+		if c.ProjectId == "" {
+			errs = append(errs, errors.New("firestore storage needs a project id (FIRESTORE_PROJECT_ID)"))
+		}
+		for _, name := range c.Collections.empty() {
+			errs = append(errs, fmt.Errorf("%s collection name is empty", name))
+		}
+	case "file":
+		if c.DataPath == "" {
+			errs = append(errs, errors.New("file storage needs a data path (DATA_PATH)"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("unknown storage backend %q (want firestore, memory or file)", c.Storage))
+	}
+
+	if len(c.SessionSecret) < 32 {
+		errs = append(errs, errors.New("session secret (SESSION_SECRET) must be at least 32 bytes"))
+	}
+	if c.Cookie.Name == "" {
+		errs = append(errs, errors.New("cookie name is empty"))
+	}
+	if c.Cookie.MaxAge <= 0 {
+		errs = append(errs, errors.New("cookie max age must be positive"))
+	}
+	if c.UsernameRedirectDays < 0 {
+		errs = append(errs, errors.New("username redirect days must not be negative"))
+	}
+	if c.PasswordResetMinutes <= 0 {
+		errs = append(errs, errors.New("password reset minutes must be positive"))
+	}
+	if c.EmailVerificationHours <= 0 {
+		errs = append(errs, errors.New("email verification hours must be positive"))
+	}
+
+	for route, limit := range c.RateLimits {
+		for _, each := range []Limit{limit.PerIP, limit.PerAccount} {
+			if each.Requests < 0 || each.Burst < 0 {
+				errs = append(errs, fmt.Errorf("%s rate limit must not be negative", route))
+			}
+			if each.Requests > 0 && each.PeriodSeconds <= 0 {
+				errs = append(errs, fmt.Errorf("%s rate limit needs a positive period", route))
+			}
+		}
+	}
+	backoff := c.LoginBackoff
+	if backoff.FreeFailures < 0 || backoff.BaseDelaySeconds < 0 || backoff.MaxDelaySeconds < backoff.BaseDelaySeconds {
+		errs = append(errs, errors.New("login backoff delays must not be negative, nor the maximum below the base"))
+	}
+	if backoff.LockoutFailures <= backoff.FreeFailures || backoff.LockoutMinutes <= 0 {
+		errs = append(errs, errors.New("login lockout needs more failures than are free and a positive duration"))
+	}
+
+	if c.BaseURL == "" {
+		errs = append(errs, errors.New("base URL (BASE_URL) is empty"))
+	}
+	if c.TOTPIssuer == "" {
+		errs = append(errs, errors.New("TOTP issuer (TOTP_ISSUER) is empty"))
+	}
+	if c.Mail.From == "" {
+		errs = append(errs, errors.New("mail sender (MAIL_FROM) is empty"))
+	}
+	switch c.Mail.Backend {
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			errs = append(errs, errors.New("smtp mail needs a server address (SMTP_ADDR)"))
+		}
+	case "file":
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("file mail needs a directory (MAIL_DIR)"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown mail backend %q (want smtp or file)", c.Mail.Backend))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func setString(field *string, key string) {
+	if value, ok := os.LookupEnv(key); ok {
+		*field = value
+	}
+}
+
+func setInt(field *int, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*field = n
+	return nil
+}
+
+func setBool(field *bool, key string) error {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", key, err)
+	}
+	*field = b
+	return nil
+}
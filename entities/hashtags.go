@@ -0,0 +1,60 @@
+// Package entities finds the structured parts of post content, such as
+// hashtags and mentions, so they can be indexed and linked.
+package entities
+
+import (
+	"posts/models"
+	"strings"
+	"unicode"
+)
+
+const maxTagLength = 100
+
+// Parse finds every entity in content that can be recognized from the text
+// alone. Mentions also need the user store, so they are left to the caller.
+func Parse(content string) models.Entities {
+	return models.Entities{Hashtags: Hashtags(content)}
+}
+
+// Hashtags returns every #hashtag in content. A hashtag starts with '#' at the
+// beginning of the content or after a character that cannot be part of a
+// word, runs over letters, digits and underscores, and must contain at least
+// one letter, so "#1" or "a#b" are not tags.
+func Hashtags(content string) []models.HashtagEntity {
+	runes := []rune(content)
+
+	var hashtags []models.HashtagEntity
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && isTagRune(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		hasLetter := false
+		for end < len(runes) && isTagRune(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+
+		if hasLetter && end-i-1 <= maxTagLength {
+			hashtags = append(hashtags, models.HashtagEntity{
+				Tag:   NormalizeTag(string(runes[i+1 : end])),
+				Start: i,
+				End:   end,
+			})
+		}
+		i = end - 1
+	}
+
+	return hashtags
+}
+
+// NormalizeTag maps the ways a tag can be written ("#Go", "go", "GO") to the
+// one form it is indexed under.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isTagRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
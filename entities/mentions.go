@@ -0,0 +1,56 @@
+package entities
+
+import "strings"
+
+const maxHandleLength = 30
+
+// Mention is an @handle found in content, before it is known whether the
+// handle belongs to anyone.
+type Mention struct {
+	// Handle is normalized, without the '@'.
+	Handle string
+	Start  int
+	End    int
+}
+
+// Mentions returns every @handle in content. Like hashtags, a mention starts
+// at the beginning of the content or after a character that cannot be part
+// of a word, so email addresses are not mentions.
+func Mentions(content string) []Mention {
+	runes := []rune(content)
+
+	var mentions []Mention
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isTagRune(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isHandleRune(runes[end]) {
+			end++
+		}
+
+		if end > i+1 && end-i-1 <= maxHandleLength {
+			mentions = append(mentions, Mention{
+				Handle: NormalizeHandle(string(runes[i+1 : end])),
+				Start:  i,
+				End:    end,
+			})
+		}
+		i = end - 1
+	}
+
+	return mentions
+}
+
+// NormalizeHandle maps "@Ann", "ann" and "ANN" to the form usernames are
+// stored in.
+func NormalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(handle, "@"))
+}
+
+// isHandleRune is narrower than isTagRune: handles are plain ASCII so they
+// read the same everywhere they are typed.
+func isHandleRune(r rune) bool {
+	return r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+}
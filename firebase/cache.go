@@ -0,0 +1,117 @@
+package firebase
+
+import (
+	"posts/models"
+	"sync"
+	"time"
+)
+
+// userCacheTTL is how long a cached user is served. Every write through
+// this server forgets the account at once; the TTL bounds how stale a copy
+// can get when another server or the console changes it.
+const userCacheTTL = 30 * time.Second
+
+// userCacheSize bounds how many users are cached at once.
+const userCacheSize = 10000
+
+type cachedUser struct {
+	user    *models.User
+	expires time.Time
+}
+
+// userCache keeps users looked up by id, so the handlers that show the
+// session user on every request do not each cost a query. Every write to an
+// account forgets it by document id. Security checks do not read from it;
+// see FindAccountByUuidUncached.
+type userCache struct {
+	mu    sync.RWMutex
+	users map[string]cachedUser
+	// ids maps document ids to the user ids cached under them.
+	ids map[string]string
+	// generation counts forgets, so a read that raced with a write does
+	// not cache what it read.
+	generation uint64
+}
+
+func newUserCache() *userCache {
+	return &userCache{users: make(map[string]cachedUser), ids: make(map[string]string)}
+}
+
+// copyUser copies user deeply enough that neither copy's slices can change
+// the other.
+func copyUser(user *models.User) *models.User {
+	c := *user
+	c.Followers = append([]string(nil), user.Followers...)
+	c.Following = append([]string(nil), user.Following...)
+	c.RecoveryCodes = append([]string(nil), user.RecoveryCodes...)
+	return &c
+}
+
+// get returns a copy of the cached user, so callers cannot change the
+// cached one.
+func (c *userCache) get(id string, now time.Time) (*models.User, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cached, ok := c.users[id]
+	if !ok || !now.Before(cached.expires) {
+		return nil, false
+	}
+	return copyUser(cached.user), true
+}
+
+// current returns the generation to hand to put after reading a user.
+func (c *userCache) current() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation
+}
+
+// put caches user unless an account was written since generation. When
+// the cache is full, expired users make room first, then any at all.
+func (c *userCache) put(generation uint64, docId string, user *models.User, now time.Time) {
+	copied := copyUser(user)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if _, ok := c.users[user.Id]; !ok && len(c.users) >= userCacheSize {
+		c.evict(now)
+	}
+	c.users[user.Id] = cachedUser{user: copied, expires: now.Add(userCacheTTL)}
+	c.ids[docId] = user.Id
+}
+
+// evict drops expired users, or one arbitrary user if none has expired.
+// Callers must hold c.mu.
+func (c *userCache) evict(now time.Time) {
+	for docId, id := range c.ids {
+		if cached, ok := c.users[id]; !ok || !now.Before(cached.expires) {
+			delete(c.users, id)
+			delete(c.ids, docId)
+		}
+	}
+	if len(c.users) < userCacheSize {
+		return
+	}
+	for docId, id := range c.ids {
+		delete(c.users, id)
+		delete(c.ids, docId)
+		return
+	}
+}
+
+func (c *userCache) forget(docIds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, docId := range docIds {
+		delete(c.users, c.ids[docId])
+		delete(c.ids, docId)
+	}
+}
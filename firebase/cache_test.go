@@ -0,0 +1,67 @@
+package firebase
+
+import (
+	"posts/models"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestUserCacheExpires(t *testing.T) {
+	cache := newUserCache()
+	now := time.Now()
+	cache.put(cache.current(), "doc", &models.User{Id: "alice"}, now)
+
+	if _, ok := cache.get("alice", now.Add(userCacheTTL-time.Second)); !ok {
+		t.Error("user gone before the TTL")
+	}
+	if _, ok := cache.get("alice", now.Add(userCacheTTL)); ok {
+		t.Error("user still cached after the TTL")
+	}
+}
+
+func TestUserCacheHandsOutDeepCopies(t *testing.T) {
+	cache := newUserCache()
+	now := time.Now()
+	user := &models.User{Id: "alice", Followers: []string{"bob"}, RecoveryCodes: []string{"code"}}
+	cache.put(cache.current(), "doc", user, now)
+	user.Followers[0] = "mallory"
+
+	got, _ := cache.get("alice", now)
+	got.RecoveryCodes[0] = "stolen"
+	got.Following = append(got.Following, "mallory")
+
+	again, _ := cache.get("alice", now)
+	if again.Followers[0] != "bob" || again.RecoveryCodes[0] != "code" || len(again.Following) != 0 {
+		t.Errorf("changing a user in or out of the cache changed the cached one: %+v", again)
+	}
+}
+
+func TestUserCacheStaysBounded(t *testing.T) {
+	cache := newUserCache()
+	now := time.Now()
+	for i := 0; i < userCacheSize+10; i++ {
+		id := strconv.Itoa(i)
+		cache.put(cache.current(), "doc"+id, &models.User{Id: id}, now)
+	}
+
+	if len(cache.users) > userCacheSize || len(cache.ids) > userCacheSize {
+		t.Errorf("cache holds %d users and %d ids, more than %d", len(cache.users), len(cache.ids), userCacheSize)
+	}
+	last := strconv.Itoa(userCacheSize + 9)
+	if _, ok := cache.get(last, now); !ok {
+		t.Error("the newest user was not cached")
+	}
+}
+
+func TestUserCacheSkipsReadsThatRacedAWrite(t *testing.T) {
+	cache := newUserCache()
+	now := time.Now()
+	generation := cache.current()
+	cache.forget("doc")
+	cache.put(generation, "doc", &models.User{Id: "alice"}, now)
+
+	if _, ok := cache.get("alice", now); ok {
+		t.Error("a user read before a write was cached after it")
+	}
+}
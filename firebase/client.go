@@ -0,0 +1,15 @@
+package firebase
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+)
+
+// NewClient opens the Firestore client that every repository shares for the
+// lifetime of the process. The caller closes it on shutdown.
+func NewClient(ctx context.Context, projectId string, credentialsPath string) (*firestore.Client, error) {
+	opt := option.WithCredentialsFile(credentialsPath)
+	return firestore.NewClient(ctx, projectId, opt)
+}
@@ -0,0 +1,22 @@
+package firebase
+
+import (
+	"posts/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backendError wraps a Firestore failure, marking the gRPC codes that usually
+// clear up on their own as temporary.
+func backendError(op string, err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return &repository.BackendError{Op: op, Err: err, Temporary: true}
+	}
+	return &repository.BackendError{Op: op, Err: err}
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
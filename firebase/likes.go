@@ -0,0 +1,109 @@
+package firebase
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Likes keeps one document per (post, user) pair, keyed so that liking twice
+// hits the same document, and maintains LikeCount on the post alongside it.
+type Likes struct {
+	client          *firestore.Client
+	collection      string
+	postsCollection string
+}
+
+func NewLikes(client *firestore.Client, collection string, postsCollection string) *Likes {
+	return &Likes{client: client, collection: collection, postsCollection: postsCollection}
+}
+
+func likeDocId(postId string, userId string) string {
+	return postId + "_" + userId
+}
+
+func (l *Likes) Like(ctx context.Context, postId string, userId string) (int, error) {
+	return l.set(ctx, postId, userId, true)
+}
+
+func (l *Likes) Unlike(ctx context.Context, postId string, userId string) (int, error) {
+	return l.set(ctx, postId, userId, false)
+}
+
+// set runs in a transaction so concurrent likes on the same post are retried
+// by Firestore instead of losing updates to the count.
+func (l *Likes) set(ctx context.Context, postId string, userId string, liked bool) (int, error) {
+	postRef := l.client.Collection(l.postsCollection).Doc(postId)
+	likeRef := l.client.Collection(l.collection).Doc(likeDocId(postId, userId))
+
+	var count int
+	err := l.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		postSnapshot, err := tx.Get(postRef)
+		if err != nil {
+			return err
+		}
+		post, err := decodeLivePost(postSnapshot)
+		if err != nil {
+			return err
+		}
+
+		likeSnapshot, err := tx.Get(likeRef)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+
+		count = post.LikeCount
+		if likeSnapshot.Exists() == liked {
+			return nil
+		}
+
+		if liked {
+			count++
+			err = tx.Create(likeRef, map[string]interface{}{
+				"PostId": postId,
+				"UserId": userId,
+			})
+		} else {
+			count--
+			err = tx.Delete(likeRef)
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Update(postRef, []firestore.Update{
+			{Path: "LikeCount", Value: count},
+		})
+	})
+
+	if err != nil {
+		return 0, postError("like post", err)
+	}
+
+	return count, nil
+}
+
+func (l *Likes) LikedPostIds(ctx context.Context, userId string, postIds []string) (map[string]bool, error) {
+	liked := make(map[string]bool)
+	if len(postIds) == 0 {
+		return liked, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(postIds))
+	for _, postId := range postIds {
+		refs = append(refs, l.client.Collection(l.collection).Doc(likeDocId(postId, userId)))
+	}
+
+	snapshots, err := l.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, backendError("get likes", err)
+	}
+
+	for i, snapshot := range snapshots {
+		if snapshot.Exists() {
+			liked[postIds[i]] = true
+		}
+	}
+
+	return liked, nil
+}
@@ -0,0 +1,366 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+)
+
+// Messages keeps each conversation as a document with its messages in a
+// "messages" subcollection underneath it.
+type Messages struct {
+	client     *firestore.Client
+	collection string
+}
+
+func NewMessages(client *firestore.Client, collection string) *Messages {
+	return &Messages{client: client, collection: collection}
+}
+
+func (m *Messages) messages(conversationId string) *firestore.CollectionRef {
+	return m.client.Collection(m.collection).Doc(conversationId).Collection("messages")
+}
+
+func (m *Messages) CreateDirectConversation(ctx context.Context, userId string, otherId string) (*models.Conversation, error) {
+	ref := m.client.Collection(m.collection).Doc(models.DirectConversationId(userId, otherId))
+
+	var conversation *models.Conversation
+	err := m.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err == nil {
+			conversation, err = decodeConversation(snapshot)
+			return err
+		}
+		if !isNotFound(err) {
+			return err
+		}
+
+		now := time.Now().UTC()
+		conversation = &models.Conversation{
+			Id:        ref.ID,
+			Kind:      models.DirectConversation,
+			MemberIds: []string{userId, otherId},
+			CreatedAt: now,
+			UpdatedAt: now,
+			ReadAt:    map[string]time.Time{},
+			Unread:    map[string]int{},
+		}
+		return tx.Create(ref, conversation)
+	})
+
+	if err != nil {
+		return nil, conversationError("create conversation", err)
+	}
+
+	return conversation, nil
+}
+
+func (m *Messages) CreateGroupConversation(ctx context.Context, ownerId string, name string, memberIds []string) (*models.Conversation, error) {
+	now := time.Now().UTC()
+	conversation := &models.Conversation{
+		Id:        uuid.New().String(),
+		Kind:      models.GroupConversation,
+		Name:      name,
+		OwnerId:   ownerId,
+		MemberIds: []string{ownerId},
+		JoinedAt:  map[string]time.Time{ownerId: now},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ReadAt:    map[string]time.Time{},
+		Unread:    map[string]int{ownerId: 0},
+	}
+	for _, memberId := range memberIds {
+		if !conversation.HasMember(memberId) {
+			conversation.MemberIds = append(conversation.MemberIds, memberId)
+			conversation.JoinedAt[memberId] = now
+			conversation.Unread[memberId] = 0
+		}
+	}
+
+	_, err := m.client.Collection(m.collection).Doc(conversation.Id).Create(ctx, conversation)
+	if err != nil {
+		return nil, backendError("create group conversation", err)
+	}
+
+	return conversation, nil
+}
+
+// AddMembers counts the members and adds the new ones in one transaction,
+// so two admins adding people at once cannot take the group past its limit,
+// and the unread counts of users already in it are left alone.
+func (m *Messages) AddMembers(ctx context.Context, conversationId string, userIds []string, maxMembers int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	ref := m.client.Collection(m.collection).Doc(conversationId)
+	err := m.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		conversation, err := decodeConversation(snapshot)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		var updates []firestore.Update
+		for _, userId := range userIds {
+			if conversation.HasMember(userId) {
+				continue
+			}
+			conversation.MemberIds = append(conversation.MemberIds, userId)
+			updates = append(updates,
+				firestore.Update{FieldPath: firestore.FieldPath{"JoinedAt", userId}, Value: now},
+				firestore.Update{FieldPath: firestore.FieldPath{"Unread", userId}, Value: 0})
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if len(conversation.MemberIds) > maxMembers {
+			return repository.ErrGroupFull
+		}
+
+		updates = append(updates, firestore.Update{Path: "MemberIds", Value: conversation.MemberIds})
+		return tx.Update(ref, updates)
+	})
+
+	return conversationError("add members", err)
+}
+
+func (m *Messages) RemoveMember(ctx context.Context, conversationId string, userId string) error {
+	ref := m.client.Collection(m.collection).Doc(conversationId)
+	err := m.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		conversation, err := decodeConversation(snapshot)
+		if err != nil {
+			return err
+		}
+
+		conversation.MemberIds = without(conversation.MemberIds, userId)
+		conversation.AdminIds = without(conversation.AdminIds, userId)
+		if conversation.OwnerId == userId {
+			conversation.OwnerId = conversation.NextOwner()
+			conversation.AdminIds = without(conversation.AdminIds, conversation.OwnerId)
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "MemberIds", Value: conversation.MemberIds},
+			{Path: "AdminIds", Value: conversation.AdminIds},
+			{Path: "OwnerId", Value: conversation.OwnerId},
+			{FieldPath: firestore.FieldPath{"JoinedAt", userId}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"ReadAt", userId}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"Unread", userId}, Value: firestore.Delete},
+		})
+	})
+
+	return conversationError("remove member", err)
+}
+
+func (m *Messages) SetAdmin(ctx context.Context, conversationId string, userId string, admin bool) error {
+	var value interface{} = firestore.ArrayRemove(userId)
+	if admin {
+		value = firestore.ArrayUnion(userId)
+	}
+
+	_, err := m.client.Collection(m.collection).Doc(conversationId).Update(ctx, []firestore.Update{
+		{Path: "AdminIds", Value: value},
+	})
+
+	return conversationError("set admin", err)
+}
+
+func without(ids []string, id string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+func (m *Messages) GetConversation(ctx context.Context, conversationId string) (*models.Conversation, error) {
+	snapshot, err := m.client.Collection(m.collection).Doc(conversationId).Get(ctx)
+	if err != nil {
+		return nil, conversationError("get conversation", err)
+	}
+
+	return decodeConversation(snapshot)
+}
+
+// ListConversations needs a composite index on (MemberIds array,
+// UpdatedAt desc, Id desc).
+func (m *Messages) ListConversations(ctx context.Context, userId string, page repository.PageRequest) (*repository.ConversationPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	query := m.client.Collection(m.collection).
+		Where("MemberIds", "array-contains", userId).
+		OrderBy("UpdatedAt", firestore.Desc).OrderBy("Id", firestore.Desc)
+	if cursor != nil {
+		query = query.StartAfter(cursor.CreatedAt, cursor.Id)
+	}
+
+	docs, err := query.Limit(page.Limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("list conversations", err)
+	}
+
+	conversations := make([]*models.Conversation, 0, len(docs))
+	for _, doc := range docs {
+		conversation, err := decodeConversation(doc)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	return repository.NewConversationPage(conversations, page.Limit), nil
+}
+
+// AddMessage writes the message and updates the conversation's counters in
+// one transaction, so concurrent messages are all counted.
+func (m *Messages) AddMessage(ctx context.Context, message *models.Message) error {
+	ref := m.client.Collection(m.collection).Doc(message.ConversationId)
+
+	err := m.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		conversation, err := decodeConversation(snapshot)
+		if err != nil {
+			return err
+		}
+
+		message.Id = uuid.New().String()
+		message.CreatedAt = time.Now().UTC()
+
+		updates := []firestore.Update{
+			{Path: "LastMessage", Value: message},
+			{Path: "UpdatedAt", Value: message.CreatedAt},
+		}
+		for _, memberId := range conversation.MemberIds {
+			if memberId == message.SenderId {
+				updates = append(updates,
+					firestore.Update{FieldPath: firestore.FieldPath{"ReadAt", memberId}, Value: message.CreatedAt},
+					firestore.Update{FieldPath: firestore.FieldPath{"Unread", memberId}, Value: 0},
+				)
+			} else {
+				updates = append(updates,
+					firestore.Update{FieldPath: firestore.FieldPath{"Unread", memberId}, Value: firestore.Increment(1)},
+				)
+			}
+		}
+
+		if err := tx.Create(m.messages(conversation.Id).Doc(message.Id), message); err != nil {
+			return err
+		}
+		return tx.Update(ref, updates)
+	})
+
+	return conversationError("add message", err)
+}
+
+func (m *Messages) ListMessages(ctx context.Context, conversationId string, since time.Time, page repository.PageRequest) (*repository.MessagePage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	query := m.messages(conversationId).
+		Where("CreatedAt", ">=", since).
+		OrderBy("CreatedAt", firestore.Desc).OrderBy("Id", firestore.Desc)
+	if cursor != nil {
+		query = query.StartAfter(cursor.CreatedAt, cursor.Id)
+	}
+
+	docs, err := query.Limit(page.Limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("list messages", err)
+	}
+
+	messages := make([]*models.Message, 0, len(docs))
+	for _, doc := range docs {
+		var message models.Message
+		if err := doc.DataTo(&message); err != nil {
+			return nil, backendError("decode message", err)
+		}
+		messages = append(messages, &message)
+	}
+
+	return repository.NewMessagePage(messages, page.Limit), nil
+}
+
+func (m *Messages) MarkConversationRead(ctx context.Context, conversationId string, userId string, at time.Time) error {
+	_, err := m.client.Collection(m.collection).Doc(conversationId).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"ReadAt", userId}, Value: at},
+		{FieldPath: firestore.FieldPath{"Unread", userId}, Value: 0},
+	})
+
+	return conversationError("mark conversation read", err)
+}
+
+func (m *Messages) UnreadMessageCount(ctx context.Context, userId string) (int, error) {
+	docs, err := m.client.Collection(m.collection).
+		Where("MemberIds", "array-contains", userId).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return 0, backendError("count unread messages", err)
+	}
+
+	count := 0
+	for _, doc := range docs {
+		conversation, err := decodeConversation(doc)
+		if err != nil {
+			return 0, err
+		}
+		count += conversation.Unread[userId]
+	}
+
+	return count, nil
+}
+
+func decodeConversation(snapshot *firestore.DocumentSnapshot) (*models.Conversation, error) {
+	var conversation models.Conversation
+	if err := snapshot.DataTo(&conversation); err != nil {
+		return nil, backendError("decode conversation", err)
+	}
+	if conversation.ReadAt == nil {
+		conversation.ReadAt = map[string]time.Time{}
+	}
+	if conversation.Unread == nil {
+		conversation.Unread = map[string]int{}
+	}
+	return &conversation, nil
+}
+
+// conversationError passes repository errors from inside a transaction
+// through and wraps everything else.
+func conversationError(op string, err error) error {
+	var backendErr *repository.BackendError
+	switch {
+	case err == nil:
+		return nil
+	case isNotFound(err), errors.Is(err, repository.ErrConversationNotFound):
+		return repository.ErrConversationNotFound
+	case errors.Is(err, repository.ErrGroupFull):
+		return repository.ErrGroupFull
+	case errors.As(err, &backendErr):
+		return err
+	default:
+		return backendError(op, err)
+	}
+}
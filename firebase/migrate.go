@@ -0,0 +1,132 @@
+package firebase
+
+import (
+	"context"
+	"posts/entities"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// BackfillPosts gives posts written before posts had ids and timestamps the
+// fields the feed queries filter and order on. Firestore leaves documents
+// without a field out of any query on it, so until then they appear in no
+// feed. Each post's id becomes its document id and its creation time the
+// document's. Fields a post already has are kept, so running it again only
+// touches posts added by an older server since. It returns how many posts
+// it changed.
+func (p *Posts) BackfillPosts(ctx context.Context) (int, error) {
+	docs := p.client.Collection(p.collection).Documents(ctx)
+	defer docs.Stop()
+
+	var refs []*firestore.DocumentRef
+	var updates [][]firestore.Update
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, backendError("list posts", err)
+		}
+
+		if missing := missingPostFields(doc); len(missing) > 0 {
+			refs = append(refs, doc.Ref)
+			updates = append(updates, missing)
+		}
+	}
+
+	for start := 0; start < len(refs); start += firestoreBatchLimit {
+		end := start + firestoreBatchLimit
+		if end > len(refs) {
+			end = len(refs)
+		}
+
+		batch := p.client.Batch()
+		for i := start; i < end; i++ {
+			batch.Update(refs[i], updates[i])
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return start, backendError("backfill posts", err)
+		}
+	}
+
+	return len(refs), nil
+}
+
+// BackfillUsers marks accounts created before email addresses were confirmed
+// as verified, so they are not shut out of posting. Those are the accounts
+// with no Verified field: every account created since is written with one.
+// It returns how many accounts it changed.
+func (a *Account) BackfillUsers(ctx context.Context) (int, error) {
+	docs := a.client.Collection(a.collection).Documents(ctx)
+	defer docs.Stop()
+
+	var refs []*firestore.DocumentRef
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, backendError("list users", err)
+		}
+
+		if _, ok := doc.Data()["Verified"]; !ok {
+			refs = append(refs, doc.Ref)
+		}
+	}
+
+	for start := 0; start < len(refs); start += firestoreBatchLimit {
+		end := start + firestoreBatchLimit
+		if end > len(refs) {
+			end = len(refs)
+		}
+
+		batch := a.client.Batch()
+		docIds := make([]string, 0, end-start)
+		for _, ref := range refs[start:end] {
+			batch.Update(ref, []firestore.Update{{Path: "Verified", Value: true}})
+			docIds = append(docIds, ref.ID)
+		}
+		_, err := batch.Commit(ctx)
+		a.cache.forget(docIds...)
+		if err != nil {
+			return start, backendError("backfill users", err)
+		}
+	}
+
+	return len(refs), nil
+}
+
+// missingPostFields returns an update setting each field postFields writes
+// that doc lacks.
+func missingPostFields(doc *firestore.DocumentSnapshot) []firestore.Update {
+	data := doc.Data()
+	content, _ := data["Content"].(string)
+	parsed := entities.Parse(content)
+
+	defaults := []firestore.Update{
+		{Path: "Id", Value: doc.Ref.ID},
+		{Path: "Entities", Value: parsed},
+		{Path: "Tags", Value: parsed.TagNames()},
+		{Path: "ParentId", Value: ""},
+		{Path: "RootId", Value: ""},
+		{Path: "RepostOf", Value: ""},
+		{Path: "QuoteOf", Value: ""},
+		{Path: "ReplyCount", Value: 0},
+		{Path: "CreatedAt", Value: doc.CreateTime.UTC()},
+		{Path: "UpdatedAt", Value: doc.UpdateTime.UTC()},
+		{Path: "LikeCount", Value: 0},
+		{Path: "EditedAt", Value: nil},
+		{Path: "DeletedAt", Value: nil},
+	}
+
+	var missing []firestore.Update
+	for _, update := range defaults {
+		if _, ok := data[update.Path]; !ok {
+			missing = append(missing, update)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,231 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/google/uuid"
+)
+
+var errUnexpectedAggregate = errors.New("unexpected aggregation result")
+
+type Notifications struct {
+	client     *firestore.Client
+	collection string
+}
+
+func NewNotifications(client *firestore.Client, collection string) *Notifications {
+	return &Notifications{client: client, collection: collection}
+}
+
+// unreadAbout finds the recipient's unread notification of kind about
+// postId. It needs a composite index on (RecipientId, Kind, PostId, ReadAt).
+func (n *Notifications) unreadAbout(recipientId string, kind string, postId string) firestore.Query {
+	return n.client.Collection(n.collection).
+		Where("RecipientId", "==", recipientId).
+		Where("Kind", "==", kind).
+		Where("PostId", "==", postId).
+		Where("ReadAt", "==", nil).
+		Limit(1)
+}
+
+func (n *Notifications) Record(ctx context.Context, recipientId string, kind string, postId string, actorId string, at time.Time) error {
+	query := n.unreadAbout(recipientId, kind, postId)
+
+	err := n.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+
+		if len(docs) == 0 {
+			notification := &models.Notification{
+				Id:          uuid.New().String(),
+				RecipientId: recipientId,
+				Kind:        kind,
+				PostId:      postId,
+				ActorIds:    []string{actorId},
+				ActorCount:  1,
+				CreatedAt:   at,
+				UpdatedAt:   at,
+			}
+			return tx.Create(n.client.Collection(n.collection).Doc(notification.Id), notificationFields(notification))
+		}
+
+		var notification models.Notification
+		if err := docs[0].DataTo(&notification); err != nil {
+			return backendError("decode notification", err)
+		}
+
+		notification.AddActor(actorId)
+
+		return tx.Update(docs[0].Ref, []firestore.Update{
+			{Path: "ActorIds", Value: notification.ActorIds},
+			{Path: "ActorCount", Value: notification.ActorCount},
+			{Path: "UpdatedAt", Value: at},
+		})
+	})
+
+	var backendErr *repository.BackendError
+	if err != nil && !errors.As(err, &backendErr) {
+		return backendError("record notification", err)
+	}
+	return err
+}
+
+func (n *Notifications) Retract(ctx context.Context, recipientId string, kind string, postId string, actorId string) error {
+	query := n.unreadAbout(recipientId, kind, postId)
+
+	err := n.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil || len(docs) == 0 {
+			return err
+		}
+
+		var notification models.Notification
+		if err := docs[0].DataTo(&notification); err != nil {
+			return backendError("decode notification", err)
+		}
+		if !notification.RemoveActor(actorId) {
+			return nil
+		}
+		if notification.ActorCount == 0 {
+			return tx.Delete(docs[0].Ref)
+		}
+
+		return tx.Update(docs[0].Ref, []firestore.Update{
+			{Path: "ActorIds", Value: notification.ActorIds},
+			{Path: "ActorCount", Value: notification.ActorCount},
+		})
+	})
+
+	var backendErr *repository.BackendError
+	if err != nil && !errors.As(err, &backendErr) {
+		return backendError("retract notification", err)
+	}
+	return err
+}
+
+// notificationFields writes ReadAt even when it is nil, because unread
+// notifications are found by it being null.
+func notificationFields(notification *models.Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":          notification.Id,
+		"RecipientId": notification.RecipientId,
+		"Kind":        notification.Kind,
+		"PostId":      notification.PostId,
+		"ActorIds":    notification.ActorIds,
+		"ActorCount":  notification.ActorCount,
+		"CreatedAt":   notification.CreatedAt,
+		"UpdatedAt":   notification.UpdatedAt,
+		"ReadAt":      nil,
+	}
+}
+
+// List needs a composite index on (RecipientId, UpdatedAt desc, Id desc).
+func (n *Notifications) List(ctx context.Context, recipientId string, page repository.PageRequest) (*repository.NotificationPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	query := n.client.Collection(n.collection).
+		Where("RecipientId", "==", recipientId).
+		OrderBy("UpdatedAt", firestore.Desc).OrderBy("Id", firestore.Desc)
+	if cursor != nil {
+		query = query.StartAfter(cursor.CreatedAt, cursor.Id)
+	}
+
+	docs, err := query.Limit(page.Limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("list notifications", err)
+	}
+
+	notifications := make([]*models.Notification, 0, len(docs))
+	for _, doc := range docs {
+		var notification models.Notification
+		if err := doc.DataTo(&notification); err != nil {
+			return nil, backendError("decode notification", err)
+		}
+		notifications = append(notifications, &notification)
+	}
+
+	return repository.NewNotificationPage(notifications, page.Limit), nil
+}
+
+func (n *Notifications) unread(recipientId string) firestore.Query {
+	return n.client.Collection(n.collection).
+		Where("RecipientId", "==", recipientId).
+		Where("ReadAt", "==", nil)
+}
+
+func (n *Notifications) UnreadCount(ctx context.Context, recipientId string) (int, error) {
+	query := n.unread(recipientId)
+	result, err := query.NewAggregationQuery().WithCount("unread").Get(ctx)
+	if err != nil {
+		return 0, backendError("count notifications", err)
+	}
+
+	count, ok := result["unread"].(*firestorepb.Value)
+	if !ok {
+		return 0, backendError("count notifications", errUnexpectedAggregate)
+	}
+
+	return int(count.GetIntegerValue()), nil
+}
+
+// firestoreBatchLimit is the most writes one batch accepts.
+const firestoreBatchLimit = 500
+
+func (n *Notifications) MarkRead(ctx context.Context, recipientId string, ids []string, at time.Time) error {
+	var snapshots []*firestore.DocumentSnapshot
+	var err error
+	if len(ids) == 0 {
+		snapshots, err = n.unread(recipientId).Documents(ctx).GetAll()
+	} else {
+		refs := make([]*firestore.DocumentRef, 0, len(ids))
+		for _, id := range ids {
+			refs = append(refs, n.client.Collection(n.collection).Doc(id))
+		}
+		snapshots, err = n.client.GetAll(ctx, refs)
+	}
+	if err != nil {
+		return backendError("find notifications", err)
+	}
+
+	var refs []*firestore.DocumentRef
+	for _, snapshot := range snapshots {
+		if !snapshot.Exists() {
+			continue
+		}
+		var notification models.Notification
+		if err := snapshot.DataTo(&notification); err != nil {
+			return backendError("decode notification", err)
+		}
+		if notification.RecipientId == recipientId && notification.ReadAt == nil {
+			refs = append(refs, snapshot.Ref)
+		}
+	}
+
+	for start := 0; start < len(refs); start += firestoreBatchLimit {
+		end := start + firestoreBatchLimit
+		if end > len(refs) {
+			end = len(refs)
+		}
+
+		batch := n.client.Batch()
+		for _, ref := range refs[start:end] {
+			batch.Update(ref, []firestore.Update{{Path: "ReadAt", Value: at}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return backendError("mark notifications read", err)
+		}
+	}
+
+	return nil
+}
@@ -2,125 +2,403 @@ package firebase
 
 import (
 	"context"
-	"log"
-	"posts/globals"
+	"errors"
 	"posts/models"
-	"sync"
+	"posts/repository"
+	"time"
 
 	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 )
 
-type PostsRepository interface {
-	AddPost(post *models.Post, author string, authorId string) error
-	GetPosts() ([]*models.Post, error)
-    GetPostByAuthorId(authorId string) ([]models.Post, error)
+type Posts struct {
+    client     *firestore.Client
+    collection string
 }
 
-type Posts struct{}
+func NewPosts(client *firestore.Client, collection string) *Posts {
+    return &Posts{client: client, collection: collection}
+}
+
+func (p *Posts) AddPost(ctx context.Context, post *models.Post, author string, authorId string) error {
+	initPost(post, author, authorId)
+
+	_, err := p.client.Collection(p.collection).Doc(post.Id).Set(ctx, postFields(post))
 
-func getFirebasePostsClient(ctx context.Context) (*firestore.Client, error) {
-    opt := option.WithCredentialsJSON([]byte(globals.ServiceAccountKey))
-	client, err := firestore.NewClient(ctx, globals.ProjectId, opt)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-		return nil, err
+		return backendError("add post", err)
 	}
 
-	return client, nil
+	return nil
+}
+
+// AddRepost looks for an earlier repost and writes the new one in one
+// transaction, so two at once still leave one. It needs a composite index
+// on (AuthorId, RepostOf, DeletedAt).
+func (p *Posts) AddRepost(ctx context.Context, post *models.Post, author string, authorId string) (bool, error) {
+	earlier := p.client.Collection(p.collection).
+		Where("AuthorId", "==", authorId).
+		Where("RepostOf", "==", post.RepostOf).
+		Where("DeletedAt", "==", nil).
+		Limit(1)
+
+	var created bool
+	err := p.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshots, err := tx.Documents(earlier).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(snapshots) > 0 {
+			existing, err := decodeLivePost(snapshots[0])
+			if err != nil {
+				return err
+			}
+			*post = *existing
+			created = false
+			return nil
+		}
+
+		initPost(post, author, authorId)
+		created = true
+		return tx.Create(p.client.Collection(p.collection).Doc(post.Id), postFields(post))
+	})
+
+	return created, postError("add repost", err)
+}
+
+// AddReply writes the reply and bumps the parent's ReplyCount in one
+// transaction, so the count stays right under concurrent replies.
+func (p *Posts) AddReply(ctx context.Context, reply *models.Post, parentId string, author string, authorId string) error {
+	parentRef := p.client.Collection(p.collection).Doc(parentId)
+
+	err := p.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(parentRef)
+		if err != nil {
+			return err
+		}
+		parent, err := decodeLivePost(snapshot)
+		if err != nil {
+			return err
+		}
+
+		initPost(reply, author, authorId)
+		reply.ParentId = parent.Id
+		reply.RootId = parent.RootId
+		if reply.RootId == "" {
+			reply.RootId = parent.Id
+		}
+
+		err = tx.Update(parentRef, []firestore.Update{
+			{Path: "ReplyCount", Value: firestore.Increment(1)},
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(p.client.Collection(p.collection).Doc(reply.Id), postFields(reply))
+	})
+
+	return postError("add reply", err)
+}
+
+func initPost(post *models.Post, author string, authorId string) {
+	now := time.Now().UTC()
+
+	post.Id = uuid.New().String()
+	post.Author = author
+	post.AuthorId = authorId
+	post.CreatedAt = now
+	post.UpdatedAt = now
+	post.Tags = post.Entities.TagNames()
 }
 
-func (*Posts) AddPost(post *models.Post, author string, authorId string) error {
-	ctx := context.Background()
-	client, err := getFirebasePostsClient(ctx)
+// postFields lists every field explicitly, including the empty ones, because
+// the feed queries filter on ParentId and DeletedAt being empty.
+func postFields(post *models.Post) map[string]interface{} {
+	return map[string]interface{}{
+		"Id":         post.Id,
+		"Author":     post.Author,
+		"Content":    post.Content,
+		"Entities":   post.Entities,
+		"Tags":       post.Tags,
+		"AuthorId":   post.AuthorId,
+		"ParentId":   post.ParentId,
+		"RootId":     post.RootId,
+		"RepostOf":   post.RepostOf,
+		"QuoteOf":    post.QuoteOf,
+		"ReplyCount": 0,
+		"CreatedAt":  post.CreatedAt,
+		"UpdatedAt":  post.UpdatedAt,
+		"LikeCount":  0,
+		"EditedAt":   nil,
+		"DeletedAt":  nil,
+	}
+}
+
+func (p *Posts) GetPost(ctx context.Context, postId string) (*models.Post, error) {
+	snapshot, err := p.client.Collection(p.collection).Doc(postId).Get(ctx)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-		return err
+		if isNotFound(err) {
+			return nil, repository.ErrPostNotFound
+		}
+		return nil, backendError("get post", err)
 	}
-	defer client.Close()
 
-	post.Author = author
-    post.AuthorId = authorId
+	return decodeLivePost(snapshot)
+}
+
+func (p *Posts) GetPostsByIds(ctx context.Context, postIds []string) (map[string]*models.Post, error) {
+	posts := make(map[string]*models.Post, len(postIds))
+	if len(postIds) == 0 {
+		return posts, nil
+	}
 
-	_, _, err = client.Collection(globals.PostsCollectionName).Add(ctx, map[string]string{
-        "Author": post.Author,
-		"Content": post.Content,
-        "AuthorId": post.AuthorId,
+	refs := make([]*firestore.DocumentRef, 0, len(postIds))
+	for _, postId := range postIds {
+		refs = append(refs, p.client.Collection(p.collection).Doc(postId))
+	}
+
+	snapshots, err := p.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, backendError("get posts", err)
+	}
+
+	for _, snapshot := range snapshots {
+		if !snapshot.Exists() {
+			continue
+		}
+		post, err := decodeLivePost(snapshot)
+		if errors.Is(err, repository.ErrPostNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		posts[post.Id] = post
+	}
+
+	return posts, nil
+}
+
+func (p *Posts) UpdatePostContent(ctx context.Context, postId string, content string, entities models.Entities) (*models.Post, error) {
+	ref := p.client.Collection(p.collection).Doc(postId)
+
+	var post *models.Post
+	err := p.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if post, err = decodeLivePost(snapshot); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		post.Content = content
+		post.Entities = entities
+		post.Tags = entities.TagNames()
+		post.UpdatedAt = now
+		post.EditedAt = &now
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "Content", Value: content},
+			{Path: "Entities", Value: post.Entities},
+			{Path: "Tags", Value: post.Tags},
+			{Path: "UpdatedAt", Value: now},
+			{Path: "EditedAt", Value: now},
+		})
 	})
 
 	if err != nil {
-		log.Fatalf("Failed to add post: %v", err)
+		return nil, postError("update post", err)
+	}
+
+	return post, nil
+}
+
+// DeletePost only marks the post as deleted so moderators can still read it.
+func (p *Posts) DeletePost(ctx context.Context, postId string) error {
+	ref := p.client.Collection(p.collection).Doc(postId)
+
+	err := p.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		post, err := decodeLivePost(snapshot)
+		if err != nil {
+			return err
+		}
+
+		if post.ParentId != "" {
+			err := tx.Update(p.client.Collection(p.collection).Doc(post.ParentId), []firestore.Update{
+				{Path: "ReplyCount", Value: firestore.Increment(-1)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Update(ref, []firestore.Update{
+			{Path: "DeletedAt", Value: time.Now().UTC()},
+		})
+	})
+
+	return postError("delete post", err)
+}
+
+func decodeLivePost(snapshot *firestore.DocumentSnapshot) (*models.Post, error) {
+	var post models.Post
+	if err := snapshot.DataTo(&post); err != nil {
+		return nil, backendError("decode post", err)
+	}
+	if post.DeletedAt != nil {
+		return nil, repository.ErrPostNotFound
+	}
+	return &post, nil
+}
+
+// postError passes repository errors from inside a transaction through and
+// wraps everything else.
+func postError(op string, err error) error {
+	var backendErr *repository.BackendError
+	switch {
+	case err == nil:
+		return nil
+	case isNotFound(err), errors.Is(err, repository.ErrPostNotFound):
+		return repository.ErrPostNotFound
+	case errors.As(err, &backendErr):
 		return err
+	default:
+		return backendError(op, err)
+	}
+}
+
+// EachPost walks the whole collection rather than querying on DeletedAt,
+// so it needs no index.
+func (p *Posts) EachPost(ctx context.Context, fn func(*models.Post) error) error {
+	docs := p.client.Collection(p.collection).Documents(ctx)
+	defer docs.Stop()
+
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return backendError("list posts", err)
+		}
+
+		post, err := decodeLivePost(doc)
+		if errors.Is(err, repository.ErrPostNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(post); err != nil {
+			return err
+		}
 	}
+}
 
-	return nil
+func (p *Posts) ListPosts(ctx context.Context, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(ctx, p.topLevel(), page)
+}
+
+// ListPostsByAuthor needs a composite index on (ParentId, AuthorId,
+// DeletedAt, CreatedAt desc, Id desc).
+func (p *Posts) ListPostsByAuthor(ctx context.Context, authorId string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(ctx, p.topLevel().Where("AuthorId", "==", authorId), page)
 }
 
-func (*Posts) GetPosts() ([]*models.Post, error) {
-	ctx := context.Background()
-	client, err := getFirebasePostsClient(ctx)
+func (p *Posts) ListThread(ctx context.Context, rootId string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(ctx, p.client.Collection(p.collection).Where("RootId", "==", rootId), page)
+}
+
+// ListPostsByTag needs a composite index on (Tags array, DeletedAt,
+// CreatedAt desc, Id desc).
+func (p *Posts) ListPostsByTag(ctx context.Context, tag string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(ctx, p.client.Collection(p.collection).Where("Tags", "array-contains", tag), page)
+}
+
+// topLevel leaves replies out of a feed.
+func (p *Posts) topLevel() firestore.Query {
+	return p.client.Collection(p.collection).Where("ParentId", "==", "")
+}
+
+// firestoreInLimit is the most values an "in" filter accepts.
+const firestoreInLimit = 10
+
+// ListPostsByAuthors runs one query per batch of authors and merges the
+// results, since an "in" filter only takes a handful of values.
+func (p *Posts) ListPostsByAuthors(ctx context.Context, authorIds []string, page repository.PageRequest) (*repository.PostPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
 		return nil, err
 	}
-	defer client.Close()
 
 	var posts []*models.Post
-	iter := client.Collection(globals.PostsCollectionName).Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+	for start := 0; start < len(authorIds); start += firestoreInLimit {
+		end := start + firestoreInLimit
+		if end > len(authorIds) {
+			end = len(authorIds)
 		}
+
+		query := p.topLevel().Where("AuthorId", "in", authorIds[start:end])
+		batch, err := p.fetch(ctx, query, cursor, page.Limit+1)
 		if err != nil {
-			log.Fatalf("Failed to iterate: %v", err)
 			return nil, err
 		}
+		posts = append(posts, batch...)
+	}
+
+	repository.SortPosts(posts)
+	if len(posts) > page.Limit+1 {
+		posts = posts[:page.Limit+1]
+	}
 
+	return repository.NewPostPage(posts, page.Limit), nil
+}
+
+// list runs query in feed order, resuming after page.Cursor.
+func (p *Posts) list(ctx context.Context, query firestore.Query, page repository.PageRequest) (*repository.PostPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := p.fetch(ctx, query, cursor, page.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.NewPostPage(posts, page.Limit), nil
+}
+
+// fetch returns up to limit posts matching query that come after cursor in
+// feed order.
+func (p *Posts) fetch(ctx context.Context, query firestore.Query, cursor *repository.Cursor, limit int) ([]*models.Post, error) {
+	query = query.Where("DeletedAt", "==", nil).
+		OrderBy("CreatedAt", firestore.Desc).OrderBy("Id", firestore.Desc)
+	if cursor != nil {
+		query = query.StartAfter(cursor.CreatedAt, cursor.Id)
+	}
+
+	docs, err := query.Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("list posts", err)
+	}
+
+	posts := make([]*models.Post, 0, len(docs))
+	for _, doc := range docs {
 		var post models.Post
-		doc.DataTo(&post)
+		if err := doc.DataTo(&post); err != nil {
+			return nil, backendError("decode post", err)
+		}
 		posts = append(posts, &post)
 	}
 
 	return posts, nil
 }
-
-func (*Posts) GetPostByAuthorId(id string) ([]models.Post, error) {
-    ctx := context.Background()
-    client, err := getFirebasePostsClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return nil, err
-    }
-    defer client.Close()
-
-    query := client.Collection(globals.PostsCollectionName).Where("AuthorId", "==", id)
-    docs, err := query.Documents(ctx).GetAll()
-    if err != nil {
-        log.Fatalf("Failed to fetch documents: %v", err)
-        return nil, err
-    }
-
-    var (
-        posts     []models.Post
-        wg        sync.WaitGroup
-        postsLock sync.Mutex
-    )
-
-    for _, doc := range docs {
-        wg.Add(1)
-        go func(d *firestore.DocumentSnapshot) {
-            defer wg.Done()
-            var post models.Post
-            d.DataTo(&post)
-            postsLock.Lock()
-            posts = append(posts, post)
-            postsLock.Unlock()
-        }(doc)
-    }
-
-    wg.Wait()
-
-    return posts, nil
-}
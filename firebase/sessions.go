@@ -0,0 +1,153 @@
+package firebase
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Sessions keys each session document by the session's id, which is the
+// hash of what the cookie holds. A TTL policy on ExpiresAt can clear out
+// the expired ones.
+type Sessions struct {
+	client     *firestore.Client
+	collection string
+}
+
+func NewSessions(client *firestore.Client, collection string) *Sessions {
+	return &Sessions{client: client, collection: collection}
+}
+
+func sessionError(op string, err error) error {
+	if isNotFound(err) {
+		return repository.ErrSessionNotFound
+	}
+	return backendError(op, err)
+}
+
+func (s *Sessions) CreateSession(ctx context.Context, session *models.Session) error {
+	_, err := s.client.Collection(s.collection).Doc(session.Id).Create(ctx, session)
+	if err != nil {
+		return backendError("create session", err)
+	}
+	return nil
+}
+
+func (s *Sessions) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	snapshot, err := s.client.Collection(s.collection).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, sessionError("get session", err)
+	}
+
+	var session models.Session
+	if err := snapshot.DataTo(&session); err != nil {
+		return nil, backendError("decode session", err)
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, repository.ErrSessionNotFound
+	}
+
+	return &session, nil
+}
+
+func (s *Sessions) UpdateSession(ctx context.Context, id string, values []byte, expiresAt time.Time) error {
+	_, err := s.client.Collection(s.collection).Doc(id).Update(ctx, []firestore.Update{
+		{Path: "Values", Value: values},
+		{Path: "ExpiresAt", Value: expiresAt},
+	})
+	if err != nil {
+		return sessionError("update session", err)
+	}
+	return nil
+}
+
+func (s *Sessions) TouchSession(ctx context.Context, id string, seenAt time.Time, ip string, userAgent string) error {
+	_, err := s.client.Collection(s.collection).Doc(id).Update(ctx, []firestore.Update{
+		{Path: "LastSeenAt", Value: seenAt},
+		{Path: "IP", Value: ip},
+		{Path: "UserAgent", Value: userAgent},
+	})
+	if err != nil {
+		return sessionError("touch session", err)
+	}
+	return nil
+}
+
+// userSessions returns every stored session of the user, expired or not.
+func (s *Sessions) userSessions(ctx context.Context, userId string) ([]*firestore.DocumentSnapshot, error) {
+	snapshots, err := s.client.Collection(s.collection).Where("UserId", "==", userId).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("find sessions", err)
+	}
+	return snapshots, nil
+}
+
+func (s *Sessions) GetUserSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	snapshots, err := s.userSessions(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []*models.Session{}
+	for _, snapshot := range snapshots {
+		var session models.Session
+		if err := snapshot.DataTo(&session); err != nil {
+			return nil, backendError("decode session", err)
+		}
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, &session)
+		}
+	}
+
+	// Sorted here rather than in the query, which would need an index.
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Sessions) DeleteSession(ctx context.Context, id string) error {
+	ref := s.client.Collection(s.collection).Doc(id)
+	_, err := ref.Delete(ctx, firestore.Exists)
+	if err != nil {
+		return sessionError("delete session", err)
+	}
+	return nil
+}
+
+func (s *Sessions) DeleteUserSessions(ctx context.Context, userId string, except string) error {
+	snapshots, err := s.userSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	var refs []*firestore.DocumentRef
+	for _, snapshot := range snapshots {
+		if snapshot.Ref.ID != except {
+			refs = append(refs, snapshot.Ref)
+		}
+	}
+
+	for start := 0; start < len(refs); start += firestoreBatchLimit {
+		end := start + firestoreBatchLimit
+		if end > len(refs) {
+			end = len(refs)
+		}
+
+		batch := s.client.Batch()
+		for _, ref := range refs[start:end] {
+			batch.Delete(ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return backendError("delete sessions", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Tokens keys each token document by the token's hash. A TTL policy on
+// ExpiresAt can clear out the old ones.
+type Tokens struct {
+	client     *firestore.Client
+	collection string
+}
+
+func NewTokens(client *firestore.Client, collection string) *Tokens {
+	return &Tokens{client: client, collection: collection}
+}
+
+func (t *Tokens) CreateToken(ctx context.Context, token *models.Token) error {
+	_, err := t.client.Collection(t.collection).Doc(token.Hash).Create(ctx, token)
+	if err != nil {
+		return backendError("create token", err)
+	}
+	return nil
+}
+
+// ConsumeToken reads and marks the token in one transaction, so two
+// requests racing with the same link cannot both use it.
+func (t *Tokens) ConsumeToken(ctx context.Context, purpose string, hash string, at time.Time) (*models.Token, error) {
+	ref := t.client.Collection(t.collection).Doc(hash)
+
+	var token models.Token
+	err := t.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snapshot.DataTo(&token); err != nil {
+			return backendError("decode token", err)
+		}
+
+		if token.Purpose != purpose || token.UsedAt != nil || !at.Before(token.ExpiresAt) {
+			return repository.ErrInvalidToken
+		}
+		token.UsedAt = &at
+
+		return tx.Update(ref, []firestore.Update{{Path: "UsedAt", Value: at}})
+	})
+
+	var backendErr *repository.BackendError
+	switch {
+	case err == nil:
+		return &token, nil
+	case isNotFound(err), errors.Is(err, repository.ErrInvalidToken):
+		return nil, repository.ErrInvalidToken
+	case errors.As(err, &backendErr):
+		return nil, err
+	default:
+		return nil, backendError("consume token", err)
+	}
+}
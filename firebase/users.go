@@ -2,68 +2,35 @@ package firebase
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"posts/globals"
+	"errors"
 	"posts/models"
+	"posts/repository"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
-
-	"google.golang.org/api/option"
+	"google.golang.org/api/iterator"
 )
 
-type AccountRepository interface {
-	CreateAccount(user *models.User) error
-	FindAccountByEmail(email *string) (*models.User, error)
-    FindAccountByUuid(id string) (*models.User, error)
-    AddFollower(followerId string, followingId string) error
-    RemoveFollower(followerId string, followingId string) error
-    GetDocumentIdByUuid(uuid string) (string, error)
-    IsFollowing(firstUuid string, secondUuid string) (bool, error)
-    UpdateEmail(docId string, email string) error
-    UpdateFirstName(docId string, firstName string) error
-    UpdateLastName(docId string, lastName string) error
+// Account keeps users in one collection and reserved usernames in another,
+// where each document's id is the username, so claiming one is a single
+// transactional read and write.
+type Account struct {
+    client     *firestore.Client
+    collection string
+    handles    string
+    cache      *userCache
 }
 
-type Account struct{}
-
-func getFirebaseUserClient(ctx context.Context) (*firestore.Client, error) {
-    opt := option.WithCredentialsJSON([]byte(globals.ServiceAccountKey))
-	client, err := firestore.NewClient(ctx, globals.ProjectId, opt)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-		return nil, err
-	}
-
-	return client, nil
+func NewAccount(client *firestore.Client, collection string, handles string) *Account {
+    return &Account{client: client, collection: collection, handles: handles, cache: newUserCache()}
 }
 
-func (*Account) CreateAccount(user *models.User) error {
-	ctx := context.Background()
-	client, err := getFirebaseUserClient(ctx)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-		return err
-	}
-	defer client.Close()
-
-	query := client.Collection(globals.UsersCollectionName).Where("Email", "==", user.Email).Limit(1)
-	docs, err := query.Documents(ctx).GetAll()
-	if err != nil {
-		log.Fatalf("Failed to get user: %v", err)
-		return err
-	}
-
-	if len(docs) > 0 {
-		return fmt.Errorf("User already exists")
-	}
-
+func (a *Account) CreateAccount(ctx context.Context, user *models.User) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Fatalf("Failed to hash password: %v", err)
 		return err
 	}
 
@@ -72,124 +39,212 @@ func (*Account) CreateAccount(user *models.User) error {
     followers := make([]string, 0)
     following := make([]string, 0)
 
-    _, _, err = client.Collection(globals.UsersCollectionName).Add(ctx, map[string]interface{}{
-        "Id":        user.Id,
-        "Email":     user.Email,
-        "Password":  string(hashedPassword),
-        "FirstName": user.FirstName,
-        "LastName":  user.LastName,
-        "Followers": followers,
-        "Following": following,
-    })
-	if err != nil {
-		log.Fatalf("Failed to add user: %v", err)
+	err = a.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		query := a.client.Collection(a.collection).Where("Email", "==", user.Email).Limit(1)
+		docs, err := tx.Documents(query).GetAll()
+		if err != nil {
+			return err
+		}
+
+		if len(docs) > 0 {
+			return repository.ErrUserExists
+		}
+
+		if user.Username != "" {
+			if err := a.claim(tx, user.Username, user.Id); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(a.client.Collection(a.collection).NewDoc(), map[string]interface{}{
+			"Id":        user.Id,
+			"Email":     user.Email,
+			"Username":  user.Username,
+			"Password":  string(hashedPassword),
+			"FirstName": user.FirstName,
+			"LastName":  user.LastName,
+			"Followers": followers,
+			"Following": following,
+			// BackfillUsers tells accounts from before verification by
+			// this field being missing.
+			"Verified": false,
+		})
+	})
+
+	return accountError("add user", err)
+}
+
+// claim reserves username for userId inside tx. It reads before it writes,
+// so it has to come before any other write in the transaction.
+func (a *Account) claim(tx *firestore.Transaction, username string, userId string) error {
+	ref := a.client.Collection(a.handles).Doc(username)
+
+	snapshot, err := tx.Get(ref)
+	if err != nil && !isNotFound(err) {
 		return err
 	}
+	if err == nil {
+		var handle models.Handle
+		if err := snapshot.DataTo(&handle); err != nil {
+			return backendError("decode username", err)
+		}
+		if handle.TakenFor(userId, time.Now()) {
+			return repository.ErrUsernameTaken
+		}
+	}
 
-	return nil
+	return tx.Set(ref, handleFields(&models.Handle{Username: username, UserId: userId}))
 }
 
-func (*Account) FindAccountByEmail(email *string) (*models.User, error) {
-	ctx := context.Background()
-	client, err := getFirebaseUserClient(ctx)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-		return nil, err
+func handleFields(handle *models.Handle) map[string]interface{} {
+	return map[string]interface{}{
+		"Username":      handle.Username,
+		"UserId":        handle.UserId,
+		"RedirectUntil": handle.RedirectUntil,
+	}
+}
+
+// accountError passes repository errors from inside a transaction through
+// and wraps everything else.
+func accountError(op string, err error) error {
+	var backendErr *repository.BackendError
+	switch {
+	case err == nil:
+		return nil
+	case isNotFound(err):
+		return repository.ErrUserNotFound
+	case errors.Is(err, repository.ErrUserNotFound),
+		errors.Is(err, repository.ErrUserExists),
+		errors.Is(err, repository.ErrUsernameTaken),
+		errors.Is(err, repository.ErrInvalidToken),
+		errors.Is(err, repository.ErrInvalidCode),
+		errors.As(err, &backendErr):
+		return err
+	default:
+		return backendError(op, err)
 	}
-	defer client.Close()
+}
 
-	query := client.Collection(globals.UsersCollectionName).Where("Email", "==", *email).Limit(1)
+func (a *Account) FindAccountByEmail(ctx context.Context, email *string) (*models.User, error) {
+	query := a.client.Collection(a.collection).Where("Email", "==", *email).Limit(1)
 	docs, err := query.Documents(ctx).GetAll()
 	if err != nil {
-		log.Fatalf("Failed to get user: %v", err)
-		return nil, err
+		return nil, backendError("find user by email", err)
 	}
 
 	if len(docs) == 0 {
-		return nil, fmt.Errorf("User not found")
+		return nil, repository.ErrUserNotFound
 	}
 
 	var user models.User
-	for _, doc := range docs {
-		doc.DataTo(&user)
+	if err := docs[0].DataTo(&user); err != nil {
+		return nil, backendError("decode user", err)
 	}
 
 	return &user, nil
 }
 
-var userCache = make(map[string]*models.User)
-
-func (*Account) FindAccountByUuid(id string) (*models.User, error) {
-	if user, ok := userCache[id]; ok {
+func (a *Account) FindAccountByUuid(ctx context.Context, id string) (*models.User, error) {
+	now := time.Now()
+	if user, ok := a.cache.get(id, now); ok {
 		return user, nil
 	}
 
-	ctx := context.Background()
-	client, err := getFirebaseUserClient(ctx)
+	generation := a.cache.current()
+	user, docId, err := a.lookupAccount(ctx, id)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
 		return nil, err
 	}
-	defer client.Close()
 
-	query := client.Collection(globals.UsersCollectionName).Where("Id", "==", id).Limit(1)
+	a.cache.put(generation, docId, user, now)
+
+	return user, nil
+}
+
+// FindAccountByUuidUncached always reads from Firestore, for callers that
+// cannot live with a stale cached copy.
+func (a *Account) FindAccountByUuidUncached(ctx context.Context, id string) (*models.User, error) {
+	user, _, err := a.lookupAccount(ctx, id)
+	return user, err
+}
+
+func (a *Account) lookupAccount(ctx context.Context, id string) (*models.User, string, error) {
+	query := a.client.Collection(a.collection).Where("Id", "==", id).Limit(1)
 	snapshots, err := query.Documents(ctx).GetAll()
 	if err != nil {
-		return nil, err
+		return nil, "", backendError("find user by id", err)
 	}
 
 	if len(snapshots) == 0 {
-		return nil, fmt.Errorf("User not found")
+		return nil, "", repository.ErrUserNotFound
 	}
 
 	var user models.User
 	if err := snapshots[0].DataTo(&user); err != nil {
-		return nil, err
+		return nil, "", backendError("decode user", err)
+	}
+
+	return &user, snapshots[0].Ref.ID, nil
+}
+
+func (a *Account) FindAccountByUsername(ctx context.Context, username string) (*models.User, error) {
+	query := a.client.Collection(a.collection).Where("Username", "==", username).Limit(1)
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, backendError("find user by username", err)
+	}
+
+	if len(docs) == 0 {
+		return nil, repository.ErrUserNotFound
 	}
 
-	userCache[id] = &user
+	var user models.User
+	if err := docs[0].DataTo(&user); err != nil {
+		return nil, backendError("decode user", err)
+	}
 
 	return &user, nil
 }
 
-func (*Account) GetDocumentIdByUuid(uuid string) (string, error) {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return "", err
-    }
-    defer client.Close()
+func (a *Account) FindAccountByOldUsername(ctx context.Context, username string) (*models.User, error) {
+	snapshot, err := a.client.Collection(a.handles).Doc(username).Get(ctx)
+	if err != nil {
+		return nil, accountError("find username", err)
+	}
+
+	var handle models.Handle
+	if err := snapshot.DataTo(&handle); err != nil {
+		return nil, backendError("decode username", err)
+	}
+	if !handle.Redirects(time.Now()) {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return a.FindAccountByUuidUncached(ctx, handle.UserId)
+}
 
-    collection := client.Collection(globals.UsersCollectionName)
+func (a *Account) GetDocumentIdByUuid(ctx context.Context, uuid string) (string, error) {
+    collection := a.client.Collection(a.collection)
     query := collection.Where("Id", "==", uuid).Limit(1)
 
     docs, err := query.Documents(ctx).GetAll()
     if err != nil {
-        log.Fatalf("Failed to get user: %v", err)
-        return "", err
+        return "", backendError("find user document", err)
     }
 
     if len(docs) == 0 {
-        return "", fmt.Errorf("User not found")
+        return "", repository.ErrUserNotFound
     }
 
     return docs[0].Ref.ID, nil
 }
 
-func (*Account) AddFollower(followerId string, followingId string) error {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        return fmt.Errorf("failed to create client: %v", err)
-    }
-    defer client.Close()
-
-    followingRef := client.Collection(globals.UsersCollectionName).Doc(followingId)
-    followerRef := client.Collection(globals.UsersCollectionName).Doc(followerId)
+func (a *Account) AddFollower(ctx context.Context, followerId string, followingId string) error {
+    followingRef := a.client.Collection(a.collection).Doc(followingId)
+    followerRef := a.client.Collection(a.collection).Doc(followerId)
 
     var wg sync.WaitGroup
-    var updateErr error
+    var followersErr, followingErr error
 
     wg.Add(2)
     go func() {
@@ -201,7 +256,7 @@ func (*Account) AddFollower(followerId string, followingId string) error {
             },
         })
         if err != nil {
-            updateErr = fmt.Errorf("failed adding follower: %v", err)
+            followersErr = backendError("add follower", err)
         }
     }()
 
@@ -214,29 +269,25 @@ func (*Account) AddFollower(followerId string, followingId string) error {
             },
         })
         if err != nil {
-            updateErr = fmt.Errorf("failed adding following: %v", err)
+            followingErr = backendError("add following", err)
         }
     }()
 
     wg.Wait()
+    a.cache.forget(followerId, followingId)
 
-    return updateErr
-}
-
-func (*Account) RemoveFollower(followerId string, followingId string) error {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return err
+    if followersErr != nil {
+        return followersErr
     }
-    defer client.Close()
+    return followingErr
+}
 
-    followingRef := client.Collection(globals.UsersCollectionName).Doc(followingId)
-    followerRef := client.Collection(globals.UsersCollectionName).Doc(followerId)
+func (a *Account) RemoveFollower(ctx context.Context, followerId string, followingId string) error {
+    followingRef := a.client.Collection(a.collection).Doc(followingId)
+    followerRef := a.client.Collection(a.collection).Doc(followerId)
 
     var wg sync.WaitGroup
-    var updateErr error
+    var followersErr, followingErr error
 
     wg.Add(2)
 
@@ -250,8 +301,7 @@ func (*Account) RemoveFollower(followerId string, followingId string) error {
         })
 
         if err != nil {
-            log.Fatalf("Failed removing follower: %v", err)
-            updateErr = err
+            followersErr = backendError("remove follower", err)
         }
     }()
 
@@ -265,47 +315,40 @@ func (*Account) RemoveFollower(followerId string, followingId string) error {
         })
 
         if err != nil {
-            log.Fatalf("Failed removing following: %v", err)
-            updateErr = err
+            followingErr = backendError("remove following", err)
         }
     }()
 
     wg.Wait()
+    a.cache.forget(followerId, followingId)
 
-    return updateErr
-}
-
-func (*Account) IsFollowing(firstUuid string, secondUuid string) (bool, error) {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return false, err
+    if followersErr != nil {
+        return followersErr
     }
-    defer client.Close()
+    return followingErr
+}
 
-    firstId, err := getDocumentIdByUuid(firstUuid)
+func (a *Account) IsFollowing(ctx context.Context, firstUuid string, secondUuid string) (bool, error) {
+    firstId, err := a.GetDocumentIdByUuid(ctx, firstUuid)
     if err != nil {
         return false, err
     }
 
-    secondId, err := getDocumentIdByUuid(secondUuid)
+    secondId, err := a.GetDocumentIdByUuid(ctx, secondUuid)
     if err != nil {
         return false, err
     }
 
-    followingSnapshot, err := client.Collection(globals.UsersCollectionName).Doc(firstId).Get(ctx)
+    followingSnapshot, err := a.client.Collection(a.collection).Doc(firstId).Get(ctx)
     if err != nil {
-        log.Fatalf("Failed to get following: %v", err)
-        return false, err
+        return false, backendError("get following", err)
     }
 
     var followingData struct {
         Following []string
     }
     if err := followingSnapshot.DataTo(&followingData); err != nil {
-        log.Fatalf("Failed to parse following data: %v", err)
-        return false, err
+        return false, backendError("decode following", err)
     }
 
     for _, id := range followingData.Following {
@@ -317,96 +360,253 @@ func (*Account) IsFollowing(firstUuid string, secondUuid string) (bool, error) {
     return false, nil
 }
 
-func (*Account) UpdateEmail(docId, email string) error {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
+// GetFollowingIds returns the uuids of the accounts uuid follows. Following
+// holds document ids, so each one is resolved back to the account's uuid.
+func (a *Account) GetFollowingIds(ctx context.Context, uuid string) ([]string, error) {
+    user, err := a.FindAccountByUuidUncached(ctx, uuid)
     if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return err
+        return nil, err
     }
-    defer client.Close()
-
-    accountRef := client.Collection(globals.UsersCollectionName).Doc(docId)
 
-    _, err = accountRef.Update(ctx, []firestore.Update{
-        {Path: "Email", Value: email},
-    })
+    refs := make([]*firestore.DocumentRef, 0, len(user.Following))
+    for _, docId := range user.Following {
+        refs = append(refs, a.client.Collection(a.collection).Doc(docId))
+    }
 
+    snapshots, err := a.client.GetAll(ctx, refs)
     if err != nil {
-        log.Fatalf("Failed updating user: %v", err)
-        return err
+        return nil, backendError("get following", err)
+    }
+
+    ids := make([]string, 0, len(snapshots))
+    for _, snapshot := range snapshots {
+        if !snapshot.Exists() {
+            continue
+        }
+        id, err := snapshot.DataAt("Id")
+        if err != nil {
+            return nil, backendError("decode following", err)
+        }
+        if id, ok := id.(string); ok {
+            ids = append(ids, id)
+        }
     }
 
-    return nil
+    return ids, nil
 }
 
-func (*Account) UpdateFirstName(docId, firstName string) error {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return err
-    }
-    defer client.Close()
+func (a *Account) SetPendingEmail(ctx context.Context, docId, email string) error {
+	return a.updateUser(ctx, docId, "set pending email", []firestore.Update{
+		{Path: "PendingEmail", Value: email},
+	})
+}
 
-    accountRef := client.Collection(globals.UsersCollectionName).Doc(docId)
+func (a *Account) VerifyEmail(ctx context.Context, docId, email string) error {
+	accountRef := a.client.Collection(a.collection).Doc(docId)
+
+	err := a.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(accountRef)
+		if err != nil {
+			return err
+		}
+		var user models.User
+		if err := snapshot.DataTo(&user); err != nil {
+			return backendError("decode user", err)
+		}
+
+		switch {
+		case email == user.Email:
+			return tx.Update(accountRef, []firestore.Update{
+				{Path: "Verified", Value: true},
+			})
+		case email != "" && email == user.PendingEmail:
+			query := a.client.Collection(a.collection).Where("Email", "==", email).Limit(1)
+			docs, err := tx.Documents(query).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) > 0 {
+				return repository.ErrUserExists
+			}
+
+			return tx.Update(accountRef, []firestore.Update{
+				{Path: "Email", Value: email},
+				{Path: "PendingEmail", Value: ""},
+				{Path: "Verified", Value: true},
+			})
+		default:
+			return repository.ErrInvalidToken
+		}
+	})
+	a.cache.forget(docId)
+	if err != nil {
+		return accountError("verify email", err)
+	}
 
-    _, err = accountRef.Update(ctx, []firestore.Update{
-        {Path: "FirstName", Value: firstName},
-    })
+	return nil
+}
 
-    if err != nil {
-        log.Fatalf("Failed updating user: %v", err)
-        return err
-    }
+func (a *Account) UpdateFirstName(ctx context.Context, docId, firstName string) error {
+	return a.updateUser(ctx, docId, "update user", []firestore.Update{
+		{Path: "FirstName", Value: firstName},
+	})
+}
 
-    return nil
+func (a *Account) UpdateLastName(ctx context.Context, docId, lastName string) error {
+	return a.updateUser(ctx, docId, "update user", []firestore.Update{
+		{Path: "LastName", Value: lastName},
+	})
 }
 
-func (*Account) UpdateLastName(docId, lastName string) error {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return err
-    }
-    defer client.Close()
+func (a *Account) UpdatePassword(ctx context.Context, docId, password string, changedAt time.Time) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
 
-    accountRef := client.Collection(globals.UsersCollectionName).Doc(docId)
+	return a.updateUser(ctx, docId, "update password", []firestore.Update{
+		{Path: "Password", Value: string(hashedPassword)},
+		{Path: "PasswordChangedAt", Value: changedAt},
+	})
+}
 
-    _, err = accountRef.Update(ctx, []firestore.Update{
-        {Path: "LastName", Value: lastName},
-    })
+func (a *Account) EnableTOTP(ctx context.Context, docId, secret string, recoveryCodes []string) error {
+	return a.updateUser(ctx, docId, "enable two-factor login", []firestore.Update{
+		{Path: "TOTPSecret", Value: secret},
+		{Path: "TOTPLastStep", Value: 0},
+		{Path: "RecoveryCodes", Value: recoveryCodes},
+	})
+}
 
-    if err != nil {
-        log.Fatalf("Failed updating user: %v", err)
-        return err
-    }
+func (a *Account) DisableTOTP(ctx context.Context, docId string) error {
+	return a.updateUser(ctx, docId, "disable two-factor login", []firestore.Update{
+		{Path: "TOTPSecret", Value: ""},
+		{Path: "TOTPLastStep", Value: 0},
+		{Path: "RecoveryCodes", Value: []string{}},
+	})
+}
 
-    return nil
+// UseTOTPStep and UseRecoveryCode check and update in one transaction, so
+// two logins racing with the same code cannot both succeed.
+func (a *Account) UseTOTPStep(ctx context.Context, docId string, step int64) error {
+	return a.useCode(ctx, docId, func(user *models.User) ([]firestore.Update, error) {
+		if step <= user.TOTPLastStep {
+			return nil, repository.ErrInvalidCode
+		}
+		return []firestore.Update{{Path: "TOTPLastStep", Value: step}}, nil
+	})
 }
 
-func getDocumentIdByUuid(uuid string) (string, error) {
-    ctx := context.Background()
-    client, err := getFirebaseUserClient(ctx)
-    if err != nil {
-        log.Fatalf("Failed to create client: %v", err)
-        return "", err
-    }
-    defer client.Close()
+func (a *Account) UseRecoveryCode(ctx context.Context, docId, hash string) error {
+	return a.useCode(ctx, docId, func(user *models.User) ([]firestore.Update, error) {
+		remaining := without(user.RecoveryCodes, hash)
+		if len(remaining) == len(user.RecoveryCodes) {
+			return nil, repository.ErrInvalidCode
+		}
+		return []firestore.Update{{Path: "RecoveryCodes", Value: remaining}}, nil
+	})
+}
 
-    collection := client.Collection(globals.UsersCollectionName)
-    query := collection.Where("Id", "==", uuid).Limit(1)
+func (a *Account) useCode(ctx context.Context, docId string, check func(*models.User) ([]firestore.Update, error)) error {
+	accountRef := a.client.Collection(a.collection).Doc(docId)
+
+	err := a.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(accountRef)
+		if err != nil {
+			return err
+		}
+		var user models.User
+		if err := snapshot.DataTo(&user); err != nil {
+			return backendError("decode user", err)
+		}
+
+		updates, err := check(&user)
+		if err != nil {
+			return err
+		}
+		return tx.Update(accountRef, updates)
+	})
+	a.cache.forget(docId)
+	if err != nil {
+		return accountError("use two-factor code", err)
+	}
 
-    docs, err := query.Documents(ctx).GetAll()
-    if err != nil {
-        log.Fatalf("Failed to get user: %v", err)
-        return "", err
-    }
+	return nil
+}
 
-    if len(docs) == 0 {
-        return "", fmt.Errorf("User not found")
-    }
+// updateUser applies updates to the account and drops it from the cache,
+// which sessions and logins are checked against.
+func (a *Account) updateUser(ctx context.Context, docId string, op string, updates []firestore.Update) error {
+	_, err := a.client.Collection(a.collection).Doc(docId).Update(ctx, updates)
+	a.cache.forget(docId)
+	if err != nil {
+		return accountError(op, err)
+	}
 
-    return docs[0].Ref.ID, nil
+	return nil
+}
+
+// UpdateUsername claims the new username, points the old one at the account
+// until redirectUntil and renames the account, all in one transaction.
+func (a *Account) UpdateUsername(ctx context.Context, docId, username string, redirectUntil time.Time) error {
+	accountRef := a.client.Collection(a.collection).Doc(docId)
+
+	err := a.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(accountRef)
+		if err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := snapshot.DataTo(&user); err != nil {
+			return backendError("decode user", err)
+		}
+		if user.Username == username {
+			return nil
+		}
+
+		if err := a.claim(tx, username, user.Id); err != nil {
+			return err
+		}
+
+		if user.Username != "" {
+			old := &models.Handle{Username: user.Username, UserId: user.Id, RedirectUntil: &redirectUntil}
+			if err := tx.Set(a.client.Collection(a.handles).Doc(user.Username), handleFields(old)); err != nil {
+				return err
+			}
+		}
+
+		return tx.Update(accountRef, []firestore.Update{
+			{Path: "Username", Value: username},
+		})
+	})
+	a.cache.forget(docId)
+	if err != nil {
+		return accountError("update username", err)
+	}
+
+	return nil
+}
+
+func (a *Account) EachAccount(ctx context.Context, fn func(*models.User) error) error {
+	docs := a.client.Collection(a.collection).Documents(ctx)
+	defer docs.Stop()
+
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return backendError("list accounts", err)
+		}
+
+		var user models.User
+		if err := doc.DataTo(&user); err != nil {
+			return backendError("decode user", err)
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes each message to its own .eml file in Dir instead of
+// sending it, for development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+
+	sent atomic.Int64
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, message Message) error {
+	data, err := format(m.From, message)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + strconv.FormatInt(m.sent.Add(1), 10) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
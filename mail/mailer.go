@@ -0,0 +1,16 @@
+// Package mail sends the emails the site needs, such as password reset
+// links, through whichever Mailer the server was configured with.
+package mail
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	// Body is plain text.
+	Body string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
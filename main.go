@@ -1,50 +1,167 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"posts/config"
+	"posts/firebase"
+	"posts/mail"
+	"posts/memory"
+	"posts/notifications"
+	"posts/pubsub"
+	"posts/ratelimit"
+	"posts/repository"
 	"posts/routes"
+	"posts/search"
+	"posts/sessionstore"
+	"time"
 
-	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 )
 
-func main() {
-	router := mux.NewRouter()
-
-	router.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir("public"))))
-
-	router.HandleFunc("/media", routes.ServeIndex)
-
-	router.HandleFunc("/", routes.SignupHandler)
-
-	router.HandleFunc("/signup", routes.SignupHandler)
-
-	router.HandleFunc("/login", routes.LoginHandler)
-
-    router.HandleFunc("/profiles/{id}", routes.ProfileHandler).Methods("GET")
-
-    router.HandleFunc("/settings/edit-profile", routes.EditProfileHandler).Methods("GET")
-
-	router.HandleFunc("/api/add-post", routes.AddPost).Methods("POST")
-
-	router.HandleFunc("/api/posts", routes.GetPosts).Methods("GET")
-
-    router.HandleFunc("/api/profile-details", routes.GetProfileDetailsOnMediaPage).Methods("GET")
-
-	router.HandleFunc("/api/signup", routes.SignupAfterCheckingTheDatabase).Methods("POST")
-
-	router.HandleFunc("/api/login", routes.LoginAfterCheckingTheDatabase).Methods("POST")
-
-    router.HandleFunc("/api/users/{userId}/follow", routes.FollowUser).Methods("POST")
+// openRepositories returns the repositories for the configured backend and a
+// function that releases whatever they hold open.
+func openRepositories(ctx context.Context, cfg *config.Config) (*repository.Repositories, func(), error) {
+	switch cfg.Storage {
+	case "firestore":
+		client, err := firebase.NewClient(ctx, cfg.ProjectId, cfg.CredentialsPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		repos := &repository.Repositories{
+			Accounts:      firebase.NewAccount(client, cfg.Collections.Users, cfg.Collections.Usernames),
+			Posts:         firebase.NewPosts(client, cfg.Collections.Posts),
+			Likes:         firebase.NewLikes(client, cfg.Collections.Likes, cfg.Collections.Posts),
+			Notifications: firebase.NewNotifications(client, cfg.Collections.Notifications),
+			Messages:      firebase.NewMessages(client, cfg.Collections.Conversations),
+			Tokens:        firebase.NewTokens(client, cfg.Collections.Tokens),
+			Sessions:      firebase.NewSessions(client, cfg.Collections.Sessions),
+		}
+		return repos, func() { client.Close() }, nil
+	case "memory":
+		return memory.New().Repositories(), func() {}, nil
+	case "file":
+		store, err := memory.Open(cfg.DataPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store.Repositories(), func() { store.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+	}
+}
 
-    router.HandleFunc("/api/users/{userId}/unfollow", routes.UnfollowUser).Methods("POST")
+// openSearchIndex loads the saved search index, building it from storage
+// when asked to, when there is none yet, or when storage is in memory and so
+// starts empty every time.
+func openSearchIndex(ctx context.Context, cfg *config.Config, repos *repository.Repositories, rebuild bool) (*search.InvertedIndex, error) {
+	index, err := search.Open(cfg.SearchIndexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if rebuild || index.Empty() || cfg.Storage == "memory" {
+		if err := index.Rebuild(ctx, repos.Accounts, repos.Posts); err != nil {
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
 
-	router.HandleFunc("/api/logout", routes.Logout)
+func newMailer(cfg *config.Config) mail.Mailer {
+	if cfg.Mail.Backend == "smtp" {
+		return mail.NewSMTPMailer(cfg.Mail.SMTPAddr, cfg.Mail.From, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword)
+	}
+	return mail.NewFileMailer(cfg.Mail.Dir, cfg.Mail.From)
+}
 
-    router.HandleFunc("/api/posts/{userId}", routes.GetProfilePosts).Methods("GET")
+// newSessionStore keeps sessions in the same storage as everything else.
+func newSessionStore(cfg *config.Config, repos *repository.Repositories) *sessionstore.Store {
+	return sessionstore.New(repos.Sessions, sessions.Options{
+		Path:     "/",
+		MaxAge:   cfg.Cookie.MaxAge,
+		Secure:   cfg.Cookie.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}, []byte(cfg.SessionSecret))
+}
 
-    router.HandleFunc("/api/settings/edit-profile", routes.EditProfile).Methods("POST")
+// streamHistory is how many live events are kept for clients that
+// reconnect to /api/stream.
+const streamHistory = 1024
 
-	fmt.Println("Server listening on port 8000")
-	http.ListenAndServe(":8000", router)
+func main() {
+	configPath := flag.String("config", "", "optional JSON config file; environment variables override it")
+	reindex := flag.Bool("reindex", false, "rebuild the search index from storage and exit")
+	backfill := flag.Bool("backfill-posts", false, "give Firestore posts from before post ids and timestamps the fields feeds need, and exit")
+	backfillUsers := flag.Bool("backfill-users", false, "mark Firestore accounts from before email verification as verified, and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	repos, closeStorage, err := openRepositories(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer closeStorage()
+
+	if *backfill {
+		posts, ok := repos.Posts.(*firebase.Posts)
+		if !ok {
+			log.Fatalf("Backfilling posts needs firestore storage, not %s", cfg.Storage)
+		}
+		count, err := posts.BackfillPosts(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to backfill posts after %d: %v", count, err)
+		}
+		fmt.Println("Backfilled", count, "posts")
+		return
+	}
+
+	if *backfillUsers {
+		accounts, ok := repos.Accounts.(*firebase.Account)
+		if !ok {
+			log.Fatalf("Backfilling users needs firestore storage, not %s", cfg.Storage)
+		}
+		count, err := accounts.BackfillUsers(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to backfill users after %d: %v", count, err)
+		}
+		fmt.Println("Backfilled", count, "users")
+		return
+	}
+
+	index, err := openSearchIndex(context.Background(), cfg, repos, *reindex)
+	if err != nil {
+		log.Fatalf("Failed to open search index: %v", err)
+	}
+	defer index.Close()
+	if *reindex {
+		fmt.Println("Rebuilt search index at", cfg.SearchIndexPath)
+		return
+	}
+
+	server, err := routes.NewServer(repos, notifications.NewInbox(repos.Notifications), pubsub.NewHub(streamHistory), index, newMailer(cfg), ratelimit.NewMemoryStore(), newSessionStore(cfg, repos), cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	// There is no WriteTimeout, as it would cut off /api/stream; slow
+	// clients are bounded by the header and idle timeouts instead.
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           server.NewRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	fmt.Println("Server listening on", cfg.Addr)
+	log.Fatal(srv.ListenAndServe())
 }
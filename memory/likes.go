@@ -0,0 +1,58 @@
+package memory
+
+import "context"
+
+type Likes struct {
+	store *Store
+}
+
+func (l *Likes) Like(ctx context.Context, postId string, userId string) (int, error) {
+	return l.set(postId, userId, true)
+}
+
+func (l *Likes) Unlike(ctx context.Context, postId string, userId string) (int, error) {
+	return l.set(postId, userId, false)
+}
+
+func (l *Likes) set(postId string, userId string, liked bool) (int, error) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+
+	post, err := l.store.Posts().find(postId)
+	if err != nil {
+		return 0, err
+	}
+
+	users := l.store.likes[postId]
+	if users[userId] == liked {
+		return post.LikeCount, nil
+	}
+
+	if liked {
+		if users == nil {
+			users = make(map[string]bool)
+			l.store.likes[postId] = users
+		}
+		users[userId] = true
+		post.LikeCount++
+	} else {
+		delete(users, userId)
+		post.LikeCount--
+	}
+
+	return post.LikeCount, l.store.save(likeWrite(postId, userId, liked), postWrite(post))
+}
+
+func (l *Likes) LikedPostIds(ctx context.Context, userId string, postIds []string) (map[string]bool, error) {
+	l.store.mu.RLock()
+	defer l.store.mu.RUnlock()
+
+	liked := make(map[string]bool)
+	for _, postId := range postIds {
+		if l.store.likes[postId][userId] {
+			liked[postId] = true
+		}
+	}
+
+	return liked, nil
+}
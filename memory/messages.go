@@ -0,0 +1,304 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Messages struct {
+	store *Store
+}
+
+func copyConversation(conversation *models.Conversation) *models.Conversation {
+	c := *conversation
+	c.MemberIds = append([]string(nil), conversation.MemberIds...)
+	c.AdminIds = append([]string(nil), conversation.AdminIds...)
+	if conversation.JoinedAt != nil {
+		c.JoinedAt = make(map[string]time.Time, len(conversation.JoinedAt))
+		for userId, at := range conversation.JoinedAt {
+			c.JoinedAt[userId] = at
+		}
+	}
+	c.ReadAt = make(map[string]time.Time, len(conversation.ReadAt))
+	for userId, at := range conversation.ReadAt {
+		c.ReadAt[userId] = at
+	}
+	c.Unread = make(map[string]int, len(conversation.Unread))
+	for userId, count := range conversation.Unread {
+		c.Unread[userId] = count
+	}
+	if conversation.LastMessage != nil {
+		last := *conversation.LastMessage
+		c.LastMessage = &last
+	}
+	return &c
+}
+
+func (m *Messages) CreateDirectConversation(ctx context.Context, userId string, otherId string) (*models.Conversation, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	id := models.DirectConversationId(userId, otherId)
+	if conversation, ok := m.store.conversations[id]; ok {
+		return copyConversation(conversation), nil
+	}
+
+	now := time.Now().UTC()
+	conversation := &models.Conversation{
+		Id:        id,
+		Kind:      models.DirectConversation,
+		MemberIds: []string{userId, otherId},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ReadAt:    map[string]time.Time{},
+		Unread:    map[string]int{},
+	}
+	m.store.conversations[id] = conversation
+
+	return copyConversation(conversation), m.store.save(conversationWrite(conversation))
+}
+
+func (m *Messages) CreateGroupConversation(ctx context.Context, ownerId string, name string, memberIds []string) (*models.Conversation, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	now := time.Now().UTC()
+	conversation := &models.Conversation{
+		Id:        uuid.New().String(),
+		Kind:      models.GroupConversation,
+		Name:      name,
+		OwnerId:   ownerId,
+		MemberIds: []string{ownerId},
+		JoinedAt:  map[string]time.Time{ownerId: now},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ReadAt:    map[string]time.Time{},
+		Unread:    map[string]int{},
+	}
+	addMembers(conversation, memberIds, now)
+	m.store.conversations[conversation.Id] = conversation
+
+	return copyConversation(conversation), m.store.save(conversationWrite(conversation))
+}
+
+// newMembers returns the users who are not in conversation yet, without
+// repeats.
+func newMembers(conversation *models.Conversation, userIds []string) []string {
+	seen := make(map[string]bool, len(userIds))
+	var added []string
+	for _, userId := range userIds {
+		if !seen[userId] && !conversation.HasMember(userId) {
+			seen[userId] = true
+			added = append(added, userId)
+		}
+	}
+	return added
+}
+
+func addMembers(conversation *models.Conversation, userIds []string, at time.Time) {
+	if conversation.JoinedAt == nil {
+		conversation.JoinedAt = make(map[string]time.Time)
+	}
+	for _, userId := range newMembers(conversation, userIds) {
+		conversation.MemberIds = append(conversation.MemberIds, userId)
+		conversation.JoinedAt[userId] = at
+		conversation.Unread[userId] = 0
+	}
+}
+
+func without(ids []string, id string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+func (m *Messages) AddMembers(ctx context.Context, conversationId string, userIds []string, maxMembers int) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	conversation, ok := m.store.conversations[conversationId]
+	if !ok {
+		return repository.ErrConversationNotFound
+	}
+	if len(conversation.MemberIds)+len(newMembers(conversation, userIds)) > maxMembers {
+		return repository.ErrGroupFull
+	}
+
+	addMembers(conversation, userIds, time.Now().UTC())
+
+	return m.store.save(conversationWrite(conversation))
+}
+
+func (m *Messages) RemoveMember(ctx context.Context, conversationId string, userId string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	conversation, ok := m.store.conversations[conversationId]
+	if !ok {
+		return repository.ErrConversationNotFound
+	}
+
+	conversation.MemberIds = without(conversation.MemberIds, userId)
+	conversation.AdminIds = without(conversation.AdminIds, userId)
+	delete(conversation.JoinedAt, userId)
+	delete(conversation.ReadAt, userId)
+	delete(conversation.Unread, userId)
+	if conversation.OwnerId == userId {
+		conversation.OwnerId = conversation.NextOwner()
+		conversation.AdminIds = without(conversation.AdminIds, conversation.OwnerId)
+	}
+
+	return m.store.save(conversationWrite(conversation))
+}
+
+func (m *Messages) SetAdmin(ctx context.Context, conversationId string, userId string, admin bool) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	conversation, ok := m.store.conversations[conversationId]
+	if !ok {
+		return repository.ErrConversationNotFound
+	}
+
+	conversation.AdminIds = without(conversation.AdminIds, userId)
+	if admin {
+		conversation.AdminIds = append(conversation.AdminIds, userId)
+	}
+
+	return m.store.save(conversationWrite(conversation))
+}
+
+func (m *Messages) GetConversation(ctx context.Context, conversationId string) (*models.Conversation, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	conversation, ok := m.store.conversations[conversationId]
+	if !ok {
+		return nil, repository.ErrConversationNotFound
+	}
+
+	return copyConversation(conversation), nil
+}
+
+func (m *Messages) ListConversations(ctx context.Context, userId string, page repository.PageRequest) (*repository.ConversationPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	var conversations []*models.Conversation
+	for _, conversation := range m.store.conversations {
+		if conversation.HasMember(userId) && cursor.Precedes(conversation.UpdatedAt, conversation.Id) {
+			conversations = append(conversations, copyConversation(conversation))
+		}
+	}
+
+	sort.Slice(conversations, func(i, j int) bool {
+		a, b := conversations[i], conversations[j]
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+		return a.Id > b.Id
+	})
+	if len(conversations) > page.Limit+1 {
+		conversations = conversations[:page.Limit+1]
+	}
+
+	return repository.NewConversationPage(conversations, page.Limit), nil
+}
+
+func (m *Messages) AddMessage(ctx context.Context, message *models.Message) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	conversation, ok := m.store.conversations[message.ConversationId]
+	if !ok {
+		return repository.ErrConversationNotFound
+	}
+
+	message.Id = uuid.New().String()
+	message.CreatedAt = time.Now().UTC()
+
+	stored := *message
+	m.store.messages[conversation.Id] = append(m.store.messages[conversation.Id], &stored)
+
+	last := stored
+	conversation.LastMessage = &last
+	conversation.UpdatedAt = message.CreatedAt
+	for _, memberId := range conversation.MemberIds {
+		if memberId == message.SenderId {
+			conversation.ReadAt[memberId] = message.CreatedAt
+			conversation.Unread[memberId] = 0
+		} else {
+			conversation.Unread[memberId]++
+		}
+	}
+
+	return m.store.save(messageWrite(&stored), conversationWrite(conversation))
+}
+
+func (m *Messages) ListMessages(ctx context.Context, conversationId string, since time.Time, page repository.PageRequest) (*repository.MessagePage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	stored := m.store.messages[conversationId]
+
+	var messages []*models.Message
+	for i := len(stored) - 1; i >= 0 && len(messages) <= page.Limit; i-- {
+		if stored[i].CreatedAt.Before(since) {
+			break
+		}
+		if cursor.Precedes(stored[i].CreatedAt, stored[i].Id) {
+			message := *stored[i]
+			messages = append(messages, &message)
+		}
+	}
+
+	return repository.NewMessagePage(messages, page.Limit), nil
+}
+
+func (m *Messages) MarkConversationRead(ctx context.Context, conversationId string, userId string, at time.Time) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	conversation, ok := m.store.conversations[conversationId]
+	if !ok {
+		return repository.ErrConversationNotFound
+	}
+
+	conversation.ReadAt[userId] = at
+	conversation.Unread[userId] = 0
+
+	return m.store.save(conversationWrite(conversation))
+}
+
+func (m *Messages) UnreadMessageCount(ctx context.Context, userId string) (int, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	count := 0
+	for _, conversation := range m.store.conversations {
+		if conversation.HasMember(userId) {
+			count += conversation.Unread[userId]
+		}
+	}
+
+	return count, nil
+}
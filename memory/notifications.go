@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Notifications struct {
+	store *Store
+}
+
+func copyNotification(notification *models.Notification) *models.Notification {
+	c := *notification
+	c.ActorIds = append([]string(nil), notification.ActorIds...)
+	return &c
+}
+
+func (n *Notifications) Record(ctx context.Context, recipientId string, kind string, postId string, actorId string, at time.Time) error {
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+
+	if _, notification := n.unread(recipientId, kind, postId); notification != nil {
+		notification.AddActor(actorId)
+		notification.UpdatedAt = at
+		return n.store.save(notificationWrite(notification))
+	}
+
+	notification := &models.Notification{
+		Id:          uuid.New().String(),
+		RecipientId: recipientId,
+		Kind:        kind,
+		PostId:      postId,
+		ActorIds:    []string{actorId},
+		ActorCount:  1,
+		CreatedAt:   at,
+		UpdatedAt:   at,
+	}
+	n.store.notifications[recipientId] = append(n.store.notifications[recipientId], notification)
+
+	return n.store.save(notificationWrite(notification))
+}
+
+func (n *Notifications) Retract(ctx context.Context, recipientId string, kind string, postId string, actorId string) error {
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+
+	i, notification := n.unread(recipientId, kind, postId)
+	if notification == nil || !notification.RemoveActor(actorId) {
+		return nil
+	}
+	if notification.ActorCount > 0 {
+		return n.store.save(notificationWrite(notification))
+	}
+
+	notifications := n.store.notifications[recipientId]
+	n.store.notifications[recipientId] = append(notifications[:i:i], notifications[i+1:]...)
+	return n.store.save(write{notificationsBucket, notification.Id, nil})
+}
+
+// unread finds the recipient's unread notification of kind about postId and
+// its place in their list. Callers must hold the store's lock.
+func (n *Notifications) unread(recipientId string, kind string, postId string) (int, *models.Notification) {
+	for i, notification := range n.store.notifications[recipientId] {
+		if notification.Kind == kind && notification.PostId == postId && notification.ReadAt == nil {
+			return i, notification
+		}
+	}
+	return -1, nil
+}
+
+func (n *Notifications) List(ctx context.Context, recipientId string, page repository.PageRequest) (*repository.NotificationPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	n.store.mu.RLock()
+	defer n.store.mu.RUnlock()
+
+	var notifications []*models.Notification
+	for _, notification := range n.store.notifications[recipientId] {
+		if cursor.Precedes(notification.UpdatedAt, notification.Id) {
+			notifications = append(notifications, copyNotification(notification))
+		}
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+		return a.Id > b.Id
+	})
+	if len(notifications) > page.Limit+1 {
+		notifications = notifications[:page.Limit+1]
+	}
+
+	return repository.NewNotificationPage(notifications, page.Limit), nil
+}
+
+func (n *Notifications) UnreadCount(ctx context.Context, recipientId string) (int, error) {
+	n.store.mu.RLock()
+	defer n.store.mu.RUnlock()
+
+	count := 0
+	for _, notification := range n.store.notifications[recipientId] {
+		if notification.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (n *Notifications) MarkRead(ctx context.Context, recipientId string, ids []string, at time.Time) error {
+	n.store.mu.Lock()
+	defer n.store.mu.Unlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var writes []write
+	for _, notification := range n.store.notifications[recipientId] {
+		if notification.ReadAt != nil {
+			continue
+		}
+		if len(ids) == 0 || wanted[notification.Id] {
+			readAt := at
+			notification.ReadAt = &readAt
+			writes = append(writes, notificationWrite(notification))
+		}
+	}
+
+	return n.store.save(writes...)
+}
@@ -0,0 +1,242 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Posts struct {
+	store *Store
+}
+
+func (p *Posts) AddPost(ctx context.Context, post *models.Post, author string, authorId string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	return p.store.save(postWrite(p.add(post, author, authorId)))
+}
+
+func (p *Posts) AddRepost(ctx context.Context, post *models.Post, author string, authorId string) (bool, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	for _, stored := range p.store.posts {
+		if stored.AuthorId == authorId && stored.RepostOf == post.RepostOf && stored.DeletedAt == nil {
+			*post = *stored
+			return false, nil
+		}
+	}
+
+	return true, p.store.save(postWrite(p.add(post, author, authorId)))
+}
+
+func (p *Posts) AddReply(ctx context.Context, reply *models.Post, parentId string, author string, authorId string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	parent, err := p.find(parentId)
+	if err != nil {
+		return err
+	}
+
+	reply.ParentId = parent.Id
+	reply.RootId = parent.RootId
+	if reply.RootId == "" {
+		reply.RootId = parent.Id
+	}
+	parent.ReplyCount++
+
+	return p.store.save(postWrite(parent), postWrite(p.add(reply, author, authorId)))
+}
+
+// add keeps a new post and returns the stored copy for the caller to save.
+// Callers must hold p.store.mu.
+func (p *Posts) add(post *models.Post, author string, authorId string) *models.Post {
+	now := time.Now().UTC()
+
+	post.Id = uuid.New().String()
+	post.Author = author
+	post.AuthorId = authorId
+	post.CreatedAt = now
+	post.UpdatedAt = now
+	post.Tags = post.Entities.TagNames()
+
+	stored := *post
+	p.store.posts = append(p.store.posts, &stored)
+
+	return &stored
+}
+
+func (p *Posts) GetPost(ctx context.Context, postId string) (*models.Post, error) {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+
+	stored, err := p.find(postId)
+	if err != nil {
+		return nil, err
+	}
+
+	post := *stored
+	return &post, nil
+}
+
+func (p *Posts) GetPostsByIds(ctx context.Context, postIds []string) (map[string]*models.Post, error) {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+
+	posts := make(map[string]*models.Post, len(postIds))
+	for _, postId := range postIds {
+		if stored, err := p.find(postId); err == nil {
+			post := *stored
+			posts[postId] = &post
+		}
+	}
+
+	return posts, nil
+}
+
+func (p *Posts) UpdatePostContent(ctx context.Context, postId string, content string, entities models.Entities) (*models.Post, error) {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	stored, err := p.find(postId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	stored.Content = content
+	stored.Entities = entities
+	stored.Tags = entities.TagNames()
+	stored.UpdatedAt = now
+	stored.EditedAt = &now
+
+	if err := p.store.save(postWrite(stored)); err != nil {
+		return nil, err
+	}
+
+	post := *stored
+	return &post, nil
+}
+
+func (p *Posts) DeletePost(ctx context.Context, postId string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	stored, err := p.find(postId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	stored.DeletedAt = &now
+	writes := []write{postWrite(stored)}
+
+	if stored.ParentId != "" {
+		if parent, err := p.find(stored.ParentId); err == nil {
+			parent.ReplyCount--
+			writes = append(writes, postWrite(parent))
+		}
+	}
+
+	return p.store.save(writes...)
+}
+
+// find returns the live post with postId. Callers must hold p.store.mu.
+func (p *Posts) find(postId string) (*models.Post, error) {
+	for _, stored := range p.store.posts {
+		if stored.Id == postId && stored.DeletedAt == nil {
+			return stored, nil
+		}
+	}
+	return nil, repository.ErrPostNotFound
+}
+
+func (p *Posts) ListPosts(ctx context.Context, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(page, func(post *models.Post) bool { return post.ParentId == "" })
+}
+
+func (p *Posts) ListPostsByAuthors(ctx context.Context, authorIds []string, page repository.PageRequest) (*repository.PostPage, error) {
+	authors := make(map[string]bool, len(authorIds))
+	for _, id := range authorIds {
+		authors[id] = true
+	}
+	return p.list(page, func(post *models.Post) bool { return post.ParentId == "" && authors[post.AuthorId] })
+}
+
+func (p *Posts) ListPostsByAuthor(ctx context.Context, authorId string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(page, func(post *models.Post) bool { return post.ParentId == "" && post.AuthorId == authorId })
+}
+
+func (p *Posts) ListPostsByTag(ctx context.Context, tag string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(page, func(post *models.Post) bool { return contains(post.Tags, tag) })
+}
+
+func (p *Posts) ListThread(ctx context.Context, rootId string, page repository.PageRequest) (*repository.PostPage, error) {
+	return p.list(page, func(post *models.Post) bool { return post.RootId == rootId })
+}
+
+// list returns the posts matching keep in feed order, resuming after
+// page.Cursor.
+func (p *Posts) list(page repository.PageRequest, keep func(post *models.Post) bool) (*repository.PostPage, error) {
+	cursor, err := repository.DecodeCursor(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+
+	var matched []*models.Post
+	for _, stored := range p.store.posts {
+		if stored.DeletedAt == nil && keep(stored) && cursor.After(stored) {
+			matched = append(matched, stored)
+		}
+	}
+
+	repository.SortPosts(matched)
+
+	if len(matched) > page.Limit+1 {
+		matched = matched[:page.Limit+1]
+	}
+
+	posts := make([]*models.Post, 0, len(matched))
+	for _, stored := range matched {
+		post := *stored
+		posts = append(posts, &post)
+	}
+
+	return repository.NewPostPage(posts, page.Limit), nil
+}
+
+func (p *Posts) EachPost(ctx context.Context, fn func(*models.Post) error) error {
+	p.store.mu.RLock()
+	posts := make([]*models.Post, 0, len(p.store.posts))
+	for _, stored := range p.store.posts {
+		if stored.DeletedAt == nil {
+			post := *stored
+			posts = append(posts, &post)
+		}
+	}
+	p.store.mu.RUnlock()
+
+	for _, post := range posts {
+		if err := fn(post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
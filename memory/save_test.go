@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"posts/models"
+	"testing"
+)
+
+func TestFailedSaveLeavesTheStoreAsOnDisk(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	user := &models.User{Email: "alice@example.com", Password: "secret", FirstName: "Alice"}
+	if err := store.Accounts().CreateAccount(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the account the way a repository method does, then fail the
+	// write with a record Bolt refuses, as a full disk would.
+	store.mu.Lock()
+	stored := store.users[user.Id]
+	stored.FirstName = "Mallory"
+	store.posts = append(store.posts, &models.Post{Id: "unsaved"})
+	err = store.save(userWrite(stored), write{postsBucket, "", struct{}{}})
+	store.mu.Unlock()
+	if err == nil {
+		t.Fatal("save with an empty key succeeded")
+	}
+
+	found, err := store.Accounts().FindAccountByUuid(ctx, user.Id)
+	if err != nil || found.FirstName != "Alice" {
+		t.Errorf("account after a failed save = %+v, %v, want it unchanged", found, err)
+	}
+	if _, err := store.Posts().GetPost(ctx, "unsaved"); err == nil {
+		t.Error("post from a failed save is still there")
+	}
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"time"
+)
+
+type Sessions struct {
+	store *Store
+}
+
+func (s *Sessions) CreateSession(ctx context.Context, session *models.Session) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	// Nothing else clears out expired sessions, so each new one does.
+	now := time.Now()
+	var writes []write
+	for id, existing := range s.store.sessions {
+		if !now.Before(existing.ExpiresAt) {
+			delete(s.store.sessions, id)
+			writes = append(writes, sessionDeletes(id)...)
+		}
+	}
+
+	stored := *session
+	s.store.sessions[session.Id] = &stored
+
+	return s.store.save(append(writes, sessionWrite(&stored))...)
+}
+
+// live returns the session with id unless it is missing or expired.
+// Callers must hold s.store.mu.
+func (s *Sessions) live(id string) (*models.Session, bool) {
+	session, ok := s.store.sessions[id]
+	if !ok || !time.Now().Before(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *Sessions) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	session, ok := s.live(id)
+	if !ok {
+		return nil, repository.ErrSessionNotFound
+	}
+
+	found := *session
+	return &found, nil
+}
+
+func (s *Sessions) UpdateSession(ctx context.Context, id string, values []byte, expiresAt time.Time) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	session, ok := s.live(id)
+	if !ok {
+		return repository.ErrSessionNotFound
+	}
+	session.Values = values
+	session.ExpiresAt = expiresAt
+
+	return s.store.save(sessionWrite(session))
+}
+
+func (s *Sessions) TouchSession(ctx context.Context, id string, seenAt time.Time, ip string, userAgent string) error {
+	s.store.mu.Lock()
+	session, ok := s.live(id)
+	if ok {
+		session.LastSeenAt = seenAt
+		session.IP = ip
+		session.UserAgent = userAgent
+	}
+	s.store.mu.Unlock()
+
+	if !ok {
+		return repository.ErrSessionNotFound
+	}
+	return s.store.saveSeen(id, sessionSeen{LastSeenAt: seenAt, IP: ip, UserAgent: userAgent})
+}
+
+func (s *Sessions) GetUserSessions(ctx context.Context, userId string) ([]*models.Session, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	sessions := []*models.Session{}
+	for id, session := range s.store.sessions {
+		if _, ok := s.live(id); ok && session.UserId == userId {
+			found := *session
+			sessions = append(sessions, &found)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Sessions) DeleteSession(ctx context.Context, id string) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	if _, ok := s.store.sessions[id]; !ok {
+		return repository.ErrSessionNotFound
+	}
+	delete(s.store.sessions, id)
+
+	return s.store.save(sessionDeletes(id)...)
+}
+
+func (s *Sessions) DeleteUserSessions(ctx context.Context, userId string, except string) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	var writes []write
+	for id, session := range s.store.sessions {
+		if session.UserId == userId && id != except {
+			delete(s.store.sessions, id)
+			writes = append(writes, sessionDeletes(id)...)
+		}
+	}
+
+	return s.store.save(writes...)
+}
@@ -0,0 +1,385 @@
+// Package memory implements the repository interfaces without any external
+// service. A Store created with New keeps everything in process memory; one
+// created with Open also writes each change to a BoltDB file, one record at
+// a time, so the data survives a restart.
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type Store struct {
+	mu sync.RWMutex
+	// db is where changes are written, or nil for a Store kept only in
+	// memory. Reads are always served from the maps below, which Open
+	// fills from it.
+	db    *bolt.DB
+	users map[string]*models.User
+	// handles maps every reserved username to its reservation.
+	handles map[string]*models.Handle
+	posts   []*models.Post
+	// likes maps a post id to the set of users who liked it.
+	likes map[string]map[string]bool
+	// notifications maps a recipient's id to their notifications, oldest
+	// first.
+	notifications map[string][]*models.Notification
+	conversations map[string]*models.Conversation
+	// messages maps a conversation id to its messages, oldest first.
+	messages map[string][]*models.Message
+	// tokens maps a token's hash to it.
+	tokens map[string]*models.Token
+	// sessions maps a session's id to it.
+	sessions map[string]*models.Session
+}
+
+// Each kind of record has its own bucket, keyed by its id. A like is keyed
+// by the post and user ids and has no value; a message is keyed by its
+// conversation id and its own.
+var (
+	usersBucket         = []byte("users")
+	handlesBucket       = []byte("handles")
+	postsBucket         = []byte("posts")
+	likesBucket         = []byte("likes")
+	notificationsBucket = []byte("notifications")
+	conversationsBucket = []byte("conversations")
+	messagesBucket      = []byte("messages")
+	tokensBucket        = []byte("tokens")
+	sessionsBucket      = []byte("sessions")
+	// seenBucket holds when and where each session was last used, apart
+	// from the session, so recording it cannot undo a change to the session.
+	seenBucket = []byte("seen")
+
+	buckets = [][]byte{
+		usersBucket, handlesBucket, postsBucket, likesBucket, notificationsBucket,
+		conversationsBucket, messagesBucket, tokensBucket, sessionsBucket, seenBucket,
+	}
+)
+
+// keySeparator joins the parts of a compound key. Ids never contain it.
+const keySeparator = "\x00"
+
+func New() *Store {
+	store := &Store{}
+	store.reset()
+	return store
+}
+
+// reset empties the store, ready for load.
+func (s *Store) reset() {
+	s.users = make(map[string]*models.User)
+	s.handles = make(map[string]*models.Handle)
+	s.posts = nil
+	s.likes = make(map[string]map[string]bool)
+	s.notifications = make(map[string][]*models.Notification)
+	s.conversations = make(map[string]*models.Conversation)
+	s.messages = make(map[string][]*models.Message)
+	s.tokens = make(map[string]*models.Token)
+	s.sessions = make(map[string]*models.Session)
+}
+
+// Open returns a Store backed by the BoltDB file at path, loading any data
+// that is already there.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	store := New()
+	store.db = db
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.View(store.load)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Close closes the file behind the store, if there is one.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// load fills the maps from the database.
+func (s *Store) load(tx *bolt.Tx) error {
+	err := eachRecord(tx, usersBucket, func(key []byte, stored *storedUser) {
+		user := &stored.User
+		user.Verified = stored.Verified == nil || *stored.Verified
+		s.users[user.Id] = user
+	})
+	if err == nil {
+		err = eachRecord(tx, handlesBucket, func(key []byte, handle *models.Handle) {
+			s.handles[handle.Username] = handle
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, postsBucket, func(key []byte, post *models.Post) {
+			s.posts = append(s.posts, post)
+		})
+	}
+	if err == nil {
+		err = tx.Bucket(likesBucket).ForEach(func(key, value []byte) error {
+			postId, userId, _ := strings.Cut(string(key), keySeparator)
+			if s.likes[postId] == nil {
+				s.likes[postId] = make(map[string]bool)
+			}
+			s.likes[postId][userId] = true
+			return nil
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, notificationsBucket, func(key []byte, notification *models.Notification) {
+			s.notifications[notification.RecipientId] = append(s.notifications[notification.RecipientId], notification)
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, conversationsBucket, func(key []byte, conversation *models.Conversation) {
+			s.conversations[conversation.Id] = conversation
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, messagesBucket, func(key []byte, message *models.Message) {
+			s.messages[message.ConversationId] = append(s.messages[message.ConversationId], message)
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, tokensBucket, func(key []byte, token *models.Token) {
+			s.tokens[token.Hash] = token
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, sessionsBucket, func(key []byte, session *models.Session) {
+			s.sessions[session.Id] = session
+		})
+	}
+	if err == nil {
+		err = eachRecord(tx, seenBucket, func(key []byte, seen *sessionSeen) {
+			if session, ok := s.sessions[string(key)]; ok && seen.LastSeenAt.After(session.LastSeenAt) {
+				session.LastSeenAt = seen.LastSeenAt
+				session.IP = seen.IP
+				session.UserAgent = seen.UserAgent
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Records come back in key order; the lists are kept oldest first.
+	sort.SliceStable(s.posts, func(i, j int) bool { return s.posts[i].CreatedAt.Before(s.posts[j].CreatedAt) })
+	for _, notifications := range s.notifications {
+		sort.SliceStable(notifications, func(i, j int) bool {
+			return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+		})
+	}
+	for _, messages := range s.messages {
+		sort.SliceStable(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+	}
+	return nil
+}
+
+// storedUser reads a user record. Accounts saved before email addresses
+// were confirmed have no Verified field; they count as verified rather than
+// being shut out of posting.
+type storedUser struct {
+	models.User
+	Verified *bool
+}
+
+func eachRecord[T any](tx *bolt.Tx, bucket []byte, fn func(key []byte, record *T)) error {
+	return tx.Bucket(bucket).ForEach(func(key, value []byte) error {
+		record := new(T)
+		if err := json.Unmarshal(value, record); err != nil {
+			return fmt.Errorf("reading %s %q: %w", bucket, key, err)
+		}
+		fn(key, record)
+		return nil
+	})
+}
+
+func (s *Store) Accounts() *Account {
+	return &Account{store: s}
+}
+
+func (s *Store) Posts() *Posts {
+	return &Posts{store: s}
+}
+
+func (s *Store) Likes() *Likes {
+	return &Likes{store: s}
+}
+
+func (s *Store) Notifications() *Notifications {
+	return &Notifications{store: s}
+}
+
+func (s *Store) Messages() *Messages {
+	return &Messages{store: s}
+}
+
+func (s *Store) Tokens() *Tokens {
+	return &Tokens{store: s}
+}
+
+func (s *Store) Sessions() *Sessions {
+	return &Sessions{store: s}
+}
+
+func (s *Store) Repositories() *repository.Repositories {
+	return &repository.Repositories{
+		Accounts:      s.Accounts(),
+		Posts:         s.Posts(),
+		Likes:         s.Likes(),
+		Notifications: s.Notifications(),
+		Messages:      s.Messages(),
+		Tokens:        s.Tokens(),
+		Sessions:      s.Sessions(),
+	}
+}
+
+// write puts one record into a bucket, or deletes it when value is nil.
+type write struct {
+	bucket []byte
+	key    string
+	value  interface{}
+}
+
+func userWrite(user *models.User) write {
+	return write{usersBucket, user.Id, user}
+}
+
+func handleWrite(handle *models.Handle) write {
+	return write{handlesBucket, handle.Username, handle}
+}
+
+func postWrite(post *models.Post) write {
+	return write{postsBucket, post.Id, post}
+}
+
+// likeWrite records that userId likes postId, or deletes that record.
+func likeWrite(postId string, userId string, liked bool) write {
+	var value interface{}
+	if liked {
+		value = struct{}{}
+	}
+	return write{likesBucket, postId + keySeparator + userId, value}
+}
+
+func notificationWrite(notification *models.Notification) write {
+	return write{notificationsBucket, notification.Id, notification}
+}
+
+func conversationWrite(conversation *models.Conversation) write {
+	return write{conversationsBucket, conversation.Id, conversation}
+}
+
+func messageWrite(message *models.Message) write {
+	return write{messagesBucket, message.ConversationId + keySeparator + message.Id, message}
+}
+
+func tokenWrite(token *models.Token) write {
+	return write{tokensBucket, token.Hash, token}
+}
+
+func sessionWrite(session *models.Session) write {
+	return write{sessionsBucket, session.Id, session}
+}
+
+// sessionDeletes deletes the session and when it was last seen.
+func sessionDeletes(id string) []write {
+	return []write{{sessionsBucket, id, nil}, {seenBucket, id, nil}}
+}
+
+// sessionSeen is what TouchSession changes.
+type sessionSeen struct {
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
+}
+
+// save writes the records a change touched, all or none of them. Callers
+// must hold s.mu, so changes reach the file in the order they were made.
+// The change is already in the maps by then, so if it cannot be written
+// they are loaded again from the file, which it never reached.
+func (s *Store) save(writes ...write) error {
+	if s.db == nil || len(writes) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error { return apply(tx, writes) })
+	if err != nil {
+		s.reset()
+		if loadErr := s.db.View(s.load); loadErr != nil {
+			return errors.Join(err, fmt.Errorf("reloading after a failed write: %w", loadErr))
+		}
+	}
+	return err
+}
+
+// saveSeen records when a session was last used. That happens on many
+// requests and matters little, so concurrent calls share one commit and
+// callers must not hold s.mu, or there would be nothing to share.
+func (s *Store) saveSeen(id string, seen sessionSeen) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		// The session may have been deleted since.
+		if tx.Bucket(sessionsBucket).Get([]byte(id)) == nil {
+			return nil
+		}
+		return apply(tx, []write{{seenBucket, id, seen}})
+	})
+}
+
+func apply(tx *bolt.Tx, writes []write) error {
+	for _, w := range writes {
+		bucket := tx.Bucket(w.bucket)
+		if w.value == nil {
+			if err := bucket.Delete([]byte(w.key)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := json.Marshal(w.value)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(w.key), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,569 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"posts/memory"
+	"posts/models"
+	"posts/repository"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// reopen closes store and opens the file at path again, as a restart would.
+func reopen(t *testing.T, store *memory.Store, path string) *memory.Store {
+	t.Helper()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := memory.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+func openTemp(t *testing.T) (*memory.Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data", "store.db")
+	store, err := memory.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, path
+}
+
+func TestOpenKeepsAccountsAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	accounts := store.Accounts()
+
+	alice := &models.User{Email: "alice@example.com", Password: "secret", FirstName: "Alice"}
+	bob := &models.User{Email: "bob@example.com", Password: "secret", FirstName: "Bob"}
+	for _, user := range []*models.User{alice, bob} {
+		if err := accounts.CreateAccount(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := accounts.AddFollower(ctx, alice.Id, bob.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.UpdateLastName(ctx, bob.Id, "Builder"); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts = reopen(t, store, path).Accounts()
+
+	stored, err := accounts.FindAccountByUuid(ctx, bob.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastName != "Builder" || len(stored.Followers) != 1 || stored.Followers[0] != alice.Id {
+		t.Errorf("bob after a restart = %+v", stored)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("secret")) != nil {
+		t.Error("stored password is not a hash of the one given")
+	}
+
+	following, err := accounts.IsFollowing(ctx, alice.Id, bob.Id)
+	if err != nil || !following {
+		t.Errorf("IsFollowing after a restart = %v, %v", following, err)
+	}
+}
+
+func TestOpenCountsAccountsFromBeforeVerificationAsVerified(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	user := &models.User{Email: "new@example.com", Password: "secret"}
+	if err := store.Accounts().CreateAccount(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write an account the way a server from before verification did.
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).Put([]byte("old"), []byte(`{"Id":"old","Email":"old@example.com"}`))
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = memory.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if old, err := store.Accounts().FindAccountByUuid(ctx, "old"); err != nil || !old.Verified {
+		t.Errorf("account from before verification = %+v, %v, want it verified", old, err)
+	}
+	if found, err := store.Accounts().FindAccountByUuid(ctx, user.Id); err != nil || found.Verified {
+		t.Errorf("new account = %+v, %v, want it still unverified", found, err)
+	}
+}
+
+func TestOpenKeepsPostsNewestFirst(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+
+	var added []*models.Post
+	for _, content := range []string{"first", "second", "third"} {
+		post := &models.Post{Content: content}
+		if err := store.Posts().AddPost(ctx, post, "Alice", "alice"); err != nil {
+			t.Fatal(err)
+		}
+		added = append(added, post)
+	}
+
+	page, err := reopen(t, store, path).Posts().ListPosts(ctx, repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := page.Posts
+	if len(posts) != len(added) {
+		t.Fatalf("%d posts after a restart, want %d", len(posts), len(added))
+	}
+	for i, post := range posts {
+		want := added[len(added)-1-i]
+		if post.Id != want.Id || post.Content != want.Content || post.AuthorId != "alice" || !post.CreatedAt.Equal(want.CreatedAt) {
+			t.Errorf("post %d after a restart = %+v, want %+v", i, post, want)
+		}
+	}
+}
+
+func TestAccountsHandOutCopies(t *testing.T) {
+	ctx := context.Background()
+	accounts := memory.New().Accounts()
+	user := &models.User{Email: "alice@example.com", Password: "secret"}
+	if err := accounts.CreateAccount(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := accounts.FindAccountByUuid(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found.FirstName = "Mallory"
+	found.Followers = append(found.Followers, "someone")
+
+	again, _ := accounts.FindAccountByUuid(ctx, user.Id)
+	if again.FirstName != "" || len(again.Followers) != 0 {
+		t.Errorf("changing a returned user changed the stored one: %+v", again)
+	}
+}
+
+func TestAccountErrorsAreTyped(t *testing.T) {
+	ctx := context.Background()
+	accounts := memory.New().Accounts()
+	user := &models.User{Email: "alice@example.com", Password: "secret"}
+	if err := accounts.CreateAccount(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := accounts.CreateAccount(ctx, &models.User{Email: "alice@example.com", Password: "other"}); !errors.Is(err, repository.ErrUserExists) {
+		t.Errorf("second account with the same email: err = %v, want ErrUserExists", err)
+	}
+	if _, err := accounts.FindAccountByUuid(ctx, "missing"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("FindAccountByUuid(missing): err = %v, want ErrUserNotFound", err)
+	}
+	if err := accounts.AddFollower(ctx, user.Id, "missing"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("following a missing user: err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestOpenKeepsEditsAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	posts := store.Posts()
+
+	edited := &models.Post{Content: "draft"}
+	deleted := &models.Post{Content: "oops"}
+	for _, post := range []*models.Post{edited, deleted} {
+		if err := posts.AddPost(ctx, post, "Alice", "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := posts.UpdatePostContent(ctx, edited.Id, "final", models.Entities{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := posts.DeletePost(ctx, deleted.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	posts = reopen(t, store, path).Posts()
+
+	post, err := posts.GetPost(ctx, edited.Id)
+	if err != nil || post.Content != "final" || post.EditedAt == nil {
+		t.Errorf("edited post after a restart = %+v, %v", post, err)
+	}
+	if _, err := posts.GetPost(ctx, deleted.Id); !errors.Is(err, repository.ErrPostNotFound) {
+		t.Errorf("deleted post after a restart: err = %v, want ErrPostNotFound", err)
+	}
+}
+
+func TestOpenKeepsLikes(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+
+	post := &models.Post{Content: "like me"}
+	if err := store.Posts().AddPost(ctx, post, "Alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range []string{"bob", "carol", "bob"} {
+		if _, err := store.Likes().Like(ctx, post.Id, userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Likes().Unlike(ctx, post.Id, "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	store = reopen(t, store, path)
+
+	liked, err := store.Likes().LikedPostIds(ctx, "bob", []string{post.Id})
+	if err != nil || !liked[post.Id] {
+		t.Errorf("bob's like after a restart = %v, %v", liked, err)
+	}
+	liked, err = store.Likes().LikedPostIds(ctx, "carol", []string{post.Id})
+	if err != nil || liked[post.Id] {
+		t.Errorf("carol's withdrawn like after a restart = %v, %v", liked, err)
+	}
+	stored, err := store.Posts().GetPost(ctx, post.Id)
+	if err != nil || stored.LikeCount != 1 {
+		t.Errorf("post after a restart = %+v, %v, want one like", stored, err)
+	}
+}
+
+func TestOpenKeepsReplyCounts(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+
+	parent := &models.Post{Content: "question"}
+	if err := store.Posts().AddPost(ctx, parent, "Alice", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	first, second := &models.Post{Content: "yes"}, &models.Post{Content: "no"}
+	for _, reply := range []*models.Post{first, second} {
+		if err := store.Posts().AddReply(ctx, reply, parent.Id, "Bob", "bob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Posts().DeletePost(ctx, second.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := reopen(t, store, path).Posts()
+
+	stored, err := posts.GetPost(ctx, parent.Id)
+	if err != nil || stored.ReplyCount != 1 {
+		t.Errorf("parent after a restart = %+v, %v, want one reply", stored, err)
+	}
+	reply, err := posts.GetPost(ctx, first.Id)
+	if err != nil || reply.ParentId != parent.Id || reply.RootId != parent.Id {
+		t.Errorf("reply after a restart = %+v, %v", reply, err)
+	}
+}
+
+func TestOpenKeepsUsernames(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+
+	alice := &models.User{Email: "alice@example.com", Password: "secret", Username: "alice"}
+	if err := store.Accounts().CreateAccount(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Accounts().UpdateUsername(ctx, alice.Id, "alicia", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := reopen(t, store, path).Accounts()
+
+	if user, err := accounts.FindAccountByUsername(ctx, "alicia"); err != nil || user.Id != alice.Id {
+		t.Errorf("new username after a restart = %+v, %v", user, err)
+	}
+	if user, err := accounts.FindAccountByOldUsername(ctx, "alice"); err != nil || user.Id != alice.Id {
+		t.Errorf("old username after a restart = %+v, %v", user, err)
+	}
+	bob := &models.User{Email: "bob@example.com", Password: "secret", Username: "alice"}
+	if err := accounts.CreateAccount(ctx, bob); !errors.Is(err, repository.ErrUsernameTaken) {
+		t.Errorf("claiming a redirecting username after a restart: err = %v, want ErrUsernameTaken", err)
+	}
+}
+
+func TestOpenKeepsNotifications(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	notifications := store.Notifications()
+
+	now := time.Now()
+	for _, actorId := range []string{"bob", "carol"} {
+		if err := notifications.Record(ctx, "alice", "like", "post", actorId, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := notifications.Record(ctx, "alice", "follow", "", "bob", now); err != nil {
+		t.Fatal(err)
+	}
+	page, err := notifications.List(ctx, "alice", repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var follow string
+	for _, notification := range page.Notifications {
+		if notification.Kind == "follow" {
+			follow = notification.Id
+		}
+	}
+	if err := notifications.MarkRead(ctx, "alice", []string{follow}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	notifications = reopen(t, store, path).Notifications()
+
+	if unread, err := notifications.UnreadCount(ctx, "alice"); err != nil || unread != 1 {
+		t.Errorf("unread notifications after a restart = %d, %v, want 1", unread, err)
+	}
+	page, err = notifications.List(ctx, "alice", repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 2 {
+		t.Fatalf("%d notifications after a restart, want 2", len(page.Notifications))
+	}
+	for _, notification := range page.Notifications {
+		if notification.Kind == "like" && len(notification.ActorIds) != 2 {
+			t.Errorf("grouped like after a restart = %+v, want two actors", notification)
+		}
+	}
+}
+
+func TestNotificationsKeepTheNewestActorsAndCountThemAll(t *testing.T) {
+	ctx := context.Background()
+	notifications := memory.New().Notifications()
+
+	now := time.Now()
+	total := models.NotificationActorLimit + 5
+	for i := 0; i < total; i++ {
+		if err := notifications.Record(ctx, "alice", "like", "post", fmt.Sprint("fan", i), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Someone already counted only moves to the front.
+	if err := notifications.Record(ctx, "alice", "like", "post", "fan10", now); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := notifications.List(ctx, "alice", repository.PageRequest{Limit: 10})
+	if err != nil || len(page.Notifications) != 1 {
+		t.Fatalf("notifications = %v, %v, want one", page, err)
+	}
+	notification := page.Notifications[0]
+	if len(notification.ActorIds) != models.NotificationActorLimit || notification.ActorCount != total {
+		t.Errorf("notification keeps %d ids and counts %d, want %d and %d",
+			len(notification.ActorIds), notification.ActorCount, models.NotificationActorLimit, total)
+	}
+	if notification.ActorIds[0] != "fan10" || notification.ActorIds[1] != fmt.Sprint("fan", total-1) {
+		t.Errorf("newest actors = %v, want fan10 then the last fan", notification.ActorIds[:2])
+	}
+}
+
+func TestRetractTakesTheActorBackOff(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	notifications := store.Notifications()
+
+	now := time.Now()
+	for _, actorId := range []string{"bob", "carol"} {
+		if err := notifications.Record(ctx, "alice", "like", "post", actorId, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := notifications.Record(ctx, "alice", "like", "other", "bob", now); err != nil {
+		t.Fatal(err)
+	}
+	for _, retract := range []struct{ postId, actorId string }{{"post", "bob"}, {"post", "bob"}, {"other", "bob"}} {
+		if err := notifications.Retract(ctx, "alice", "like", retract.postId, retract.actorId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifications = reopen(t, store, path).Notifications()
+
+	page, err := notifications.List(ctx, "alice", repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Notifications) != 1 {
+		t.Fatalf("%d notifications after retracting, want the one carol is still on", len(page.Notifications))
+	}
+	notification := page.Notifications[0]
+	if notification.PostId != "post" || len(notification.ActorIds) != 1 || notification.ActorIds[0] != "carol" || notification.ActorCount != 1 {
+		t.Errorf("notification after retracting bob = %+v, want carol alone", notification)
+	}
+}
+
+func TestOpenKeepsMessages(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	messages := store.Messages()
+
+	conversation, err := messages.CreateDirectConversation(ctx, "alice", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"hi", "there"} {
+		if err := messages.AddMessage(ctx, &models.Message{ConversationId: conversation.Id, SenderId: "alice", Content: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages = reopen(t, store, path).Messages()
+
+	if unread, err := messages.UnreadMessageCount(ctx, "bob"); err != nil || unread != 2 {
+		t.Errorf("bob's unread messages after a restart = %d, %v, want 2", unread, err)
+	}
+	page, err := messages.ListMessages(ctx, conversation.Id, time.Time{}, repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 2 || page.Messages[0].Content != "there" || page.Messages[1].Content != "hi" {
+		t.Errorf("messages after a restart = %+v, want newest first", page.Messages)
+	}
+}
+
+func TestAddMembersStopsAtTheLimit(t *testing.T) {
+	ctx := context.Background()
+	messages := memory.New().Messages()
+
+	group, err := messages.CreateGroupConversation(ctx, "alice", "friends", []string{"bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := messages.AddMembers(ctx, group.Id, []string{"carol", "dave"}, 3); !errors.Is(err, repository.ErrGroupFull) {
+		t.Errorf("adding two to a group of two with a limit of three: err = %v, want ErrGroupFull", err)
+	}
+	// Members already in the group do not count against the limit.
+	if err := messages.AddMembers(ctx, group.Id, []string{"bob", "carol", "carol"}, 3); err != nil {
+		t.Errorf("adding one new member up to the limit: %v", err)
+	}
+
+	group, err = messages.GetConversation(ctx, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.MemberIds) != 3 || group.HasMember("dave") {
+		t.Errorf("members = %v, want alice, bob and carol", group.MemberIds)
+	}
+}
+
+func TestLateMembersSeeMessagesFromWhenTheyJoined(t *testing.T) {
+	ctx := context.Background()
+	messages := memory.New().Messages()
+
+	group, err := messages.CreateGroupConversation(ctx, "alice", "friends", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := messages.AddMessage(ctx, &models.Message{ConversationId: group.Id, SenderId: "alice", Content: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := messages.AddMembers(ctx, group.Id, []string{"bob"}, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := messages.AddMessage(ctx, &models.Message{ConversationId: group.Id, SenderId: "alice", Content: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	group, err = messages.GetConversation(ctx, group.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := messages.ListMessages(ctx, group.Id, group.JoinedAt["bob"], repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].Content != "after" {
+		t.Errorf("bob sees %+v, want only what was sent after bob joined", page.Messages)
+	}
+}
+
+func TestOpenKeepsUsedTokensUsed(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+
+	now := time.Now()
+	for _, hash := range []string{"used", "unused"} {
+		token := &models.Token{Hash: hash, Purpose: models.PasswordResetToken, UserId: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := store.Tokens().CreateToken(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Tokens().ConsumeToken(ctx, models.PasswordResetToken, "used", now); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := reopen(t, store, path).Tokens()
+
+	if _, err := tokens.ConsumeToken(ctx, models.PasswordResetToken, "used", now); !errors.Is(err, repository.ErrInvalidToken) {
+		t.Errorf("using a token again after a restart: err = %v, want ErrInvalidToken", err)
+	}
+	if token, err := tokens.ConsumeToken(ctx, models.PasswordResetToken, "unused", now); err != nil || token.UserId != "alice" {
+		t.Errorf("unused token after a restart = %+v, %v", token, err)
+	}
+}
+
+func TestOpenKeepsSessions(t *testing.T) {
+	ctx := context.Background()
+	store, path := openTemp(t)
+	sessions := store.Sessions()
+
+	now := time.Now().UTC()
+	for _, id := range []string{"laptop", "phone", "tablet"} {
+		session := &models.Session{Id: id, UserId: "alice", CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := sessions.CreateSession(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := now.Add(time.Minute)
+	if err := sessions.TouchSession(ctx, "laptop", seen, "192.0.2.1", "Firefox"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.DeleteSession(ctx, "phone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.TouchSession(ctx, "phone", seen, "192.0.2.2", "Safari"); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Errorf("touching a deleted session: err = %v, want ErrSessionNotFound", err)
+	}
+
+	sessions = reopen(t, store, path).Sessions()
+
+	found, err := sessions.GetUserSessions(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("%d sessions after a restart, want 2: %+v", len(found), found)
+	}
+	if found[0].Id != "laptop" || !found[0].LastSeenAt.Equal(seen) || found[0].IP != "192.0.2.1" || found[0].UserAgent != "Firefox" {
+		t.Errorf("touched session after a restart = %+v", found[0])
+	}
+	if _, err := sessions.GetSession(ctx, "phone"); !errors.Is(err, repository.ErrSessionNotFound) {
+		t.Errorf("deleted session after a restart: err = %v, want ErrSessionNotFound", err)
+	}
+}
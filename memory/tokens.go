@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"time"
+)
+
+type Tokens struct {
+	store *Store
+}
+
+func (t *Tokens) CreateToken(ctx context.Context, token *models.Token) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	stored := *token
+	t.store.tokens[token.Hash] = &stored
+
+	return t.store.save(tokenWrite(&stored))
+}
+
+func (t *Tokens) ConsumeToken(ctx context.Context, purpose string, hash string, at time.Time) (*models.Token, error) {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	token, ok := t.store.tokens[hash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !at.Before(token.ExpiresAt) {
+		return nil, repository.ErrInvalidToken
+	}
+
+	token.UsedAt = &at
+	consumed := *token
+
+	return &consumed, t.store.save(tokenWrite(token))
+}
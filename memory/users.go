@@ -0,0 +1,389 @@
+package memory
+
+import (
+	"context"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account keys users by their uuid, so the "document id" the interface hands
+// around is the uuid itself.
+type Account struct {
+	store *Store
+}
+
+func copyUser(user *models.User) *models.User {
+	c := *user
+	c.Followers = append([]string(nil), user.Followers...)
+	c.Following = append([]string(nil), user.Following...)
+	c.RecoveryCodes = append([]string(nil), user.RecoveryCodes...)
+	return &c
+}
+
+func (a *Account) CreateAccount(ctx context.Context, user *models.User) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	for _, existing := range a.store.users {
+		if existing.Email == user.Email {
+			return repository.ErrUserExists
+		}
+	}
+
+	user.Id = uuid.New().String()
+
+	if user.Username != "" {
+		if err := a.claim(user.Username, user.Id); err != nil {
+			return err
+		}
+	}
+
+	stored := copyUser(user)
+	stored.Password = string(hashedPassword)
+	stored.Followers = make([]string, 0)
+	stored.Following = make([]string, 0)
+	a.store.users[user.Id] = stored
+
+	writes := []write{userWrite(stored)}
+	if user.Username != "" {
+		writes = append(writes, handleWrite(a.store.handles[user.Username]))
+	}
+	return a.store.save(writes...)
+}
+
+func (a *Account) FindAccountByEmail(ctx context.Context, email *string) (*models.User, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	for _, user := range a.store.users {
+		if user.Email == *email {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, repository.ErrUserNotFound
+}
+
+func (a *Account) FindAccountByUuid(ctx context.Context, id string) (*models.User, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	user, ok := a.store.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// FindAccountByUuidUncached is FindAccountByUuid, which never caches.
+func (a *Account) FindAccountByUuidUncached(ctx context.Context, id string) (*models.User, error) {
+	return a.FindAccountByUuid(ctx, id)
+}
+
+func (a *Account) FindAccountByUsername(ctx context.Context, username string) (*models.User, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	for _, user := range a.store.users {
+		if user.Username != "" && user.Username == username {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, repository.ErrUserNotFound
+}
+
+func (a *Account) FindAccountByOldUsername(ctx context.Context, username string) (*models.User, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	handle, ok := a.store.handles[username]
+	if !ok || !handle.Redirects(time.Now()) {
+		return nil, repository.ErrUserNotFound
+	}
+
+	user, ok := a.store.users[handle.UserId]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (a *Account) GetDocumentIdByUuid(ctx context.Context, uuid string) (string, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	if _, ok := a.store.users[uuid]; !ok {
+		return "", repository.ErrUserNotFound
+	}
+
+	return uuid, nil
+}
+
+func (a *Account) AddFollower(ctx context.Context, followerId string, followingId string) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	follower, ok := a.store.users[followerId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	following, ok := a.store.users[followingId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	following.Followers = addUnique(following.Followers, followerId)
+	follower.Following = addUnique(follower.Following, followingId)
+
+	return a.store.save(userWrite(follower), userWrite(following))
+}
+
+func (a *Account) RemoveFollower(ctx context.Context, followerId string, followingId string) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	follower, ok := a.store.users[followerId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	following, ok := a.store.users[followingId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	following.Followers = remove(following.Followers, followerId)
+	follower.Following = remove(follower.Following, followingId)
+
+	return a.store.save(userWrite(follower), userWrite(following))
+}
+
+func (a *Account) IsFollowing(ctx context.Context, firstUuid string, secondUuid string) (bool, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	first, ok := a.store.users[firstUuid]
+	if !ok {
+		return false, repository.ErrUserNotFound
+	}
+	if _, ok := a.store.users[secondUuid]; !ok {
+		return false, repository.ErrUserNotFound
+	}
+
+	for _, id := range first.Following {
+		if id == secondUuid {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (a *Account) GetFollowingIds(ctx context.Context, uuid string) ([]string, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+
+	user, ok := a.store.users[uuid]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+
+	return append([]string(nil), user.Following...), nil
+}
+
+func (a *Account) SetPendingEmail(ctx context.Context, docId, email string) error {
+	return a.update(docId, func(user *models.User) { user.PendingEmail = email })
+}
+
+func (a *Account) VerifyEmail(ctx context.Context, docId, email string) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	user, ok := a.store.users[docId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	switch {
+	case email == user.Email:
+		user.Verified = true
+	case email != "" && email == user.PendingEmail:
+		for _, existing := range a.store.users {
+			if existing.Email == email {
+				return repository.ErrUserExists
+			}
+		}
+		user.Email = email
+		user.PendingEmail = ""
+		user.Verified = true
+	default:
+		return repository.ErrInvalidToken
+	}
+
+	return a.store.save(userWrite(user))
+}
+
+func (a *Account) UpdateFirstName(ctx context.Context, docId, firstName string) error {
+	return a.update(docId, func(user *models.User) { user.FirstName = firstName })
+}
+
+func (a *Account) UpdateLastName(ctx context.Context, docId, lastName string) error {
+	return a.update(docId, func(user *models.User) { user.LastName = lastName })
+}
+
+func (a *Account) UpdatePassword(ctx context.Context, docId, password string, changedAt time.Time) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return a.update(docId, func(user *models.User) {
+		user.Password = string(hashedPassword)
+		user.PasswordChangedAt = &changedAt
+	})
+}
+
+func (a *Account) EnableTOTP(ctx context.Context, docId, secret string, recoveryCodes []string) error {
+	return a.update(docId, func(user *models.User) {
+		user.TOTPSecret = secret
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = append([]string(nil), recoveryCodes...)
+	})
+}
+
+func (a *Account) DisableTOTP(ctx context.Context, docId string) error {
+	return a.update(docId, func(user *models.User) {
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+	})
+}
+
+func (a *Account) UseTOTPStep(ctx context.Context, docId string, step int64) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	user, ok := a.store.users[docId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	if step <= user.TOTPLastStep {
+		return repository.ErrInvalidCode
+	}
+	user.TOTPLastStep = step
+
+	return a.store.save(userWrite(user))
+}
+
+func (a *Account) UseRecoveryCode(ctx context.Context, docId, hash string) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	user, ok := a.store.users[docId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	remaining := without(user.RecoveryCodes, hash)
+	if len(remaining) == len(user.RecoveryCodes) {
+		return repository.ErrInvalidCode
+	}
+	user.RecoveryCodes = remaining
+
+	return a.store.save(userWrite(user))
+}
+
+func (a *Account) UpdateUsername(ctx context.Context, docId, username string, redirectUntil time.Time) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	user, ok := a.store.users[docId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+	if user.Username == username {
+		return nil
+	}
+
+	if err := a.claim(username, user.Id); err != nil {
+		return err
+	}
+	writes := []write{handleWrite(a.store.handles[username])}
+	if old, ok := a.store.handles[user.Username]; ok {
+		old.RedirectUntil = &redirectUntil
+		writes = append(writes, handleWrite(old))
+	}
+	user.Username = username
+
+	return a.store.save(append(writes, userWrite(user))...)
+}
+
+// claim reserves username for userId. Callers must hold the store's lock.
+func (a *Account) claim(username, userId string) error {
+	if handle, ok := a.store.handles[username]; ok && handle.TakenFor(userId, time.Now()) {
+		return repository.ErrUsernameTaken
+	}
+
+	a.store.handles[username] = &models.Handle{Username: username, UserId: userId}
+	return nil
+}
+
+func (a *Account) update(docId string, apply func(user *models.User)) error {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	user, ok := a.store.users[docId]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	apply(user)
+
+	return a.store.save(userWrite(user))
+}
+
+func addUnique(ids []string, id string) []string {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+func remove(ids []string, id string) []string {
+	kept := ids[:0]
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+func (a *Account) EachAccount(ctx context.Context, fn func(*models.User) error) error {
+	a.store.mu.RLock()
+	users := make([]*models.User, 0, len(a.store.users))
+	for _, user := range a.store.users {
+		users = append(users, copyUser(user))
+	}
+	a.store.mu.RUnlock()
+
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
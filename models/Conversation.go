@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// Conversation kinds.
+const (
+    DirectConversation = "direct"
+    GroupConversation = "group"
+)
+
+// System message actions. Each records a change SenderId made to a group,
+// usually to TargetId.
+const (
+    GroupCreated = "created"
+    MemberAdded = "added"
+    MemberRemoved = "removed"
+    MemberLeft = "left"
+    AdminAdded = "promoted"
+    AdminRemoved = "demoted"
+    // OwnerChanged is recorded when the owner leaves and TargetId takes over.
+    OwnerChanged = "owner"
+)
+
+// SystemMessage is the kind of message a group writes about itself; text
+// messages have no kind.
+const SystemMessage = "system"
+
+// Conversation is a private exchange of messages between its members.
+type Conversation struct {
+    Id string `json:"id"`
+    Kind string `json:"kind"`
+    // Name, OwnerId and AdminIds are only set for group conversations.
+    Name string `json:"name,omitempty"`
+    OwnerId string `json:"ownerId,omitempty"`
+    AdminIds []string `json:"adminIds,omitempty"`
+    MemberIds []string `json:"memberIds"`
+    // JoinedAt is when each member joined a group. They see only what was
+    // sent from then on; members with no entry see everything.
+    JoinedAt map[string]time.Time `json:"joinedAt,omitempty"`
+    Members []UserSummary `json:"members,omitempty" firestore:"-"`
+    CreatedAt time.Time `json:"createdAt"`
+    // UpdatedAt is when the last message was sent; inboxes are sorted by it.
+    UpdatedAt time.Time `json:"updatedAt"`
+    LastMessage *Message `json:"lastMessage,omitempty"`
+    // ReadAt is when each member last read the conversation, which is what
+    // read receipts are worked out from.
+    ReadAt map[string]time.Time `json:"readAt"`
+    // Unread counts, per member, the messages sent since they last read it.
+    Unread map[string]int `json:"unread"`
+    // UnreadCount is Unread for the user looking at the conversation.
+    UnreadCount int `json:"unreadCount" firestore:"-"`
+}
+
+// HasMember reports whether userId may read and write the conversation.
+func (c *Conversation) HasMember(userId string) bool {
+    for _, memberId := range c.MemberIds {
+        if memberId == userId {
+            return true
+        }
+    }
+    return false
+}
+
+// IsAdmin reports whether userId may manage the group's members. The owner
+// is always an admin.
+func (c *Conversation) IsAdmin(userId string) bool {
+    if c.OwnerId == userId {
+        return true
+    }
+    for _, adminId := range c.AdminIds {
+        if adminId == userId {
+            return true
+        }
+    }
+    return false
+}
+
+// NextOwner picks who takes over once the owner has left the group: the
+// longest-standing admin, or else the longest-standing member. It is empty
+// when nobody is left.
+func (c *Conversation) NextOwner() string {
+    if len(c.AdminIds) > 0 {
+        return c.AdminIds[0]
+    }
+    if len(c.MemberIds) > 0 {
+        return c.MemberIds[0]
+    }
+    return ""
+}
+
+type Message struct {
+    Id string `json:"id"`
+    ConversationId string `json:"conversationId"`
+    Kind string `json:"kind,omitempty"`
+    SenderId string `json:"senderId"`
+    // Action and TargetId describe a system message, whose Content is
+    // written from them when it is shown.
+    Action string `json:"action,omitempty"`
+    TargetId string `json:"targetId,omitempty"`
+    Content string `json:"content"`
+    CreatedAt time.Time `json:"createdAt"`
+    // ReadBy lists the other members who have read the message.
+    ReadBy []string `json:"readBy,omitempty" firestore:"-"`
+}
+
+// DirectConversationId is the same for both orders of the two users, so a
+// pair can only ever have one direct conversation.
+func DirectConversationId(userId string, otherId string) string {
+    if otherId < userId {
+        userId, otherId = otherId, userId
+    }
+    return "direct_" + userId + "_" + otherId
+}
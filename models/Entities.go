@@ -0,0 +1,51 @@
+package models
+
+// Entities are the structured parts of a post's content. Start and End are
+// offsets into Content counted in Unicode code points, End exclusive.
+type Entities struct {
+    Hashtags []HashtagEntity `json:"hashtags,omitempty"`
+    Mentions []MentionEntity `json:"mentions,omitempty"`
+}
+
+type HashtagEntity struct {
+    // Tag is the normalized form used for lookups; the text as written is
+    // Content[Start:End].
+    Tag string `json:"tag"`
+    Start int `json:"start"`
+    End int `json:"end"`
+}
+
+// MentionEntity is an @handle that belonged to UserId when the post was
+// written; it keeps pointing at that account if the handle changes hands.
+type MentionEntity struct {
+    UserId string `json:"userId"`
+    Start int `json:"start"`
+    End int `json:"end"`
+}
+
+// TagNames returns each distinct normalized tag once, in order of first use.
+func (e Entities) TagNames() []string {
+    tags := make([]string, 0, len(e.Hashtags))
+    seen := make(map[string]bool, len(e.Hashtags))
+    for _, hashtag := range e.Hashtags {
+        if !seen[hashtag.Tag] {
+            seen[hashtag.Tag] = true
+            tags = append(tags, hashtag.Tag)
+        }
+    }
+    return tags
+}
+
+// MentionedUserIds returns each distinct mentioned user once, in order of
+// first mention.
+func (e Entities) MentionedUserIds() []string {
+    ids := make([]string, 0, len(e.Mentions))
+    seen := make(map[string]bool, len(e.Mentions))
+    for _, mention := range e.Mentions {
+        if !seen[mention.UserId] {
+            seen[mention.UserId] = true
+            ids = append(ids, mention.UserId)
+        }
+    }
+    return ids
+}
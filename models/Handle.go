@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Handle reserves a username for an account. The account's current username
+// has no RedirectUntil; one it gave up keeps pointing at it until then, so
+// old links still work and nobody else can pick it up in the meantime.
+type Handle struct {
+    Username string `json:"username"`
+    UserId string `json:"userId"`
+    RedirectUntil *time.Time `json:"redirectUntil,omitempty"`
+}
+
+// IsCurrent reports whether the handle is its account's username right now.
+func (h *Handle) IsCurrent() bool {
+    return h.RedirectUntil == nil
+}
+
+// Redirects reports whether an old handle still points at its account.
+func (h *Handle) Redirects(now time.Time) bool {
+    return h.RedirectUntil != nil && now.Before(*h.RedirectUntil)
+}
+
+// TakenFor reports whether the handle stops userId from claiming it.
+func (h *Handle) TakenFor(userId string, now time.Time) bool {
+    return h.UserId != userId && (h.IsCurrent() || h.Redirects(now))
+}
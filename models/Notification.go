@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Notification is one entry in a user's inbox. Events of the same kind about
+// the same post are grouped into the newest unread entry, so five likes on a
+// post read as one notification with five actors.
+type Notification struct {
+    Id string `json:"id"`
+    RecipientId string `json:"recipientId"`
+    Kind string `json:"kind"`
+    PostId string `json:"postId,omitempty"`
+    // ActorIds holds who caused the notification, most recent first, up to
+    // NotificationActorLimit of them.
+    ActorIds []string `json:"actorIds"`
+    // ActorCount counts everyone who caused it, including those whose ids no
+    // longer fit in ActorIds.
+    ActorCount int `json:"actorCount"`
+    // Actors describes the most recent few of ActorIds for display.
+    Actors []UserSummary `json:"actors,omitempty" firestore:"-"`
+    Summary string `json:"summary,omitempty" firestore:"-"`
+    CreatedAt time.Time `json:"createdAt"`
+    // UpdatedAt is when the last actor was added; the inbox is sorted by it.
+    UpdatedAt time.Time `json:"updatedAt"`
+    ReadAt *time.Time `json:"readAt,omitempty"`
+}
+
+// NotificationActorLimit is how many actor ids a notification keeps, so a
+// post liked by thousands does not grow one record without bound.
+const NotificationActorLimit = 50
+
+// TotalActors is how many people caused the notification.
+func (n *Notification) TotalActors() int {
+    // Notifications stored before ActorCount existed have only their ids.
+    if n.ActorCount < len(n.ActorIds) {
+        return len(n.ActorIds)
+    }
+    return n.ActorCount
+}
+
+// AddActor puts actorId first, counting them unless they are on the
+// notification already, and drops the oldest ids past the limit. An actor
+// whose id was dropped is counted again if they come back.
+func (n *Notification) AddActor(actorId string) {
+    n.ActorCount = n.TotalActors()
+    actorIds := []string{actorId}
+    for _, id := range n.ActorIds {
+        if id != actorId {
+            actorIds = append(actorIds, id)
+        }
+    }
+    if len(actorIds) > len(n.ActorIds) {
+        n.ActorCount++
+    }
+    if len(actorIds) > NotificationActorLimit {
+        actorIds = actorIds[:NotificationActorLimit]
+    }
+    n.ActorIds = actorIds
+}
+
+// RemoveActor takes actorId off the notification and reports whether they
+// were on it. An actor whose id was dropped cannot be taken off.
+func (n *Notification) RemoveActor(actorId string) bool {
+    for i, id := range n.ActorIds {
+        if id == actorId {
+            n.ActorCount = n.TotalActors() - 1
+            n.ActorIds = append(n.ActorIds[:i:i], n.ActorIds[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// UserSummary is what is shown about a user next to something they did.
+type UserSummary struct {
+    Id string `json:"id"`
+    Name string `json:"name"`
+    Username string `json:"username,omitempty"`
+}
@@ -1,7 +1,44 @@
 package models
 
+import "time"
+
 type Post struct {
+    Id string `json:"id"`
     Author string `json:"author"`
     AuthorId string `json:"authorId"`
     Content string `json:"content"`
+    Entities Entities `json:"entities"`
+    // Tags indexes the normalized hashtags in Entities for lookups.
+    Tags []string `json:"tags,omitempty"`
+    // ParentId is the post this one replies to, and RootId the top-level
+    // post of the thread. Both are empty for top-level posts.
+    ParentId string `json:"parentId,omitempty"`
+    RootId string `json:"rootId,omitempty"`
+    ReplyCount int `json:"replyCount"`
+    // RepostOf is set on a plain repost and QuoteOf on a quote post; either
+    // way Original is filled in per request with the shared post.
+    RepostOf string `json:"repostOf,omitempty"`
+    QuoteOf string `json:"quoteOf,omitempty"`
+    Original *EmbeddedPost `json:"original,omitempty" firestore:"-"`
+    CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
+    LikeCount int `json:"likeCount"`
+    // LikedByMe is filled in per request for the session user.
+    LikedByMe bool `json:"likedByMe" firestore:"-"`
+    // EditedAt is set once the author changes the content.
+    EditedAt *time.Time `json:"editedAt,omitempty"`
+    // DeletedAt marks a soft-deleted post. It is kept for moderation but
+    // never served.
+    DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// EmbeddedPost is the shared post shown inside a repost or quote. Once the
+// original is deleted only Id and Unavailable are set.
+type EmbeddedPost struct {
+    Id string `json:"id"`
+    Author string `json:"author,omitempty"`
+    AuthorId string `json:"authorId,omitempty"`
+    Content string `json:"content,omitempty"`
+    CreatedAt *time.Time `json:"createdAt,omitempty"`
+    Unavailable bool `json:"unavailable,omitempty"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is one signed-in browser or device. The cookie holds a random id;
+// only its hash is stored, and the hash is what the session is known by.
+type Session struct {
+    Id string `json:"id"`
+    UserId string `json:"userId"`
+    // Values are the session's values, gob-encoded by the session store.
+    Values []byte `json:"values"`
+    UserAgent string `json:"userAgent"`
+    IP string `json:"ip"`
+    CreatedAt time.Time `json:"createdAt"`
+    LastSeenAt time.Time `json:"lastSeenAt"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
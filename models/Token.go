@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Token purposes.
+const (
+    PasswordResetToken = "password_reset"
+    EmailVerificationToken = "email_verification"
+)
+
+// Token is a single-use secret emailed to a user. Only a hash of the secret
+// is stored, so the link in the email is the only way to use it.
+type Token struct {
+    Hash string
+    Purpose string
+    UserId string
+    // Email is the address an email verification token confirms.
+    Email string
+    CreatedAt time.Time
+    ExpiresAt time.Time
+    UsedAt *time.Time
+}
@@ -1,10 +1,29 @@
 package models
 
+import "time"
+
 type User struct {
     Email string
     FirstName string
     LastName string
+    // Username is the @handle, always stored in lower case.
+    Username string
     Password string
+    // Verified is set once the user has followed a link sent to Email.
+    Verified bool
+    // PendingEmail is an address the user changed to but has not confirmed
+    // yet. Email stays in use until they do.
+    PendingEmail string
+    // PasswordChangedAt makes reset links asked for before it stale.
+    PasswordChangedAt *time.Time
+    // TOTPSecret is set while two-factor login is on.
+    TOTPSecret string
+    // TOTPLastStep is the time step of the last code used, so none is
+    // accepted twice.
+    TOTPLastStep int64
+    // RecoveryCodes are hashes of the unused codes that can stand in for an
+    // authenticator code once each.
+    RecoveryCodes []string
     Id string
     Followers []string
     Following []string
@@ -0,0 +1,32 @@
+package notifications
+
+import (
+	"context"
+	"posts/repository"
+)
+
+// Inbox is the Notifier that stores events in each recipient's inbox.
+type Inbox struct {
+	repo repository.NotificationsRepository
+}
+
+func NewInbox(repo repository.NotificationsRepository) *Inbox {
+	return &Inbox{repo: repo}
+}
+
+// Notify drops events users cause themselves, such as liking their own post.
+func (i *Inbox) Notify(ctx context.Context, event Event) error {
+	if event.RecipientId == event.ActorId {
+		return nil
+	}
+
+	return i.repo.Record(ctx, event.RecipientId, string(event.Kind), event.PostId, event.ActorId, event.CreatedAt)
+}
+
+func (i *Inbox) Retract(ctx context.Context, event Event) error {
+	if event.RecipientId == event.ActorId {
+		return nil
+	}
+
+	return i.repo.Retract(ctx, event.RecipientId, string(event.Kind), event.PostId, event.ActorId)
+}
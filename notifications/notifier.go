@@ -0,0 +1,38 @@
+// Package notifications tells users about activity that concerns them, such
+// as new followers, likes, replies, mentions and reposts.
+package notifications
+
+import (
+	"context"
+	"time"
+)
+
+type Kind string
+
+const (
+	Follow  Kind = "follow"
+	Like    Kind = "like"
+	Reply   Kind = "reply"
+	Mention Kind = "mention"
+	Repost  Kind = "repost"
+	Quote   Kind = "quote"
+)
+
+// Event is something that happened to RecipientId because of ActorId.
+// PostId is empty for events that are not about a post, such as follows.
+type Event struct {
+	Kind        Kind
+	RecipientId string
+	ActorId     string
+	PostId      string
+	CreatedAt   time.Time
+}
+
+// Notifier delivers events to their recipients. Handlers call it after the
+// change that caused the event has been saved, so a failed notification
+// never undoes the change.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+	// Retract takes back an event whose cause was undone, such as a like.
+	Retract(ctx context.Context, event Event) error
+}
@@ -0,0 +1,37 @@
+package notifications
+
+import (
+	"fmt"
+	"posts/models"
+)
+
+var actions = map[Kind]string{
+	Follow:  "followed you",
+	Like:    "liked your post",
+	Reply:   "replied to your post",
+	Mention: "mentioned you in a post",
+	Repost:  "reposted your post",
+	Quote:   "quoted your post",
+}
+
+// Summary describes a grouped notification in one line, such as "Ann Lee
+// and Bob Ray liked your post" or "5 people liked your post". actors holds
+// the most recent actors; the total comes from the notification.
+func Summary(notification *models.Notification, actors []models.UserSummary) string {
+	action, ok := actions[Kind(notification.Kind)]
+	if !ok {
+		action = "did something"
+	}
+
+	count := notification.TotalActors()
+	switch {
+	case count == 1 && len(actors) >= 1:
+		return fmt.Sprintf("%s %s", actors[0].Name, action)
+	case count == 2 && len(actors) >= 2:
+		return fmt.Sprintf("%s and %s %s", actors[0].Name, actors[1].Name, action)
+	case count == 1:
+		return fmt.Sprintf("Someone %s", action)
+	default:
+		return fmt.Sprintf("%d people %s", count, action)
+	}
+}
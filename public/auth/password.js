@@ -0,0 +1,44 @@
+const forgotForm = document.getElementById("forgot_form");
+const resetForm = document.getElementById("reset_form");
+const message = document.getElementById("message");
+
+// submitForm posts form to url, showing any error under it. It reports
+// whether the request worked.
+async function submitForm(form, url, extra = {}) {
+    const formData = new FormData(form);
+    Object.entries(extra).forEach(([name, value]) => formData.append(name, value));
+
+    message.classList.remove("text-danger");
+    const response = await fetch(url, {
+        method: "POST",
+        body: new URLSearchParams(formData),
+    });
+
+    if (response.ok) {
+        return true;
+    }
+
+    const data = await response.json().catch(() => ({}));
+    message.classList.add("text-danger");
+    message.innerText = data.error || "Something went wrong, please try again.";
+    return false;
+}
+
+if (forgotForm) {
+    forgotForm.addEventListener("submit", async (event) => {
+        event.preventDefault();
+        if (await submitForm(forgotForm, "/api/password/forgot")) {
+            message.innerText = "If that address has an account, a reset link is on its way.";
+        }
+    });
+}
+
+if (resetForm) {
+    resetForm.addEventListener("submit", async (event) => {
+        event.preventDefault();
+        const token = new URLSearchParams(window.location.search).get("token") || "";
+        if (await submitForm(resetForm, "/api/password/reset", { token })) {
+            window.location.href = "/login";
+        }
+    });
+}
@@ -0,0 +1,22 @@
+const twoFactorForm = document.getElementById("two_factor_form");
+const message = document.getElementById("message");
+
+twoFactorForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    message.classList.remove("text-danger");
+
+    const response = await fetch("/api/login/two-factor", {
+        method: "POST",
+        body: new URLSearchParams(new FormData(twoFactorForm)),
+    });
+
+    if (response.ok) {
+        window.location.href = "/media";
+        return;
+    }
+
+    const data = await response.json().catch(() => ({}));
+    message.classList.add("text-danger");
+    message.innerText = data.error || "Something went wrong, please try again.";
+    twoFactorForm.reset();
+});
@@ -0,0 +1,19 @@
+const message = document.getElementById("message");
+
+window.onload = async () => {
+    const response = await fetch("/api/verify-email", {
+        method: "POST",
+        body: new URLSearchParams({
+            token: new URLSearchParams(window.location.search).get("token") || "",
+        }),
+    });
+
+    if (response.ok) {
+        message.innerText = "Your email address is confirmed.";
+        return;
+    }
+
+    const data = await response.json().catch(() => ({}));
+    message.classList.add("text-danger");
+    message.innerText = data.error || "Something went wrong, please try again.";
+}
@@ -1,10 +1,12 @@
 const emailInput = document.getElementById('email');
+const usernameInput = document.getElementById('username');
 const firstNameInput = document.getElementById('first_name');
 const lastNameInput = document.getElementById('last_name');
 const confirmButton = document.getElementById('confirm_button');
 const backButton = document.getElementById('back_button');
 
 const email = emailInput.value;
+const username = usernameInput.value;
 const firstName = firstNameInput.value;
 const lastName = lastNameInput.value;
 
@@ -14,6 +16,12 @@ emailInput.addEventListener("input", () => {
     }
 });
 
+usernameInput.addEventListener("input", () => {
+    if (usernameInput.value !== username && confirmButton.disabled) {
+        confirmButton.disabled = false;
+    }
+});
+
 firstNameInput.addEventListener("input", () => {
     if (firstNameInput.value !== firstName && confirmButton.disabled) {
         confirmButton.disabled = false;
@@ -25,3 +33,196 @@ lastNameInput.addEventListener("input", () => {
         confirmButton.disabled = false;
     }
 });
+
+const passwordForm = document.getElementById('password_form');
+const passwordMessage = document.getElementById('password_message');
+
+passwordForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    passwordMessage.classList.remove("text-danger", "text-success");
+
+    const response = await fetch("/api/settings/password", {
+        method: "POST",
+        body: new URLSearchParams(new FormData(passwordForm)),
+    });
+
+    if (response.ok) {
+        passwordForm.reset();
+        passwordMessage.classList.add("text-success");
+        passwordMessage.innerText = "Password changed. Your other sessions have been logged out.";
+        return;
+    }
+
+    const data = await response.json().catch(() => ({}));
+    passwordMessage.classList.add("text-danger");
+    passwordMessage.innerText = data.error || "Something went wrong, please try again.";
+});
+
+document.querySelectorAll('.resend_link').forEach((link) => {
+    link.addEventListener("click", async (event) => {
+        event.preventDefault();
+
+        const response = await fetch("/api/settings/verify-email", { method: "POST" });
+        if (response.ok) {
+            link.replaceWith("Link sent, check your inbox.");
+            return;
+        }
+
+        const data = await response.json().catch(() => ({}));
+        alert(data.error || "Something went wrong, please try again.");
+    });
+});
+
+const sessionsList = document.getElementById('sessions');
+const revokeOthersButton = document.getElementById('revoke_others_button');
+
+async function loadSessions() {
+    const response = await fetch("/api/settings/sessions");
+    if (!response.ok) {
+        return;
+    }
+
+    const data = await response.json();
+    sessionsList.innerHTML = "";
+    data.sessions.forEach((session) => sessionsList.appendChild(createSessionElement(session)));
+}
+
+function createSessionElement(session) {
+    const item = document.createElement("li");
+    item.classList.add("d-flex", "justify-content-between", "align-items-center", "py-2", "border-bottom");
+
+    const details = document.createElement("div");
+    const device = document.createElement("div");
+    device.innerText = session.device;
+    if (session.current) {
+        const current = document.createElement("span");
+        current.classList.add("badge", "bg-success", "ms-2");
+        current.innerText = "This device";
+        device.appendChild(current);
+    }
+    const seen = document.createElement("small");
+    seen.classList.add("text-muted");
+    seen.innerText = `${session.ip} · last seen ${new Date(session.lastSeenAt).toLocaleString()}`;
+    details.appendChild(device);
+    details.appendChild(seen);
+    item.appendChild(details);
+
+    if (!session.current) {
+        const revokeButton = document.createElement("button");
+        revokeButton.classList.add("btn", "btn-sm", "btn-outline-dark");
+        revokeButton.innerText = "Log out";
+        revokeButton.addEventListener("click", async () => {
+            const response = await fetch(`/api/settings/sessions/${session.id}`, { method: "DELETE" });
+            if (response.ok) {
+                item.remove();
+            }
+        });
+        item.appendChild(revokeButton);
+    }
+
+    return item;
+}
+
+revokeOthersButton.addEventListener("click", async () => {
+    const response = await fetch("/api/settings/sessions", { method: "DELETE" });
+    if (response.ok) {
+        await loadSessions();
+    }
+});
+
+loadSessions();
+
+const twoFactorStatus = document.getElementById('two_factor_status');
+const twoFactorOff = document.getElementById('two_factor_off');
+const setupButton = document.getElementById('setup_button');
+const enableForm = document.getElementById('enable_form');
+const disableForm = document.getElementById('disable_form');
+const recoveryCodes = document.getElementById('recovery_codes');
+const twoFactorMessage = document.getElementById('two_factor_message');
+
+function showTwoFactorError(data) {
+    twoFactorMessage.classList.add("text-danger");
+    twoFactorMessage.innerText = data.error || "Something went wrong, please try again.";
+}
+
+async function loadTwoFactor() {
+    const response = await fetch("/api/settings/two-factor");
+    if (!response.ok) {
+        return;
+    }
+
+    const data = await response.json();
+    twoFactorOff.classList.toggle("d-none", data.enabled);
+    disableForm.classList.toggle("d-none", !data.enabled);
+    enableForm.classList.add("d-none");
+    twoFactorStatus.innerText = data.enabled
+        ? `Two-factor login is on. You have ${data.recoveryCodesLeft} recovery codes left.`
+        : "Two-factor login is off.";
+}
+
+setupButton.addEventListener("click", async () => {
+    twoFactorMessage.classList.remove("text-danger");
+    twoFactorMessage.innerText = "";
+    const response = await fetch("/api/settings/two-factor/setup", { method: "POST" });
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        showTwoFactorError(data);
+        return;
+    }
+
+    const qrCode = document.getElementById('qr_code');
+    qrCode.innerHTML = "";
+    new QRCode(qrCode, { text: data.uri, width: 180, height: 180 });
+    document.getElementById('totp_secret').innerText = data.secret;
+
+    twoFactorOff.classList.add("d-none");
+    enableForm.classList.remove("d-none");
+});
+
+enableForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    twoFactorMessage.classList.remove("text-danger");
+    twoFactorMessage.innerText = "";
+
+    const response = await fetch("/api/settings/two-factor/enable", {
+        method: "POST",
+        body: new URLSearchParams(new FormData(enableForm)),
+    });
+    const data = await response.json().catch(() => ({}));
+    if (!response.ok) {
+        showTwoFactorError(data);
+        return;
+    }
+
+    const list = document.getElementById('recovery_code_list');
+    list.innerHTML = "";
+    data.recoveryCodes.forEach((code) => {
+        const item = document.createElement("li");
+        item.innerText = code;
+        list.appendChild(item);
+    });
+    recoveryCodes.classList.remove("d-none");
+    enableForm.reset();
+    await loadTwoFactor();
+});
+
+disableForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    twoFactorMessage.classList.remove("text-danger");
+    twoFactorMessage.innerText = "";
+
+    const response = await fetch("/api/settings/two-factor/disable", {
+        method: "POST",
+        body: new URLSearchParams(new FormData(disableForm)),
+    });
+    if (!response.ok) {
+        showTwoFactorError(await response.json().catch(() => ({})));
+        return;
+    }
+
+    disableForm.reset();
+    recoveryCodes.classList.add("d-none");
+    await loadTwoFactor();
+});
+
+loadTwoFactor();
@@ -30,10 +30,11 @@ addPostButton.addEventListener("click", async () => {
     const data = await response.json();
 
     if (!response.ok) {
+        alert(data.error);
         return;
     }
 
-    createPostElement(data);
+    createPostElement(data, true);
 });
 
 window.onload = async () => {
@@ -54,12 +55,48 @@ window.onload = async () => {
     profileDetails.innerHTML += `
         <div class="profile_details">
             <div class="profile_details_name">
-                <a href="/profiles/${profileDetailsData.id}" style="text-decoration: none; color: black">${profileDetailsData.name}</a>
+                <a href="${profileDetailsData.username ? `/@${profileDetailsData.username}` : `/profiles/${profileDetailsData.id}`}" style="text-decoration: none; color: black">${profileDetailsData.name}</a>
             </div>
         </div>
     `;
 
-    const response = await fetch("/api/posts", {
+    loadUnreadBadge();
+    await loadPosts();
+    openStream();
+}
+
+// openStream listens for live updates. EventSource reconnects by itself and
+// sends the last event id, so nothing pushed in between is lost.
+function openStream() {
+    const stream = new EventSource("/api/stream");
+
+    stream.addEventListener("post", (event) => {
+        if (posts.dataset.feed === "timeline") {
+            createPostElement(JSON.parse(event.data), true);
+        }
+    });
+
+    stream.addEventListener("notification", () => {
+        loadUnreadBadge();
+    });
+}
+
+let nextCursor = "";
+let loadingPosts = false;
+
+async function loadPosts() {
+    if (loadingPosts || nextCursor === null) {
+        return;
+    }
+    loadingPosts = true;
+
+    const query = nextCursor ? `?cursor=${encodeURIComponent(nextCursor)}` : "";
+    const endpoints = {
+        explore: "/api/posts",
+        tag: `/api/tags/${encodeURIComponent(posts.dataset.tag)}`,
+    };
+    const endpoint = endpoints[posts.dataset.feed] || "/api/timeline";
+    const response = await fetch(`${endpoint}${query}`, {
         method: "GET",
         headers: {
             "Content-Type": "application/json"
@@ -67,19 +104,33 @@ window.onload = async () => {
     });
 
     const data = await response.json();
+    loadingPosts = false;
 
     if (!response.ok) {
         return;
     }
 
-    data.forEach((post) => {
+    data.posts.forEach((post) => {
         createPostElement(post);
     });
+    nextCursor = data.nextCursor || null;
 }
 
-function createPostElement(post) {
+window.addEventListener("scroll", () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 200) {
+        loadPosts();
+    }
+});
+
+function createPostElement(post, prepend = false) {
+    // The stream may push a post this page already shows.
+    if (document.querySelector(`.post_body[data-post-id="${post.id}"]`)) {
+        return;
+    }
+
     const postBody = document.createElement("div");
     postBody.classList.add("post_body");
+    postBody.dataset.postId = post.id;
 
     const postCard = document.createElement("div");
     postCard.classList.add("card-body");
@@ -87,18 +138,131 @@ function createPostElement(post) {
     const postContent = document.createElement("p");
     postContent.classList.add("card-text");
     postContent.classList.add("text-justify");
-    postContent.innerText = post.content;
+    renderContent(postContent, post.content, post.entities);
 
     const postAuthor = document.createElement("h5");
     postAuthor.classList.add("card-text");
     postAuthor.classList.add("text-right");
     postAuthor.innerHTML = `<a href="/profiles/${post.authorId}" style="text-decoration: none; color: black">${post.author}</a>`;
 
+    const likeButton = createLikeButton(post);
+
     const hr = document.createElement("hr");
 
     postCard.appendChild(postAuthor);
-    postCard.appendChild(postContent);
+    if (post.repostOf) {
+        postAuthor.innerHTML += ` <small class="text-muted"><i class="fas fa-retweet"></i> reposted</small>`;
+    } else {
+        postCard.appendChild(postContent);
+    }
+    if (post.original) {
+        postCard.appendChild(createEmbeddedPost(post.original));
+    }
+    postCard.appendChild(likeButton);
     postBody.appendChild(postCard);
     postBody.appendChild(hr);
-    posts.appendChild(postBody);
+    if (prepend) {
+        posts.prepend(postBody);
+    } else {
+        posts.appendChild(postBody);
+    }
+}
+
+function createLikeButton(post) {
+    const likeButton = document.createElement("button");
+    likeButton.type = "button";
+    likeButton.classList.add("btn", "btn-sm", "btn-link");
+
+    let liked = post.likedByMe;
+    let count = post.likeCount || 0;
+    const render = () => {
+        likeButton.innerHTML = `<i class="${liked ? "fas" : "far"} fa-heart"></i> ${count}`;
+    };
+    render();
+
+    likeButton.addEventListener("click", async () => {
+        likeButton.disabled = true;
+        const response = await fetch(`/api/posts/${post.id}/like`, {
+            method: liked ? "DELETE" : "POST",
+        });
+        likeButton.disabled = false;
+
+        if (!response.ok) {
+            return;
+        }
+
+        const data = await response.json();
+        liked = data.likedByMe;
+        count = data.likeCount;
+        render();
+    });
+
+    return likeButton;
+}
+
+function createEmbeddedPost(original) {
+    const embedded = document.createElement("div");
+    embedded.classList.add("card", "card-body", "mb-2", "p-2");
+
+    if (original.unavailable) {
+        embedded.classList.add("text-muted");
+        embedded.innerText = "This post is unavailable.";
+        return embedded;
+    }
+
+    const author = document.createElement("h6");
+    author.innerHTML = `<a href="/profiles/${original.authorId}" style="text-decoration: none; color: black">${original.author}</a>`;
+
+    const content = document.createElement("p");
+    content.classList.add("card-text", "mb-0");
+    content.innerText = original.content;
+
+    embedded.appendChild(author);
+    embedded.appendChild(content);
+    return embedded;
+}
+
+// renderContent writes text into element, turning hashtags and mentions into
+// links. Entity offsets count code points, so the text is split with Array.from.
+function renderContent(element, text, entities) {
+    const chars = Array.from(text);
+    const links = [];
+    ((entities && entities.hashtags) || []).forEach((hashtag) => {
+        links.push({ start: hashtag.start, end: hashtag.end, href: `/tags/${encodeURIComponent(hashtag.tag)}` });
+    });
+    ((entities && entities.mentions) || []).forEach((mention) => {
+        links.push({ start: mention.start, end: mention.end, href: `/profiles/${mention.userId}` });
+    });
+    links.sort((a, b) => a.start - b.start);
+
+    let last = 0;
+    links.forEach((entity) => {
+        element.appendChild(document.createTextNode(chars.slice(last, entity.start).join("")));
+
+        const link = document.createElement("a");
+        link.href = entity.href;
+        link.innerText = chars.slice(entity.start, entity.end).join("");
+        element.appendChild(link);
+
+        last = entity.end;
+    });
+
+    element.appendChild(document.createTextNode(chars.slice(last).join("")));
+}
+
+async function loadUnreadBadge() {
+    const response = await fetch("/api/notifications/unread", {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+
+    if (!response.ok) {
+        return;
+    }
+
+    document.getElementById("notification_badge").innerText = data.unreadCount > 0 ? data.unreadCount : "";
 }
@@ -0,0 +1,280 @@
+const conversationList = document.getElementById("conversations");
+const unreadCount = document.getElementById("unread_count");
+const conversationCard = document.getElementById("conversation");
+const conversationTitle = document.getElementById("conversation_title");
+const messageList = document.getElementById("messages");
+const olderButton = document.getElementById("older_button");
+const messageForm = document.getElementById("message_form");
+const messageInput = document.getElementById("message_input");
+const leaveButton = document.getElementById("leave_button");
+
+const me = conversationCard.dataset.me;
+
+let current = null;
+let nextCursor = "";
+let nextMessagesCursor = null;
+let loadingConversations = false;
+
+window.onload = async () => {
+    const withUser = new URLSearchParams(window.location.search).get("with");
+    if (withUser) {
+        const response = await fetch("/api/conversations", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json"
+            },
+            body: JSON.stringify({ userId: withUser }),
+        });
+
+        if (response.ok) {
+            await openConversation(await response.json());
+        }
+    }
+
+    await loadConversations();
+
+    const stream = new EventSource("/api/stream");
+    stream.addEventListener("message", (event) => {
+        const message = JSON.parse(event.data);
+        if (current && message.conversationId === current.id) {
+            messageList.appendChild(createMessageElement(message));
+            if (message.senderId !== me) {
+                markRead();
+            }
+        }
+        reloadConversations();
+    });
+    stream.addEventListener("read", (event) => {
+        const receipt = JSON.parse(event.data);
+        if (current && receipt.conversationId === current.id) {
+            current.readAt[receipt.userId] = receipt.readAt;
+            messageList.querySelectorAll("[data-created-at]").forEach(showReceipt);
+        }
+    });
+}
+
+async function loadConversations() {
+    if (loadingConversations || nextCursor === null) {
+        return;
+    }
+    loadingConversations = true;
+
+    const query = nextCursor ? `?cursor=${encodeURIComponent(nextCursor)}` : "";
+    const response = await fetch(`/api/conversations${query}`, {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+    loadingConversations = false;
+
+    if (!response.ok) {
+        return;
+    }
+
+    showUnreadCount(data.unreadCount);
+    data.conversations.forEach((conversation) => {
+        conversationList.appendChild(createConversationElement(conversation));
+    });
+    nextCursor = data.nextCursor || null;
+}
+
+async function reloadConversations() {
+    conversationList.innerHTML = "";
+    nextCursor = "";
+    await loadConversations();
+}
+
+window.addEventListener("scroll", () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 200) {
+        loadConversations();
+    }
+});
+
+function showUnreadCount(count) {
+    unreadCount.innerText = count > 0 ? count : "";
+}
+
+// conversationName is a group's name, or else lists the other members.
+function conversationName(conversation) {
+    if (conversation.name) {
+        return conversation.name;
+    }
+    const others = conversation.members.filter((member) => member.id !== me);
+    return others.map((member) => member.name).join(", ") || "Just you";
+}
+
+function createConversationElement(conversation) {
+    const item = document.createElement("li");
+    item.classList.add("p-3", "border-bottom");
+    item.style.cursor = "pointer";
+    if (conversation.unreadCount > 0) {
+        item.classList.add("font-weight-bold");
+    }
+
+    const name = document.createElement("div");
+    name.innerText = conversationName(conversation);
+    if (conversation.unreadCount > 0) {
+        name.innerHTML += ` <span class="badge badge-primary">${conversation.unreadCount}</span>`;
+    }
+
+    const last = document.createElement("small");
+    last.classList.add("d-block", "text-muted", "text-truncate");
+    last.innerText = conversation.lastMessage ? conversation.lastMessage.content : "No messages yet";
+
+    item.appendChild(name);
+    item.appendChild(last);
+    item.addEventListener("click", () => openConversation(conversation));
+
+    return item;
+}
+
+async function openConversation(conversation) {
+    current = conversation;
+    current.readAt = current.readAt || {};
+    conversationTitle.innerText = conversationName(conversation);
+    leaveButton.classList.toggle("d-none", conversation.kind !== "group");
+    conversationCard.classList.remove("d-none");
+    messageList.innerHTML = "";
+    nextMessagesCursor = "";
+
+    await loadMessages();
+    await markRead();
+}
+
+async function loadMessages() {
+    if (nextMessagesCursor === null) {
+        return;
+    }
+
+    const query = nextMessagesCursor ? `?cursor=${encodeURIComponent(nextMessagesCursor)}` : "";
+    const response = await fetch(`/api/conversations/${current.id}/messages${query}`, {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+
+    if (!response.ok) {
+        return;
+    }
+
+    // Pages come newest first, so each one goes above what is shown.
+    data.messages.forEach((message) => {
+        messageList.insertBefore(createMessageElement(message), messageList.firstChild);
+    });
+    nextMessagesCursor = data.nextCursor || null;
+    olderButton.classList.toggle("d-none", nextMessagesCursor === null);
+}
+
+olderButton.addEventListener("click", loadMessages);
+
+leaveButton.addEventListener("click", async () => {
+    if (!confirm(`Leave ${conversationName(current)}?`)) {
+        return;
+    }
+
+    const response = await fetch(`/api/conversations/${current.id}/leave`, {
+        method: "POST",
+    });
+
+    if (!response.ok) {
+        const data = await response.json();
+        alert(data.error);
+        return;
+    }
+
+    current = null;
+    conversationCard.classList.add("d-none");
+    reloadConversations();
+});
+
+async function markRead() {
+    const response = await fetch(`/api/conversations/${current.id}/read`, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+
+    if (!response.ok) {
+        return;
+    }
+
+    showUnreadCount(data.unreadCount);
+}
+
+messageForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    if (!current || messageInput.value.trim() === "") {
+        return;
+    }
+
+    const response = await fetch(`/api/conversations/${current.id}/messages`, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({ content: messageInput.value }),
+    });
+
+    if (!response.ok) {
+        const data = await response.json();
+        alert(data.error);
+        return;
+    }
+
+    // The message itself arrives over the stream.
+    messageInput.value = "";
+});
+
+function createMessageElement(message) {
+    const item = document.createElement("li");
+    if (message.kind === "system") {
+        item.classList.add("mb-2", "text-center", "text-muted", "small");
+        item.innerText = message.content;
+        return item;
+    }
+
+    item.classList.add("mb-2");
+    if (message.senderId === me) {
+        item.classList.add("text-right");
+        item.dataset.createdAt = message.createdAt;
+    }
+
+    const content = document.createElement("span");
+    content.classList.add("d-inline-block", "px-3", "py-1", "rounded");
+    content.classList.add(message.senderId === me ? "bg-primary" : "bg-light");
+    if (message.senderId === me) {
+        content.classList.add("text-white");
+    }
+    content.innerText = message.content;
+
+    const receipt = document.createElement("small");
+    receipt.classList.add("d-block", "text-muted", "receipt");
+
+    item.appendChild(content);
+    item.appendChild(receipt);
+    showReceipt(item);
+
+    return item;
+}
+
+// showReceipt marks one of the user's own messages as seen once every
+// other member has read past it.
+function showReceipt(item) {
+    if (!item.dataset.createdAt) {
+        return;
+    }
+
+    const sent = new Date(item.dataset.createdAt);
+    const others = current.memberIds.filter((id) => id !== me);
+    const seen = others.length > 0 && others.every((id) => current.readAt[id] && new Date(current.readAt[id]) >= sent);
+    item.querySelector(".receipt").innerText = seen ? "Seen" : "";
+}
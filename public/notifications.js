@@ -0,0 +1,97 @@
+const notificationList = document.getElementById("notifications");
+const unreadCount = document.getElementById("unread_count");
+const readAllButton = document.getElementById("read_all_button");
+
+let nextCursor = "";
+let loadingNotifications = false;
+
+window.onload = async () => {
+    await loadNotifications();
+}
+
+async function loadNotifications() {
+    if (loadingNotifications || nextCursor === null) {
+        return;
+    }
+    loadingNotifications = true;
+
+    const query = nextCursor ? `?cursor=${encodeURIComponent(nextCursor)}` : "";
+    const response = await fetch(`/api/notifications${query}`, {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+    loadingNotifications = false;
+
+    if (!response.ok) {
+        return;
+    }
+
+    showUnreadCount(data.unreadCount);
+    data.notifications.forEach((notification) => {
+        notificationList.appendChild(createNotificationElement(notification));
+    });
+    nextCursor = data.nextCursor || null;
+}
+
+window.addEventListener("scroll", () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 200) {
+        loadNotifications();
+    }
+});
+
+readAllButton.addEventListener("click", async () => {
+    const response = await fetch("/api/notifications/read", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json"
+        },
+        body: JSON.stringify({ ids: [] }),
+    });
+
+    const data = await response.json();
+
+    if (!response.ok) {
+        return;
+    }
+
+    showUnreadCount(data.unreadCount);
+    notificationList.querySelectorAll(".font-weight-bold").forEach((element) => {
+        element.classList.remove("font-weight-bold");
+    });
+});
+
+function showUnreadCount(count) {
+    unreadCount.innerText = count > 0 ? count : "";
+}
+
+function createNotificationElement(notification) {
+    const item = document.createElement("li");
+    item.classList.add("media", "p-3", "border-bottom");
+
+    const body = document.createElement("div");
+    body.classList.add("media-body");
+    if (!notification.readAt) {
+        body.classList.add("font-weight-bold");
+    }
+
+    const summary = document.createElement("a");
+    summary.classList.add("text-dark");
+    summary.innerText = notification.summary;
+    if (notification.actors.length > 0) {
+        summary.href = `/profiles/${notification.actors[0].id}`;
+    }
+
+    const time = document.createElement("small");
+    time.classList.add("d-block", "text-muted");
+    time.innerText = new Date(notification.updatedAt).toLocaleString();
+
+    body.appendChild(summary);
+    body.appendChild(time);
+    item.appendChild(body);
+
+    return item;
+}
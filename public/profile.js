@@ -3,10 +3,26 @@ const followButton = document.getElementById("follow_button");
 const unfollowButton = document.getElementById("unfollow_button");
 const editButton = document.getElementById("edit_button");
 
+let nextCursor = "";
+let loadingPosts = false;
+
 window.onload = async () => {
-    const url = window.location.href;
-    const userId = url.substring(url.lastIndexOf("/") + 1);
-    const userPosts = await fetch(`/api/posts/${userId}`, {
+    loadUnreadBadge();
+    new EventSource("/api/stream").addEventListener("notification", () => {
+        loadUnreadBadge();
+    });
+    await loadPosts();
+}
+
+async function loadPosts() {
+    if (loadingPosts || nextCursor === null) {
+        return;
+    }
+    loadingPosts = true;
+
+    const userId = document.getElementById("profile_card").dataset.userId;
+    const query = nextCursor ? `?cursor=${encodeURIComponent(nextCursor)}` : "";
+    const userPosts = await fetch(`/api/posts/${userId}${query}`, {
         method: "GET",
         headers: {
             "Content-Type": "application/json",
@@ -14,16 +30,24 @@ window.onload = async () => {
     });
 
     const userPostsData = await userPosts.json();
+    loadingPosts = false;
 
     if (!userPosts.ok) {
         return;
     }
 
-    userPostsData.forEach((post) => {
+    userPostsData.posts.forEach((post) => {
         createPostElement(post);
     });
+    nextCursor = userPostsData.nextCursor || null;
 }
 
+window.addEventListener("scroll", () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 200) {
+        loadPosts();
+    }
+});
+
 function createPostElement(post) {
     const postBody = document.createElement("div");
     postBody.classList.add("user_post_body");
@@ -34,17 +58,27 @@ function createPostElement(post) {
     const postContent = document.createElement("p");
     postContent.classList.add("card-text");
     postContent.classList.add("text-justify");
-    postContent.innerText = post.content;
+    renderContent(postContent, post.content, post.entities);
 
     const postAuthor = document.createElement("h5");
     postAuthor.classList.add("card-text");
     postAuthor.classList.add("text-right");
     postAuthor.innerHTML = `<a href="/profiles/${post.authorId}" style="text-decoration: none; color: black">${post.author}</a>`;
 
+    const likeButton = createLikeButton(post);
+
     const hr = document.createElement("hr");
 
     postCard.appendChild(postAuthor);
-    postCard.appendChild(postContent);
+    if (post.repostOf) {
+        postAuthor.innerHTML += ` <small class="text-muted"><i class="fas fa-retweet"></i> reposted</small>`;
+    } else {
+        postCard.appendChild(postContent);
+    }
+    if (post.original) {
+        postCard.appendChild(createEmbeddedPost(post.original));
+    }
+    postCard.appendChild(likeButton);
     postBody.appendChild(postCard);
     postBody.appendChild(hr);
     userPosts.appendChild(postBody);
@@ -93,8 +127,7 @@ async function followUser(elementButton) {
 
     elementButton.disabled = true;
 
-    const url = window.location.href;
-    const userId = url.substring(url.lastIndexOf("/") + 1);
+    const userId = document.getElementById("profile_card").dataset.userId;
     await fetch(`/api/users/${userId}/follow`, {
         method: "POST",
         headers: {
@@ -113,8 +146,7 @@ async function removeFollow(elementButton) {
 
     elementButton.disabled = true;
 
-    const url = window.location.href;
-    const userId = url.substring(url.lastIndexOf("/") + 1);
+    const userId = document.getElementById("profile_card").dataset.userId;
     await fetch(`/api/users/${userId}/unfollow`, {
         method: "POST",
         headers: {
@@ -124,3 +156,102 @@ async function removeFollow(elementButton) {
 
     elementButton.disabled = false;
 }
+
+function createLikeButton(post) {
+    const likeButton = document.createElement("button");
+    likeButton.type = "button";
+    likeButton.classList.add("btn", "btn-sm", "btn-link");
+
+    let liked = post.likedByMe;
+    let count = post.likeCount || 0;
+    const render = () => {
+        likeButton.innerHTML = `<i class="${liked ? "fas" : "far"} fa-heart"></i> ${count}`;
+    };
+    render();
+
+    likeButton.addEventListener("click", async () => {
+        likeButton.disabled = true;
+        const response = await fetch(`/api/posts/${post.id}/like`, {
+            method: liked ? "DELETE" : "POST",
+        });
+        likeButton.disabled = false;
+
+        if (!response.ok) {
+            return;
+        }
+
+        const data = await response.json();
+        liked = data.likedByMe;
+        count = data.likeCount;
+        render();
+    });
+
+    return likeButton;
+}
+
+function createEmbeddedPost(original) {
+    const embedded = document.createElement("div");
+    embedded.classList.add("card", "card-body", "mb-2", "p-2");
+
+    if (original.unavailable) {
+        embedded.classList.add("text-muted");
+        embedded.innerText = "This post is unavailable.";
+        return embedded;
+    }
+
+    const author = document.createElement("h6");
+    author.innerHTML = `<a href="/profiles/${original.authorId}" style="text-decoration: none; color: black">${original.author}</a>`;
+
+    const content = document.createElement("p");
+    content.classList.add("card-text", "mb-0");
+    content.innerText = original.content;
+
+    embedded.appendChild(author);
+    embedded.appendChild(content);
+    return embedded;
+}
+
+// renderContent writes text into element, turning hashtags and mentions into
+// links. Entity offsets count code points, so the text is split with Array.from.
+function renderContent(element, text, entities) {
+    const chars = Array.from(text);
+    const links = [];
+    ((entities && entities.hashtags) || []).forEach((hashtag) => {
+        links.push({ start: hashtag.start, end: hashtag.end, href: `/tags/${encodeURIComponent(hashtag.tag)}` });
+    });
+    ((entities && entities.mentions) || []).forEach((mention) => {
+        links.push({ start: mention.start, end: mention.end, href: `/profiles/${mention.userId}` });
+    });
+    links.sort((a, b) => a.start - b.start);
+
+    let last = 0;
+    links.forEach((entity) => {
+        element.appendChild(document.createTextNode(chars.slice(last, entity.start).join("")));
+
+        const link = document.createElement("a");
+        link.href = entity.href;
+        link.innerText = chars.slice(entity.start, entity.end).join("");
+        element.appendChild(link);
+
+        last = entity.end;
+    });
+
+    element.appendChild(document.createTextNode(chars.slice(last).join("")));
+}
+
+async function loadUnreadBadge() {
+    const response = await fetch("/api/notifications/unread", {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+
+    if (!response.ok) {
+        return;
+    }
+
+    document.getElementById("notification_badge").innerText = data.unreadCount > 0 ? data.unreadCount : "";
+}
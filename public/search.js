@@ -0,0 +1,130 @@
+const results = document.getElementById("results");
+const resultCount = document.getElementById("result_count");
+const postsTab = document.getElementById("posts_tab");
+const usersTab = document.getElementById("users_tab");
+
+const params = new URLSearchParams(window.location.search);
+const query = params.get("q") || "";
+let type = params.get("type") === "users" ? "users" : "posts";
+
+let nextCursor = "";
+let loadingResults = false;
+
+window.onload = async () => {
+    document.getElementById("search").value = query;
+    showTab();
+    await loadResults();
+}
+
+function showTab() {
+    postsTab.classList.toggle("active", type === "posts");
+    usersTab.classList.toggle("active", type === "users");
+}
+
+async function switchTab(event, tab) {
+    event.preventDefault();
+    if (type === tab) {
+        return;
+    }
+
+    type = tab;
+    history.replaceState(null, "", `/search?q=${encodeURIComponent(query)}&type=${type}`);
+    showTab();
+    results.innerHTML = "";
+    nextCursor = "";
+    await loadResults();
+}
+
+postsTab.addEventListener("click", (event) => switchTab(event, "posts"));
+usersTab.addEventListener("click", (event) => switchTab(event, "users"));
+
+async function loadResults() {
+    if (loadingResults || nextCursor === null || query.trim() === "") {
+        return;
+    }
+    loadingResults = true;
+
+    const cursor = nextCursor ? `&cursor=${encodeURIComponent(nextCursor)}` : "";
+    const response = await fetch(`/api/search?q=${encodeURIComponent(query)}&type=${type}${cursor}`, {
+        method: "GET",
+        headers: {
+            "Content-Type": "application/json"
+        },
+    });
+
+    const data = await response.json();
+    loadingResults = false;
+
+    if (!response.ok) {
+        resultCount.innerText = data.error;
+        return;
+    }
+
+    resultCount.innerText = `${data.total} ${data.total === 1 ? "result" : "results"}`;
+    if (type === "posts") {
+        data.posts.forEach((post) => results.appendChild(createPostResult(post)));
+    } else {
+        data.users.forEach((user) => results.appendChild(createUserResult(user)));
+    }
+    nextCursor = data.nextCursor || null;
+}
+
+window.addEventListener("scroll", () => {
+    if (window.innerHeight + window.scrollY >= document.body.offsetHeight - 200) {
+        loadResults();
+    }
+});
+
+function createPostResult(post) {
+    const item = document.createElement("li");
+    item.classList.add("p-3", "border-bottom");
+
+    const author = document.createElement("h6");
+    author.innerHTML = `<a href="/profiles/${post.authorId}" style="text-decoration: none; color: black">${post.author}</a>`;
+
+    const content = document.createElement("p");
+    content.classList.add("card-text", "mb-0");
+    renderHighlights(content, post.content, post.highlights, "content");
+
+    item.appendChild(author);
+    item.appendChild(content);
+    return item;
+}
+
+function createUserResult(user) {
+    const item = document.createElement("li");
+    item.classList.add("p-3", "border-bottom");
+
+    const link = document.createElement("a");
+    link.href = user.username ? `/@${user.username}` : `/profiles/${user.id}`;
+    link.classList.add("text-dark");
+    renderHighlights(link, user.name, user.highlights, "name");
+
+    item.appendChild(link);
+    if (user.username) {
+        const username = document.createElement("small");
+        username.classList.add("text-muted", "ml-2");
+        username.appendChild(document.createTextNode("@"));
+        renderHighlights(username, user.username, user.highlights, "username");
+        item.appendChild(username);
+    }
+    return item;
+}
+
+// renderHighlights writes text into element, marking the matches in field.
+// Like entity offsets, highlight offsets count code points.
+function renderHighlights(element, text, highlights, field) {
+    const chars = Array.from(text);
+    let last = 0;
+    highlights.filter((highlight) => highlight.field === field).forEach((highlight) => {
+        element.appendChild(document.createTextNode(chars.slice(last, highlight.start).join("")));
+
+        const mark = document.createElement("mark");
+        mark.innerText = chars.slice(highlight.start, highlight.end).join("");
+        element.appendChild(mark);
+
+        last = highlight.end;
+    });
+
+    element.appendChild(document.createTextNode(chars.slice(last).join("")));
+}
@@ -0,0 +1,41 @@
+// Package pubsub carries live events from the handlers that cause them to
+// the streams of the users who should see them.
+package pubsub
+
+import "context"
+
+// ResetEvent is sent first on a resumed subscription when events after the
+// given id are no longer available, so the client should reload instead of
+// relying on the stream to fill the gap.
+const ResetEvent = "reset"
+
+// Message is one published event. Ids only mean something to the broker
+// that issued them; clients hand them back to resume.
+type Message struct {
+	Id    string
+	Topic string
+	Event string
+	Data  []byte
+}
+
+// Broker is what the handlers publish to and stream from. Hub keeps
+// everything in process; an implementation backed by an external broker
+// lets several servers share events.
+type Broker interface {
+	// Publish sends data, encoded as JSON, to everyone subscribed to topic.
+	Publish(ctx context.Context, topic string, event string, data interface{}) error
+	// Subscribe delivers messages published to topics from now on, preceded
+	// by those after lastEventId when it is not empty. The subscription ends
+	// when ctx is done.
+	Subscribe(ctx context.Context, topics []string, lastEventId string) (Subscription, error)
+}
+
+type Subscription interface {
+	// Messages is closed when the subscription ends, including when the
+	// subscriber falls too far behind; it can then resume from the last id
+	// it saw.
+	Messages() <-chan Message
+	Add(topics ...string)
+	Remove(topics ...string)
+	Close()
+}
@@ -0,0 +1,173 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many messages a subscriber may fall behind before
+// it is cut off.
+const subscriberBuffer = 64
+
+// Hub is an in-process Broker. It remembers the last messages published so
+// that a subscriber that reconnects soon enough misses nothing.
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Message
+	historySize int
+	subs        map[*subscription]bool
+}
+
+// NewHub returns a Hub that keeps the last historySize messages for resumed
+// subscriptions.
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		historySize: historySize,
+		subs:        make(map[*subscription]bool),
+	}
+}
+
+func (h *Hub) Publish(ctx context.Context, topic string, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	msg := Message{
+		Id:    h.epoch + "-" + strconv.FormatUint(h.seq, 10),
+		Topic: topic,
+		Event: event,
+		Data:  encoded,
+	}
+
+	h.history = append(h.history, msg)
+	if len(h.history) > h.historySize {
+		h.history = h.history[len(h.history)-h.historySize:]
+	}
+
+	for sub := range h.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+
+	return nil
+}
+
+func (h *Hub) Subscribe(ctx context.Context, topics []string, lastEventId string) (Subscription, error) {
+	sub := &subscription{hub: h, topics: make(map[string]bool, len(topics))}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var backlog []Message
+	if lastEventId != "" {
+		var complete bool
+		backlog, complete = h.since(lastEventId, sub.topics)
+		if !complete {
+			backlog = []Message{{Event: ResetEvent, Data: []byte("{}")}}
+		}
+	}
+
+	sub.messages = make(chan Message, subscriberBuffer+len(backlog))
+	for _, msg := range backlog {
+		sub.messages <- msg
+	}
+	h.subs[sub] = true
+
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	return sub, nil
+}
+
+// since returns the remembered messages on topics published after
+// lastEventId, and whether those are all of them. Callers must hold h.mu.
+func (h *Hub) since(lastEventId string, topics map[string]bool) ([]Message, bool) {
+	epoch, seqText, ok := strings.Cut(lastEventId, "-")
+	if !ok || epoch != h.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if err != nil || seq > h.seq {
+		return nil, false
+	}
+
+	// The oldest remembered message must directly follow the last one seen.
+	oldest := h.seq - uint64(len(h.history)) + 1
+	if seq+1 < oldest {
+		return nil, false
+	}
+
+	var backlog []Message
+	for _, msg := range h.history[seq+1-oldest:] {
+		if topics[msg.Topic] {
+			backlog = append(backlog, msg)
+		}
+	}
+	return backlog, true
+}
+
+// closeLocked ends sub. Callers must hold h.mu.
+func (h *Hub) closeLocked(sub *subscription) {
+	if h.subs[sub] {
+		delete(h.subs, sub)
+		close(sub.messages)
+	}
+}
+
+type subscription struct {
+	hub      *Hub
+	topics   map[string]bool
+	messages chan Message
+}
+
+func (s *subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+func (s *subscription) Add(topics ...string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+}
+
+func (s *subscription) Remove(topics ...string) {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(s.topics, topic)
+	}
+}
+
+func (s *subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.closeLocked(s)
+}
@@ -0,0 +1,75 @@
+// Package ratelimit throttles requests with token buckets and slows down
+// repeated login failures. Its state sits behind Store, so several servers
+// can share it.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Rule lets Limit requests through per Per on average, and up to Burst at
+// once. A zero Limit lets everything through.
+type Rule struct {
+	Limit int
+	Per   time.Duration
+	Burst int
+}
+
+// rate is how many tokens the rule's bucket gains each second.
+func (r Rule) rate() float64 {
+	return float64(r.Limit) / r.Per.Seconds()
+}
+
+// capacity is how many tokens the bucket holds when full.
+func (r Rule) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Limit)
+}
+
+// Backoff decides how long failures block further attempts. The first
+// FreeFailures cost nothing; each one after waits twice as long as the last,
+// from BaseDelay up to MaxDelay; and at LockoutFailures attempts stop for
+// Lockout. Failures are forgotten once Lockout has passed since the last
+// one stopped blocking.
+type Backoff struct {
+	FreeFailures    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutFailures int
+	Lockout         time.Duration
+}
+
+// Delay returns how long the failures'th failure in a row blocks for.
+func (b Backoff) Delay(failures int) time.Duration {
+	if b.LockoutFailures > 0 && failures >= b.LockoutFailures {
+		return b.Lockout
+	}
+	if failures <= b.FreeFailures {
+		return 0
+	}
+
+	delay := b.BaseDelay
+	for i := b.FreeFailures + 1; i < failures && delay < b.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+	return delay
+}
+
+// Store holds limiter state. Each method that refuses something says how
+// long until it would be allowed; zero means it is allowed now.
+type Store interface {
+	// Take spends a token from the bucket at key, which refills by rule.
+	Take(ctx context.Context, key string, rule Rule, now time.Time) (time.Duration, error)
+	// Blocked reports how long key stays blocked by earlier failures.
+	Blocked(ctx context.Context, key string, now time.Time) (time.Duration, error)
+	// Fail records a failure at key and blocks it as backoff says.
+	Fail(ctx context.Context, key string, backoff Backoff, now time.Time) (time.Duration, error)
+	// Reset forgets the failures at key, as after a successful login.
+	Reset(ctx context.Context, key string) error
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many calls go by between sweeps for state that no
+// longer matters, which keeps the maps from growing without bound.
+const sweepEvery = 1024
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled, after which it is the
+	// same as no bucket at all.
+	full time.Time
+}
+
+type failures struct {
+	count        int
+	blockedUntil time.Time
+	// forgetAt is when the failures stop counting.
+	forgetAt time.Time
+}
+
+// MemoryStore keeps limiter state in the process, so each server limits on
+// its own.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failures
+	calls    int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]*failures),
+	}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, rule Rule, now time.Time) (time.Duration, error) {
+	if rule.Limit <= 0 || rule.Per <= 0 {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	rate, capacity := rule.rate(), rule.capacity()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+
+	b.tokens--
+	b.full = now.Add(time.Duration((capacity - b.tokens) / rate * float64(time.Second)))
+	return 0, nil
+}
+
+func (m *MemoryStore) Blocked(ctx context.Context, key string, now time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.failures[key]
+	if !ok || !now.Before(f.blockedUntil) {
+		return 0, nil
+	}
+	return f.blockedUntil.Sub(now), nil
+}
+
+func (m *MemoryStore) Fail(ctx context.Context, key string, backoff Backoff, now time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	f, ok := m.failures[key]
+	if !ok || !now.Before(f.forgetAt) {
+		f = &failures{}
+		m.failures[key] = f
+	}
+
+	f.count++
+	delay := backoff.Delay(f.count)
+	f.blockedUntil = now.Add(delay)
+	f.forgetAt = f.blockedUntil.Add(backoff.Lockout)
+
+	return delay, nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
+
+// sweep drops full buckets and forgotten failures every so often. Callers
+// must hold m.mu.
+func (m *MemoryStore) sweep(now time.Time) {
+	m.calls++
+	if m.calls%sweepEvery != 0 {
+		return
+	}
+
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+	for key, f := range m.failures {
+		if !now.Before(f.forgetAt) {
+			delete(m.failures, key)
+		}
+	}
+}
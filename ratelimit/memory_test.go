@@ -0,0 +1,160 @@
+package ratelimit_test
+
+import (
+	"context"
+	"posts/ratelimit"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func take(t *testing.T, store ratelimit.Store, key string, rule ratelimit.Rule, now time.Time) time.Duration {
+	t.Helper()
+	wait, err := store.Take(context.Background(), key, rule, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestTakeAllowsABurstThenRefills(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rule := ratelimit.Rule{Limit: 2, Per: time.Second, Burst: 4}
+
+	for i := 0; i < 4; i++ {
+		if wait := take(t, store, "ip", rule, start); wait != 0 {
+			t.Fatalf("request %d of the burst waits %v", i+1, wait)
+		}
+	}
+	if wait := take(t, store, "ip", rule, start); wait != 500*time.Millisecond {
+		t.Errorf("request after the burst waits %v, want 500ms", wait)
+	}
+
+	// Half a second buys back one token at two a second.
+	if wait := take(t, store, "ip", rule, start.Add(500*time.Millisecond)); wait != 0 {
+		t.Errorf("request after waiting waits %v", wait)
+	}
+	if wait := take(t, store, "ip", rule, start.Add(500*time.Millisecond)); wait == 0 {
+		t.Error("a second request after waiting for one token got through")
+	}
+
+	// A long wait refills only up to the burst.
+	later := start.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		if wait := take(t, store, "ip", rule, later); wait != 0 {
+			t.Fatalf("request %d after an hour waits %v", i+1, wait)
+		}
+	}
+	if wait := take(t, store, "ip", rule, later); wait == 0 {
+		t.Error("the bucket refilled past its burst")
+	}
+}
+
+func TestTakeKeepsKeysApart(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	rule := ratelimit.Rule{Limit: 1, Per: time.Minute}
+
+	if wait := take(t, store, "alice", rule, start); wait != 0 {
+		t.Fatalf("alice's first request waits %v", wait)
+	}
+	if wait := take(t, store, "alice", rule, start); wait != time.Minute {
+		t.Errorf("alice's second request waits %v, want a minute", wait)
+	}
+	if wait := take(t, store, "bob", rule, start); wait != 0 {
+		t.Errorf("bob waits %v for alice's requests", wait)
+	}
+}
+
+func TestTakeWithoutALimitLetsEverythingThrough(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		if wait := take(t, store, "ip", ratelimit.Rule{}, start); wait != 0 {
+			t.Fatalf("request %d waits %v with no limit", i+1, wait)
+		}
+	}
+}
+
+func TestBackoffDoublesUpToTheLockout(t *testing.T) {
+	backoff := ratelimit.Backoff{
+		FreeFailures:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Second,
+		LockoutFailures: 7,
+		Lockout:         time.Hour,
+	}
+
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, time.Hour, time.Hour}
+	for i, delay := range want {
+		if got := backoff.Delay(i + 1); got != delay {
+			t.Errorf("failure %d blocks for %v, want %v", i+1, got, delay)
+		}
+	}
+}
+
+func TestFailBlocksUntilTheDelayPasses(t *testing.T) {
+	ctx := context.Background()
+	store := ratelimit.NewMemoryStore()
+	backoff := ratelimit.Backoff{FreeFailures: 1, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Lockout: time.Hour}
+
+	blocked := func(at time.Time) time.Duration {
+		t.Helper()
+		wait, err := store.Blocked(ctx, "alice@example.com", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait
+	}
+	fail := func(at time.Time) time.Duration {
+		t.Helper()
+		delay, err := store.Fail(ctx, "alice@example.com", backoff, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return delay
+	}
+
+	if delay := fail(start); delay != 0 {
+		t.Errorf("the free failure blocks for %v", delay)
+	}
+	if delay := fail(start); delay != 10*time.Second {
+		t.Errorf("the second failure blocks for %v, want 10s", delay)
+	}
+	if wait := blocked(start.Add(4 * time.Second)); wait != 6*time.Second {
+		t.Errorf("blocked for %v four seconds in, want 6s", wait)
+	}
+	if wait := blocked(start.Add(10 * time.Second)); wait != 0 {
+		t.Errorf("still blocked for %v once the delay has passed", wait)
+	}
+	if delay := fail(start.Add(10 * time.Second)); delay != 20*time.Second {
+		t.Errorf("the third failure blocks for %v, want 20s", delay)
+	}
+
+	if err := store.Reset(ctx, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := blocked(start.Add(11 * time.Second)); wait != 0 {
+		t.Errorf("blocked for %v after a reset", wait)
+	}
+	if delay := fail(start.Add(11 * time.Second)); delay != 0 {
+		t.Errorf("the first failure after a reset blocks for %v", delay)
+	}
+}
+
+func TestFailuresAreForgottenAfterTheLockout(t *testing.T) {
+	ctx := context.Background()
+	store := ratelimit.NewMemoryStore()
+	backoff := ratelimit.Backoff{BaseDelay: time.Second, MaxDelay: time.Minute, Lockout: time.Minute}
+
+	if _, err := store.Fail(ctx, "key", backoff, start); err != nil {
+		t.Fatal(err)
+	}
+	// The failure blocked for a second, and counts for a minute after that.
+	delay, err := store.Fail(ctx, "key", backoff, start.Add(time.Minute+2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delay != time.Second {
+		t.Errorf("failure after the first was forgotten blocks for %v, want the base delay", delay)
+	}
+}
@@ -0,0 +1,44 @@
+package repository
+
+import "errors"
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrUserExists           = errors.New("user already exists")
+	ErrPostNotFound         = errors.New("post not found")
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrSessionNotFound      = errors.New("session not found")
+	// ErrUsernameTaken means another account holds the username, either as
+	// its current one or as an old one that still redirects to it.
+	ErrUsernameTaken = errors.New("username is taken")
+	// ErrGroupFull means adding members would take a group past its limit.
+	ErrGroupFull = errors.New("group is full")
+	// ErrInvalidToken covers every emailed token that cannot be used: one
+	// that never existed, was for something else, expired or was used.
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrInvalidCode is a two-factor code that is wrong or already used.
+	ErrInvalidCode = errors.New("invalid code")
+
+	// ErrUnavailable matches any BackendError that is worth retrying later.
+	ErrUnavailable = errors.New("storage backend unavailable")
+)
+
+// BackendError reports a failure talking to the storage backend itself, as
+// opposed to a lookup that simply found nothing.
+type BackendError struct {
+	Op        string
+	Err       error
+	Temporary bool
+}
+
+func (e *BackendError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+func (e *BackendError) Is(target error) bool {
+	return target == ErrUnavailable && e.Temporary
+}
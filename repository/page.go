@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"posts/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest asks for at most Limit posts older than the one Cursor points
+// at. An empty Cursor starts at the newest post.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+}
+
+type PostPage struct {
+	Posts      []*models.Post `json:"posts"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// Cursor is the decoded position of the last post on a page. Feeds are
+// ordered by CreatedAt and then Id, both descending.
+type Cursor struct {
+	CreatedAt time.Time
+	Id        string
+}
+
+func EncodeCursor(post *models.Post) string {
+	return Cursor{CreatedAt: post.CreatedAt, Id: post.Id}.Encode()
+}
+
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + "|" + c.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor returns nil for an empty cursor.
+func DecodeCursor(cursor string) (*Cursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{CreatedAt: time.Unix(0, n).UTC(), Id: id}, nil
+}
+
+// After reports whether post comes after the cursor in feed order.
+func (c *Cursor) After(post *models.Post) bool {
+	return c.Precedes(post.CreatedAt, post.Id)
+}
+
+// Precedes reports whether the cursor comes before the item stamped at with
+// id, for lists that are not ordered by a post's CreatedAt.
+func (c *Cursor) Precedes(at time.Time, id string) bool {
+	if c == nil {
+		return true
+	}
+	if !at.Equal(c.CreatedAt) {
+		return at.Before(c.CreatedAt)
+	}
+	return id < c.Id
+}
+
+// SortPosts puts posts in feed order: newest first, ties broken by id so the
+// order is the same on every call.
+func SortPosts(posts []*models.Post) {
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i], posts[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.Id > b.Id
+	})
+}
+
+// NewPostPage builds a page from up to limit+1 posts in feed order; the
+// extra post, if present, only signals that another page exists.
+func NewPostPage(posts []*models.Post, limit int) *PostPage {
+	page := &PostPage{Posts: posts}
+	if page.Posts == nil {
+		page.Posts = make([]*models.Post, 0)
+	}
+
+	if len(posts) > limit {
+		page.Posts = posts[:limit]
+		page.NextCursor = EncodeCursor(page.Posts[limit-1])
+	}
+
+	return page
+}
+
+// NotificationPage is a page of an inbox, newest activity first. Its cursor
+// points at the UpdatedAt and Id of the last notification.
+type NotificationPage struct {
+	Notifications []*models.Notification `json:"notifications"`
+	NextCursor    string                 `json:"nextCursor,omitempty"`
+}
+
+// NewNotificationPage works like NewPostPage.
+func NewNotificationPage(notifications []*models.Notification, limit int) *NotificationPage {
+	page := &NotificationPage{Notifications: notifications}
+	if page.Notifications == nil {
+		page.Notifications = make([]*models.Notification, 0)
+	}
+
+	if len(notifications) > limit {
+		page.Notifications = notifications[:limit]
+		last := page.Notifications[limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.UpdatedAt, Id: last.Id}.Encode()
+	}
+
+	return page
+}
+
+// ConversationPage is a page of a user's conversations, most recently active
+// first. Its cursor points at the UpdatedAt and Id of the last conversation.
+type ConversationPage struct {
+	Conversations []*models.Conversation `json:"conversations"`
+	NextCursor    string                 `json:"nextCursor,omitempty"`
+}
+
+func NewConversationPage(conversations []*models.Conversation, limit int) *ConversationPage {
+	page := &ConversationPage{Conversations: conversations}
+	if page.Conversations == nil {
+		page.Conversations = make([]*models.Conversation, 0)
+	}
+
+	if len(conversations) > limit {
+		page.Conversations = conversations[:limit]
+		last := page.Conversations[limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.UpdatedAt, Id: last.Id}.Encode()
+	}
+
+	return page
+}
+
+// MessagePage is a page of a conversation, newest message first.
+type MessagePage struct {
+	Messages   []*models.Message `json:"messages"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+func NewMessagePage(messages []*models.Message, limit int) *MessagePage {
+	page := &MessagePage{Messages: messages}
+	if page.Messages == nil {
+		page.Messages = make([]*models.Message, 0)
+	}
+
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		last := page.Messages[limit-1]
+		page.NextCursor = Cursor{CreatedAt: last.CreatedAt, Id: last.Id}.Encode()
+	}
+
+	return page
+}
@@ -0,0 +1,89 @@
+package repository_test
+
+import (
+	"errors"
+	"posts/models"
+	"posts/repository"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrips(t *testing.T) {
+	post := &models.Post{Id: "b", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)}
+
+	cursor, err := repository.DecodeCursor(repository.EncodeCursor(post))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Id != post.Id || !cursor.CreatedAt.Equal(post.CreatedAt) {
+		t.Errorf("decoded cursor = %+v, want %v and %s", cursor, post.CreatedAt, post.Id)
+	}
+
+	empty, err := repository.DecodeCursor("")
+	if err != nil || empty != nil {
+		t.Errorf("DecodeCursor(\"\") = %v, %v, want nil, nil", empty, err)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, cursor := range []string{"!!!", "bm8tc2VwYXJhdG9y", "MTIzfA", "YWJjfGlk"} {
+		if _, err := repository.DecodeCursor(cursor); !errors.Is(err, repository.ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q): err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+// Posts made in the same instant are told apart by id, so none is skipped
+// or repeated across pages.
+func TestCursorAfterBreaksTiesOnId(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cursor, err := repository.DecodeCursor(repository.EncodeCursor(&models.Post{Id: "m", CreatedAt: at}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		post  models.Post
+		after bool
+	}{
+		{models.Post{Id: "z", CreatedAt: at.Add(-time.Second)}, true},
+		{models.Post{Id: "a", CreatedAt: at.Add(time.Second)}, false},
+		{models.Post{Id: "a", CreatedAt: at}, true},
+		{models.Post{Id: "m", CreatedAt: at}, false},
+		{models.Post{Id: "z", CreatedAt: at}, false},
+	}
+	for _, test := range tests {
+		if got := cursor.After(&test.post); got != test.after {
+			t.Errorf("After(%s at %v) = %v, want %v", test.post.Id, test.post.CreatedAt, got, test.after)
+		}
+	}
+
+	var start *repository.Cursor
+	if !start.After(&models.Post{Id: "a", CreatedAt: at}) {
+		t.Error("a nil cursor should start before every post")
+	}
+}
+
+func TestNewPostPageOnlyPointsAtAnotherPageWhenThereIsOne(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	posts := []*models.Post{
+		{Id: "c", CreatedAt: at},
+		{Id: "b", CreatedAt: at.Add(-time.Minute)},
+		{Id: "a", CreatedAt: at.Add(-2 * time.Minute)},
+	}
+
+	page := repository.NewPostPage(posts, 2)
+	if len(page.Posts) != 2 || page.NextCursor != repository.EncodeCursor(posts[1]) {
+		t.Errorf("page of 2 from 3 = %d posts, cursor %q", len(page.Posts), page.NextCursor)
+	}
+
+	page = repository.NewPostPage(posts[:2], 2)
+	if len(page.Posts) != 2 || page.NextCursor != "" {
+		t.Errorf("page of 2 from 2 = %d posts, cursor %q, want no cursor", len(page.Posts), page.NextCursor)
+	}
+
+	page = repository.NewPostPage(nil, 2)
+	if page.Posts == nil || len(page.Posts) != 0 {
+		t.Errorf("empty page posts = %#v, want an empty list", page.Posts)
+	}
+}
@@ -0,0 +1,182 @@
+// Package repository declares the storage interfaces the handlers depend on.
+// Each backend (Firestore, in-memory, file) implements them.
+package repository
+
+import (
+	"context"
+	"posts/models"
+	"time"
+)
+
+type AccountRepository interface {
+	// CreateAccount also reserves user.Username when it is set, failing with
+	// ErrUsernameTaken if another account holds it.
+	CreateAccount(ctx context.Context, user *models.User) error
+	FindAccountByEmail(ctx context.Context, email *string) (*models.User, error)
+	FindAccountByUuid(ctx context.Context, id string) (*models.User, error)
+	// FindAccountByUuidUncached is FindAccountByUuid without any caching,
+	// for checks of passwords, second factors and confirmed emails, which
+	// must see the account as it is now.
+	FindAccountByUuidUncached(ctx context.Context, id string) (*models.User, error)
+	// FindAccountByUsername takes a username in its lower-case stored form.
+	FindAccountByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindAccountByOldUsername finds the account an old username still
+	// redirects to. Current usernames are not matched.
+	FindAccountByOldUsername(ctx context.Context, username string) (*models.User, error)
+	AddFollower(ctx context.Context, followerId string, followingId string) error
+	RemoveFollower(ctx context.Context, followerId string, followingId string) error
+	GetDocumentIdByUuid(ctx context.Context, uuid string) (string, error)
+	IsFollowing(ctx context.Context, firstUuid string, secondUuid string) (bool, error)
+	GetFollowingIds(ctx context.Context, uuid string) ([]string, error)
+	// SetPendingEmail records an address the user wants to change to; it
+	// takes effect once VerifyEmail confirms it. An empty email cancels.
+	SetPendingEmail(ctx context.Context, docId string, email string) error
+	// VerifyEmail marks email as confirmed. If it is the account's pending
+	// address it replaces the current one, failing with ErrUserExists if
+	// another account has taken it meanwhile. Any other address fails with
+	// ErrInvalidToken.
+	VerifyEmail(ctx context.Context, docId string, email string) error
+	UpdateFirstName(ctx context.Context, docId string, firstName string) error
+	UpdateLastName(ctx context.Context, docId string, lastName string) error
+	// UpdateUsername switches the account to username and keeps the old one
+	// redirecting to it until redirectUntil.
+	UpdateUsername(ctx context.Context, docId string, username string, redirectUntil time.Time) error
+	// UpdatePassword hashes password as CreateAccount does and records
+	// changedAt, which makes reset links asked for before it stale.
+	UpdatePassword(ctx context.Context, docId string, password string, changedAt time.Time) error
+	// EnableTOTP turns on two-factor login with secret, replacing any
+	// recovery codes with recoveryCodes, which are hashes.
+	EnableTOTP(ctx context.Context, docId string, secret string, recoveryCodes []string) error
+	DisableTOTP(ctx context.Context, docId string) error
+	// UseTOTPStep records that the code for step was used, failing with
+	// ErrInvalidCode if it or a later one already was.
+	UseTOTPStep(ctx context.Context, docId string, step int64) error
+	// UseRecoveryCode removes the recovery code with hash, failing with
+	// ErrInvalidCode if the account does not have it.
+	UseRecoveryCode(ctx context.Context, docId string, hash string) error
+	// EachAccount calls fn with every account in no particular order,
+	// stopping at the first error, for jobs like rebuilding the search index.
+	EachAccount(ctx context.Context, fn func(*models.User) error) error
+}
+
+type PostsRepository interface {
+	AddPost(ctx context.Context, post *models.Post, author string, authorId string) error
+	// AddRepost stores post, a plain repost of post.RepostOf, unless authorId
+	// has a live repost of it already. Then it fills post with that one and
+	// returns false, so reposting twice is a no-op, like liking twice.
+	AddRepost(ctx context.Context, post *models.Post, author string, authorId string) (bool, error)
+	// AddReply stores reply under parentId and bumps the parent's ReplyCount.
+	AddReply(ctx context.Context, reply *models.Post, parentId string, author string, authorId string) error
+	// GetPost returns ErrPostNotFound for deleted posts too.
+	GetPost(ctx context.Context, postId string) (*models.Post, error)
+	// GetPostsByIds returns the live posts among postIds, keyed by id.
+	GetPostsByIds(ctx context.Context, postIds []string) (map[string]*models.Post, error)
+	UpdatePostContent(ctx context.Context, postId string, content string, entities models.Entities) (*models.Post, error)
+	DeletePost(ctx context.Context, postId string) error
+	// The List methods return top-level posts only; ListThread returns every
+	// reply in the thread started by rootId.
+	ListPosts(ctx context.Context, page PageRequest) (*PostPage, error)
+	ListPostsByAuthor(ctx context.Context, authorId string, page PageRequest) (*PostPage, error)
+	ListPostsByAuthors(ctx context.Context, authorIds []string, page PageRequest) (*PostPage, error)
+	ListThread(ctx context.Context, rootId string, page PageRequest) (*PostPage, error)
+	// ListPostsByTag takes a normalized tag and includes replies.
+	ListPostsByTag(ctx context.Context, tag string, page PageRequest) (*PostPage, error)
+	// EachPost is EachAccount for every live post, replies included.
+	EachPost(ctx context.Context, fn func(*models.Post) error) error
+}
+
+type LikesRepository interface {
+	// Like and Unlike are idempotent per user and return the post's like
+	// count afterwards.
+	Like(ctx context.Context, postId string, userId string) (int, error)
+	Unlike(ctx context.Context, postId string, userId string) (int, error)
+	// LikedPostIds reports which of postIds userId has liked.
+	LikedPostIds(ctx context.Context, userId string, postIds []string) (map[string]bool, error)
+}
+
+// NotificationsRepository stores each user's inbox.
+type NotificationsRepository interface {
+	// Record adds actorId to the recipient's unread notification of kind
+	// about postId, or starts a new one if there is none. An actor already
+	// on it moves to the front without being counted twice.
+	Record(ctx context.Context, recipientId string, kind string, postId string, actorId string, at time.Time) error
+	// Retract takes actorId off the recipient's unread notification of kind
+	// about postId, as when a like is undone, and deletes the notification
+	// once nobody is left on it. Read notifications are left as they were.
+	Retract(ctx context.Context, recipientId string, kind string, postId string, actorId string) error
+	List(ctx context.Context, recipientId string, page PageRequest) (*NotificationPage, error)
+	UnreadCount(ctx context.Context, recipientId string) (int, error)
+	// MarkRead marks the given notifications read, or every unread one when
+	// ids is empty. Ids that are not the recipient's are ignored.
+	MarkRead(ctx context.Context, recipientId string, ids []string, at time.Time) error
+}
+
+// MessagesRepository stores conversations and the messages in them. It does
+// not check membership; the handlers do.
+type MessagesRepository interface {
+	// CreateDirectConversation returns the one-to-one conversation between
+	// the two users, starting it if they have none yet.
+	CreateDirectConversation(ctx context.Context, userId string, otherId string) (*models.Conversation, error)
+	// CreateGroupConversation starts a named group owned by ownerId, who
+	// joins it along with memberIds.
+	CreateGroupConversation(ctx context.Context, ownerId string, name string, memberIds []string) (*models.Conversation, error)
+	GetConversation(ctx context.Context, conversationId string) (*models.Conversation, error)
+	ListConversations(ctx context.Context, userId string, page PageRequest) (*ConversationPage, error)
+	// AddMembers adds the users to a group, skipping any already in it, and
+	// records when they joined. It adds nobody and fails with ErrGroupFull
+	// if that would leave more than maxMembers in the group.
+	AddMembers(ctx context.Context, conversationId string, userIds []string, maxMembers int) error
+	// RemoveMember takes userId out of a group along with any admin role.
+	// When the owner goes, ownership passes to the longest-standing admin,
+	// or else the longest-standing member.
+	RemoveMember(ctx context.Context, conversationId string, userId string) error
+	SetAdmin(ctx context.Context, conversationId string, userId string, admin bool) error
+	// AddMessage stores message, counts it as unread for every member but
+	// its sender and makes it the conversation's last message.
+	AddMessage(ctx context.Context, message *models.Message) error
+	// ListMessages pages through the messages sent at or after since, so a
+	// member who joined late does not see what came before.
+	ListMessages(ctx context.Context, conversationId string, since time.Time, page PageRequest) (*MessagePage, error)
+	MarkConversationRead(ctx context.Context, conversationId string, userId string, at time.Time) error
+	// UnreadMessageCount adds up userId's unread messages over all their
+	// conversations.
+	UnreadMessageCount(ctx context.Context, userId string) (int, error)
+}
+
+// SessionsRepository keeps server-side sessions. Expired sessions are
+// never returned, as if they had been deleted.
+type SessionsRepository interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	// UpdateSession replaces the session's values and expiry, failing with
+	// ErrSessionNotFound if it has been revoked.
+	UpdateSession(ctx context.Context, id string, values []byte, expiresAt time.Time) error
+	// TouchSession records that the session was used at seenAt from ip.
+	TouchSession(ctx context.Context, id string, seenAt time.Time, ip string, userAgent string) error
+	// GetUserSessions lists the user's sessions, most recently seen first.
+	GetUserSessions(ctx context.Context, userId string) ([]*models.Session, error)
+	DeleteSession(ctx context.Context, id string) error
+	// DeleteUserSessions deletes every session of the user except the one
+	// with id except, which may be empty.
+	DeleteUserSessions(ctx context.Context, userId string, except string) error
+}
+
+// TokensRepository keeps the single-use tokens sent out by email.
+type TokensRepository interface {
+	CreateToken(ctx context.Context, token *models.Token) error
+	// ConsumeToken marks the token with hash used at at and returns it. It
+	// fails with ErrInvalidToken unless the token exists, is for purpose,
+	// has not expired and has not been used before.
+	ConsumeToken(ctx context.Context, purpose string, hash string, at time.Time) (*models.Token, error)
+}
+
+// Repositories bundles one backend's implementation of every interface.
+type Repositories struct {
+	Accounts      AccountRepository
+	Posts         PostsRepository
+	Likes         LikesRepository
+	Notifications NotificationsRepository
+	Messages      MessagesRepository
+	Tokens        TokensRepository
+	Sessions      SessionsRepository
+}
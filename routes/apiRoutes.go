@@ -1,93 +1,186 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"posts/firebase"
-	"posts/globals"
+	"posts/entities"
 	"posts/models"
+	"posts/notifications"
+	"posts/repository"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type profileDetails struct {
     Name string `json:"name"`
     Id string `json:"id"`
+    Username string `json:"username,omitempty"`
 }
 
-func EditProfile(w http.ResponseWriter, r *http.Request) {
+func (s *Server) EditProfile(w http.ResponseWriter, r *http.Request) {
+    sessionUuid, ok := s.sessionUserId(r)
+    if !ok {
+        writeJSONError(w, http.StatusUnauthorized, "not logged in")
+        return
+    }
+
     email := r.FormValue("email")
     firstName := r.FormValue("first_name")
     lastName := r.FormValue("last_name")
+    username := entities.NormalizeHandle(r.FormValue("username"))
+
+    if !validateEmail(email) {
+        writeJSONError(w, http.StatusBadRequest, "invalid email")
+        return
+    }
+
+    session, _ := s.session(r)
+    // Accounts made before usernames existed have none until they pick one.
+    sessionUsername, _ := session.Values["username"].(string)
+    hasUsernameChanged := username != sessionUsername
 
-    if !globals.ValidateEmail(email) {
-        http.Error(w, "Invalid email", http.StatusBadRequest)
+    if hasUsernameChanged && !validateUsername(username) {
+        writeJSONError(w, http.StatusBadRequest, "invalid username")
         return
     }
 
-    session, _ := globals.LoginCookie.Get(r, "login")
-    sessionEmail := session.Values["email"].(string)
-    sessionFirstName := session.Values["firstName"].(string)
-    sessionLastName := session.Values["lastName"].(string)
-    sessionUuid := session.Values["id"].(string)
+    sessionEmail, _ := session.Values["email"].(string)
+    sessionFirstName, _ := session.Values["firstName"].(string)
+    sessionLastName, _ := session.Values["lastName"].(string)
 
     hasEmailChanged := sessionEmail != email
     hasFirstNameChanged := sessionFirstName != firstName
     hasLastNameChanged := sessionLastName != lastName
 
-    var account firebase.AccountRepository = &firebase.Account{}
+    // Everything that can be refused is checked before anything is written,
+    // so a refused change leaves the profile as it was.
+    if hasUsernameChanged {
+        if err := s.checkUsernameFree(r.Context(), username, sessionUuid); err != nil {
+            writeError(w, err)
+            return
+        }
+    }
 
-    docId, err := account.GetDocumentIdByUuid(sessionUuid)
+    if hasEmailChanged {
+        _, err := s.accounts.FindAccountByEmail(r.Context(), &email)
+        if err == nil {
+            writeJSONError(w, http.StatusConflict, "email address is already in use")
+            return
+        }
+        if !errors.Is(err, repository.ErrUserNotFound) {
+            writeError(w, err)
+            return
+        }
+    }
+
+    docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), sessionUuid)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeError(w, err)
         return
     }
 
-    if hasEmailChanged {
-        err := account.UpdateEmail(docId, email)
+    // The username goes first: someone may still have claimed it since the
+    // check above.
+    if hasUsernameChanged {
+        redirectUntil := time.Now().UTC().AddDate(0, 0, s.config.UsernameRedirectDays)
+        err := s.accounts.UpdateUsername(r.Context(), docId, username, redirectUntil)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest)
+            writeError(w, err)
+            return
+        }
+    }
+
+    // A new address only replaces the old one once it has been confirmed.
+    if hasEmailChanged {
+        if err := s.accounts.SetPendingEmail(r.Context(), docId, email); err != nil {
+            writeError(w, err)
             return
         }
+        if err := s.sendVerification(r.Context(), sessionUuid, email); err != nil {
+            log.Println("Error sending verification email:", err)
+        }
     }
 
     if hasFirstNameChanged {
-        err := account.UpdateFirstName(docId, firstName)
+        err := s.accounts.UpdateFirstName(r.Context(), docId, firstName)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest)
+            writeError(w, err)
             return
         }
     }
 
     if hasLastNameChanged {
-        err := account.UpdateLastName(docId, lastName)
+        err := s.accounts.UpdateLastName(r.Context(), docId, lastName)
         if err != nil {
-            http.Error(w, err.Error(), http.StatusBadRequest)
+            writeError(w, err)
             return
         }
     }
 
-    if hasEmailChanged || hasFirstNameChanged || hasLastNameChanged {
-        session.Values["email"] = email
-        session.Values["firstName"] = firstName
-        session.Values["lastName"] = lastName
-        session.Save(r, w)
+    if hasFirstNameChanged || hasLastNameChanged || hasUsernameChanged {
+        s.indexUser(&models.User{Id: sessionUuid, FirstName: firstName, LastName: lastName, Username: username})
+
+        // Every device the user is logged in on shows the new name.
+        err := s.sessions.SetUserValues(r.Context(), sessionUuid, map[interface{}]interface{}{
+            "username":  username,
+            "firstName": firstName,
+            "lastName":  lastName,
+        })
+        if err != nil {
+            writeError(w, err)
+            return
+        }
     }
 
     http.Redirect(w, r, "/media", http.StatusSeeOther)
 }
 
-func FollowUser(w http.ResponseWriter, r *http.Request) {
+// checkUsernameFree fails with ErrUsernameTaken when username is another
+// account's, either its current one or an old one that still redirects.
+func (s *Server) checkUsernameFree(ctx context.Context, username string, userId string) error {
+    lookups := []func(context.Context, string) (*models.User, error){
+        s.accounts.FindAccountByUsername,
+        s.accounts.FindAccountByOldUsername,
+    }
+    for _, find := range lookups {
+        user, err := find(ctx, username)
+        if err == nil && user.Id != userId {
+            return repository.ErrUsernameTaken
+        }
+        if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *Server) FollowUser(w http.ResponseWriter, r *http.Request) {
     parts := strings.Split(r.URL.Path, "/")
     userId := parts[len(parts)-2]
     _, err := uuid.Parse(userId)
     if err != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+        writeError(w, repository.ErrUserNotFound)
+        return
+    }
+
+    // Resolved before the goroutines start: a panic in one of them would
+    // take the whole process down.
+    followerId, ok := s.sessionUserId(r)
+    if !ok {
+        writeJSONError(w, http.StatusUnauthorized, "not logged in")
+        return
+    }
+    if followerId == userId {
+        writeJSONError(w, http.StatusBadRequest, "cannot follow yourself")
         return
     }
 
@@ -100,43 +193,48 @@ func FollowUser(w http.ResponseWriter, r *http.Request) {
     wg.Add(2)
     go func() {
         defer wg.Done()
-        var account firebase.AccountRepository = &firebase.Account{}
-        session, _ := globals.LoginCookie.Get(r, "login")
-        followerId := session.Values["id"].(string)
-        followerDocumentId, followerErr = account.GetDocumentIdByUuid(followerId)
+        followerDocumentId, followerErr = s.accounts.GetDocumentIdByUuid(r.Context(), followerId)
     }()
     go func() {
         defer wg.Done()
-        var account firebase.AccountRepository = &firebase.Account{}
-        userDocumentId, userErr = account.GetDocumentIdByUuid(userId)
+        userDocumentId, userErr = s.accounts.GetDocumentIdByUuid(r.Context(), userId)
     }()
     wg.Wait()
 
-    if followerErr != nil || userErr != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+    if err := errors.Join(followerErr, userErr); err != nil {
+        writeError(w, err)
         return
     }
 
-    session, _ := globals.LoginCookie.Get(r, "login")
-    if session.Values["id"] == userId {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+    err = s.accounts.AddFollower(r.Context(), followerDocumentId, userDocumentId)
+    if err != nil {
+        writeError(w, err)
         return
     }
-
-    var account firebase.AccountRepository = &firebase.Account{}
-    account.AddFollower(followerDocumentId, userDocumentId)
+    s.notify(r.Context(), notifications.Follow, userId, followerId, "")
+    s.publishFollow(r.Context(), followerId, userId, true)
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func UnfollowUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UnfollowUser(w http.ResponseWriter, r *http.Request) {
     parts := strings.Split(r.URL.Path, "/")
     userId := parts[len(parts)-2]
     _, err := uuid.Parse(userId)
     if err != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+        writeError(w, repository.ErrUserNotFound)
+        return
+    }
+
+    followerId, ok := s.sessionUserId(r)
+    if !ok {
+        writeJSONError(w, http.StatusUnauthorized, "not logged in")
+        return
+    }
+    if followerId == userId {
+        writeJSONError(w, http.StatusBadRequest, "cannot follow yourself")
         return
     }
 
@@ -149,40 +247,32 @@ func UnfollowUser(w http.ResponseWriter, r *http.Request) {
     wg.Add(2)
     go func() {
         defer wg.Done()
-        var account firebase.AccountRepository = &firebase.Account{}
-        userDocumentId, userErr = account.GetDocumentIdByUuid(userId)
+        userDocumentId, userErr = s.accounts.GetDocumentIdByUuid(r.Context(), userId)
     }()
     go func() {
         defer wg.Done()
-        session, _ := globals.LoginCookie.Get(r, "login")
-        followerId := session.Values["id"].(string)
-        var account firebase.AccountRepository = &firebase.Account{}
-        followerDocumentId, followerErr = account.GetDocumentIdByUuid(followerId)
+        followerDocumentId, followerErr = s.accounts.GetDocumentIdByUuid(r.Context(), followerId)
     }()
     wg.Wait()
 
-    if userErr != nil || followerErr != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+    if err := errors.Join(followerErr, userErr); err != nil {
+        writeError(w, err)
         return
     }
 
-    session, _ := globals.LoginCookie.Get(r, "login")
-    if session.Values["id"] == userId {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+    err = s.accounts.RemoveFollower(r.Context(), followerDocumentId, userDocumentId)
+    if err != nil {
+        writeError(w, err)
         return
     }
-
-    var account firebase.AccountRepository = &firebase.Account{}
-    account.RemoveFollower(followerDocumentId, userDocumentId)
+    s.publishFollow(r.Context(), followerId, userId, false)
 
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func GetProfilePosts(w http.ResponseWriter, r *http.Request) {
-    var postsRepository firebase.PostsRepository = &firebase.Posts{}
-
+func (s *Server) GetProfilePosts(w http.ResponseWriter, r *http.Request) {
     vars := mux.Vars(r)
     authorId := vars["userId"]
 
@@ -191,16 +281,27 @@ func GetProfilePosts(w http.ResponseWriter, r *http.Request) {
         return
     }
     
-    var account firebase.AccountRepository = &firebase.Account{}
-    user, err := account.FindAccountByUuid(authorId)
+    user, err := s.accounts.FindAccountByUuid(r.Context(), authorId)
     if err != nil {
-        http.Redirect(w, r, "/media", http.StatusSeeOther)
+        writeError(w, err)
         return
     }
 
-    posts, err := postsRepository.GetPostByAuthorId(user.Id)
+    page, err := pageRequest(r)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        writeError(w, err)
+        return
+    }
+
+    posts, err := s.posts.ListPostsByAuthor(r.Context(), user.Id, page)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+
+    viewerId, _ := s.sessionUserId(r)
+    if err := s.decoratePosts(r.Context(), viewerId, posts.Posts); err != nil {
+        writeError(w, err)
         return
     }
 
@@ -208,69 +309,471 @@ func GetProfilePosts(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(posts)
 }
 
-func GetProfileDetailsOnMediaPage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetProfileDetailsOnMediaPage(w http.ResponseWriter, r *http.Request) {
     var user profileDetails
 
-    session, err := globals.LoginCookie.Get(r, "login")
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+    name, id, ok := s.sessionAuthor(r)
+    if !ok {
+        writeJSONError(w, http.StatusUnauthorized, "not logged in")
         return
     }
-
-    firstName := session.Values["firstName"].(string)
-    lastName := session.Values["lastName"].(string)
-    user.Name = firstName + " " + lastName
-    id := session.Values["id"].(string)
+    user.Name = name
     user.Id = id
 
+    session, _ := s.session(r)
+    user.Username, _ = session.Values["username"].(string)
+
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(user)
 }
 
-func AddPost(w http.ResponseWriter, r *http.Request) {
-	var post models.Post
-	err := json.NewDecoder(r.Body).Decode(&post)
+func (s *Server) AddPost(w http.ResponseWriter, r *http.Request) {
+	var body postBody
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid post body")
+		return
+	}
+	post := models.Post{Content: body.Content}
+
+	if strings.TrimSpace(post.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "post content is empty")
+		return
+	}
+
+	author, userId, ok := s.sessionAuthor(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if !s.requireVerified(w, r, userId) {
 		return
 	}
+	post.Author = author
+	post.AuthorId = userId
 
-	session, err := globals.LoginCookie.Get(r, "login")
+	post.Entities, err = s.parseEntities(r.Context(), post.Content)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
 
-	firstName := session.Values["firstName"].(string)
-	lastName := session.Values["lastName"].(string)
-	post.Author = firstName + " " + lastName
+	err = s.posts.AddPost(r.Context(), &post, post.Author, post.AuthorId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.notifyMentions(r.Context(), &post, models.Entities{})
+	s.indexPost(&post)
+	s.publishPost(r.Context(), &post)
 
-    userId := session.Values["id"].(string)
-    post.AuthorId = userId
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(post)
+}
 
-	var postsRepository firebase.PostsRepository = &firebase.Posts{}
-	err = postsRepository.AddPost(&post, post.Author, post.AuthorId)
+func (s *Server) GetPosts(w http.ResponseWriter, r *http.Request) {
+	page, err := pageRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
+		return
+	}
+
+	posts, err := s.posts.ListPosts(r.Context(), page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	viewerId, _ := s.sessionUserId(r)
+	if err := s.decoratePosts(r.Context(), viewerId, posts.Posts); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// GetTimeline serves the session user's home feed: their own posts and those
+// of the accounts they follow. GetPosts stays the global "explore" feed.
+func (s *Server) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	following, err := s.accounts.GetFollowingIds(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	posts, err := s.posts.ListPostsByAuthors(r.Context(), append(following, userId), page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.decoratePosts(r.Context(), userId, posts.Posts); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// postBody is what clients may send when writing a post; everything else on
+// models.Post is set by the server.
+type postBody struct {
+	Content string `json:"content"`
+}
+
+func (s *Server) EditPost(w http.ResponseWriter, r *http.Request) {
+	var edit postBody
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid post body")
+		return
+	}
+
+	if strings.TrimSpace(edit.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "post content is empty")
 		return
 	}
 
+	previous, ok := s.checkPostAuthor(w, r, mux.Vars(r)["postId"])
+	if !ok {
+		return
+	}
+
+	parsed, err := s.parseEntities(r.Context(), edit.Content)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	post, err := s.posts.UpdatePostContent(r.Context(), previous.Id, edit.Content, parsed)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.notifyMentions(r.Context(), post, previous.Entities)
+	s.indexPost(post)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(post)
 }
 
-func GetPosts(w http.ResponseWriter, r *http.Request) {
-	var postsRepository firebase.PostsRepository = &firebase.Posts{}
-	posts, err := postsRepository.GetPosts()
+func (s *Server) DeletePost(w http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	if _, ok := s.checkPostAuthor(w, r, postId); !ok {
+		return
+	}
+
+	err := s.posts.DeletePost(r.Context(), postId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err)
+		return
+	}
+	s.unindexPost(postId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) AddReply(w http.ResponseWriter, r *http.Request) {
+	var body postBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid post body")
+		return
+	}
+	reply := models.Post{Content: body.Content}
+
+	if strings.TrimSpace(reply.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "post content is empty")
+		return
+	}
+
+	author, authorId, ok := s.sessionAuthor(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if !s.requireVerified(w, r, authorId) {
+		return
+	}
+
+	var err error
+	reply.Entities, err = s.parseEntities(r.Context(), reply.Content)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.posts.AddReply(r.Context(), &reply, mux.Vars(r)["postId"], author, authorId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.notifyMentions(r.Context(), &reply, models.Entities{})
+	s.indexPost(&reply)
+	if parent, err := s.posts.GetPost(r.Context(), reply.ParentId); err == nil {
+		s.notify(r.Context(), notifications.Reply, parent.AuthorId, authorId, parent.Id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reply)
+}
+
+// Repost shares a post unchanged with the session user's followers.
+func (s *Server) Repost(w http.ResponseWriter, r *http.Request) {
+	s.share(w, r, models.Post{})
+}
+
+// QuotePost shares a post together with the session user's own text.
+func (s *Server) QuotePost(w http.ResponseWriter, r *http.Request) {
+	var body postBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid post body")
+		return
+	}
+
+	if strings.TrimSpace(body.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "post content is empty")
+		return
+	}
+
+	s.share(w, r, models.Post{Content: body.Content})
+}
+
+// share stores post as a repost of the {postId} post, or as a quote of it
+// when post has content. Reposting the same post again answers with the
+// first repost.
+func (s *Server) share(w http.ResponseWriter, r *http.Request, post models.Post) {
+	author, authorId, ok := s.sessionAuthor(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if !s.requireVerified(w, r, authorId) {
 		return
 	}
+
+	original, err := s.posts.GetPost(r.Context(), mux.Vars(r)["postId"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Reposting a plain repost shares the post it points at.
+	if original.RepostOf != "" {
+		original, err = s.posts.GetPost(r.Context(), original.RepostOf)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	status := http.StatusCreated
+	if post.Content == "" {
+		post.RepostOf = original.Id
+		created, err := s.posts.AddRepost(r.Context(), &post, author, authorId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !created {
+			status = http.StatusOK
+		}
+	} else {
+		post.QuoteOf = original.Id
+		post.Entities, err = s.parseEntities(r.Context(), post.Content)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = s.posts.AddPost(r.Context(), &post, author, authorId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if status == http.StatusCreated {
+		s.notifyMentions(r.Context(), &post, models.Entities{})
+		s.indexPost(&post)
+		if post.RepostOf != "" {
+			s.notify(r.Context(), notifications.Repost, original.AuthorId, authorId, original.Id)
+		} else {
+			s.notify(r.Context(), notifications.Quote, original.AuthorId, authorId, original.Id)
+		}
+	}
+
+	if err := s.decoratePosts(r.Context(), authorId, []*models.Post{&post}); err != nil {
+		writeError(w, err)
+		return
+	}
+	if status == http.StatusCreated {
+		s.publishPost(r.Context(), &post)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(post)
+}
+
+// GetTagPosts serves every post, replies included, that uses the {tag}
+// hashtag. The tag may be given with or without its '#' and in any case.
+func (s *Server) GetTagPosts(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeTag(mux.Vars(r)["tag"])
+	if tag == "" {
+		writeJSONError(w, http.StatusBadRequest, "tag is empty")
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	posts, err := s.posts.ListPostsByTag(r.Context(), tag, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	viewerId, _ := s.sessionUserId(r)
+	if err := s.decoratePosts(r.Context(), viewerId, posts.Posts); err != nil {
+		writeError(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(posts)
 }
 
-func SignupAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
+type thread struct {
+	Post    *models.Post         `json:"post"`
+	Replies *repository.PostPage `json:"replies"`
+}
+
+// GetThread serves the top-level post of the thread postId belongs to and a
+// page of every reply in it. Replies carry parentId so clients can nest them.
+func (s *Server) GetThread(w http.ResponseWriter, r *http.Request) {
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	post, err := s.posts.GetPost(r.Context(), mux.Vars(r)["postId"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if post.RootId != "" {
+		post, err = s.posts.GetPost(r.Context(), post.RootId)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	replies, err := s.posts.ListThread(r.Context(), post.Id, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	viewerId, _ := s.sessionUserId(r)
+	if err := s.decoratePosts(r.Context(), viewerId, append([]*models.Post{post}, replies.Posts...)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thread{Post: post, Replies: replies})
+}
+
+type likeState struct {
+	LikeCount int  `json:"likeCount"`
+	LikedByMe bool `json:"likedByMe"`
+}
+
+func (s *Server) LikePost(w http.ResponseWriter, r *http.Request) {
+	s.setLike(w, r, true)
+}
+
+func (s *Server) UnlikePost(w http.ResponseWriter, r *http.Request) {
+	s.setLike(w, r, false)
+}
+
+func (s *Server) setLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	postId := mux.Vars(r)["postId"]
+
+	var count int
+	var err error
+	if liked {
+		count, err = s.likes.Like(r.Context(), postId, userId)
+	} else {
+		count, err = s.likes.Unlike(r.Context(), postId, userId)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	post, err := s.posts.GetPost(r.Context(), postId)
+	if err == nil {
+		if liked {
+			s.notify(r.Context(), notifications.Like, post.AuthorId, userId, post.Id)
+		} else {
+			s.retract(r.Context(), notifications.Like, post.AuthorId, userId, post.Id)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(likeState{LikeCount: count, LikedByMe: liked})
+}
+
+// checkPostAuthor makes sure the session user wrote the post and returns it,
+// answering the request itself when they did not.
+func (s *Server) checkPostAuthor(w http.ResponseWriter, r *http.Request, postId string) (*models.Post, bool) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return nil, false
+	}
+
+	post, err := s.posts.GetPost(r.Context(), postId)
+	if err != nil {
+		writeError(w, err)
+		return nil, false
+	}
+
+	if post.AuthorId != userId {
+		writeJSONError(w, http.StatusForbidden, "you can only change your own posts")
+		return nil, false
+	}
+
+	return post, true
+}
+
+func (s *Server) SignupAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	err := r.ParseForm()
 	if err != nil {
@@ -282,30 +785,42 @@ func SignupAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 	user.Password = r.FormValue("password")
 	user.FirstName = r.FormValue("first_name")
 	user.LastName = r.FormValue("last_name")
+	user.Username = entities.NormalizeHandle(r.FormValue("username"))
 	confirmPassword := r.FormValue("confirm_password")
 
-	if user.Password != confirmPassword {
+	if !validateEmail(user.Email) {
+		http.Redirect(w, r, "/signup", http.StatusBadRequest)
+		return
+	}
+
+	if !validateUsername(user.Username) {
+		http.Redirect(w, r, "/signup", http.StatusBadRequest)
+		return
+	}
+
+	if validatePassword(user.Password, confirmPassword) != nil {
 	    http.Redirect(w, r, "/signup", http.StatusBadRequest)
         return
 	}
 
-	if len(user.Password) < 8 {
+	err = s.accounts.CreateAccount(r.Context(), &user)
+	if errors.Is(err, repository.ErrUserExists) || errors.Is(err, repository.ErrUsernameTaken) {
         http.Redirect(w, r, "/signup", http.StatusBadRequest)
 		return
 	}
-
-	var accountRepository firebase.AccountRepository = &firebase.Account{}
-
-	err = accountRepository.CreateAccount(&user)
 	if err != nil {
-        http.Redirect(w, r, "/signup", http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
+	s.indexUser(&user)
+	if err := s.sendVerification(r.Context(), user.Id, user.Email); err != nil {
+		log.Println("Error sending verification email:", err)
+	}
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
-func LoginAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -315,12 +830,21 @@ func LoginAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 
-	var accountRepository firebase.AccountRepository = &firebase.Account{}
-	user, err := accountRepository.FindAccountByEmail(&email)
-	if err != nil {
+	if s.loginBlocked(w, r, email) {
+		return
+	}
+
+	user, err := s.accounts.FindAccountByEmail(r.Context(), &email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		// Guesses at addresses count too, so they look no different.
+		s.loginFailed(r, email)
         http.Redirect(w, r, "/login", http.StatusBadRequest)
         return
 	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
 	if user == nil {
         http.Redirect(w, r, "/login", http.StatusUnauthorized)
@@ -329,27 +853,36 @@ func LoginAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
+		s.loginFailed(r, email)
 		http.Redirect(w, r, "/login", http.StatusBadRequest)
         return
 	}
 
-	session, err := globals.LoginCookie.Get(r, "login")
+	session, err := s.session(r)
 	if err != nil {
         http.Redirect(w, r, "/login", http.StatusInternalServerError)
 		return
 	}
 
-    session.Values["id"] = user.Id
-	session.Values["email"] = user.Email
-	session.Values["firstName"] = user.FirstName
-	session.Values["lastName"] = user.LastName
-	session.Values["loginTime"] = time.Now().Unix()
-	session.Values["authenticated"] = true
+	if err := s.sessions.Renew(r.Context(), session); err != nil {
+        http.Redirect(w, r, "/login", http.StatusInternalServerError)
+		return
+	}
 
-	session.Options.MaxAge = 60 * 60 * 24 * 7
-	session.Options.Secure = true
-	session.Options.SameSite = http.SameSiteStrictMode
-	session.Options.HttpOnly = true
+	// With two-factor login on, the session only becomes authenticated
+	// once the second step passes.
+	if user.TOTPSecret != "" {
+		s.startTwoFactor(session, user)
+		if err := session.Save(r, w); err != nil {
+            http.Redirect(w, r, "/login", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	s.startSession(session, user)
+	s.loginSucceeded(r, email)
 
 	err = session.Save(r, w)
 	if err != nil {
@@ -360,8 +893,24 @@ func LoginAfterCheckingTheDatabase(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func Logout(w http.ResponseWriter, r *http.Request) {
-	session, err := globals.LoginCookie.Get(r, "login")
+// startSession marks session as logged in as user.
+func (s *Server) startSession(session *sessions.Session, user *models.User) {
+    session.Values["id"] = user.Id
+	session.Values["email"] = user.Email
+	session.Values["username"] = user.Username
+	session.Values["firstName"] = user.FirstName
+	session.Values["lastName"] = user.LastName
+	session.Values["loginTime"] = time.Now().Unix()
+	session.Values["authenticated"] = true
+
+	session.Options.MaxAge = s.config.Cookie.MaxAge
+	session.Options.Secure = s.config.Cookie.Secure
+	session.Options.SameSite = http.SameSiteStrictMode
+	session.Options.HttpOnly = true
+}
+
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
 	if err != nil {
         http.Redirect(w, r, "/login", http.StatusInternalServerError)
 		return
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"posts/models"
+	"posts/repository"
+)
+
+// decoratePosts fills in the fields of posts that are not stored on them:
+// embedded originals of reposts and quotes, and whatever depends on who is
+// looking. viewerId is empty for anonymous requests.
+func (s *Server) decoratePosts(ctx context.Context, viewerId string, posts []*models.Post) error {
+	if err := s.embedOriginals(ctx, posts); err != nil {
+		return err
+	}
+
+	if viewerId == "" || len(posts) == 0 {
+		return nil
+	}
+
+	postIds := make([]string, 0, len(posts))
+	for _, post := range posts {
+		postIds = append(postIds, post.Id)
+	}
+
+	liked, err := s.likes.LikedPostIds(ctx, viewerId, postIds)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.LikedByMe = liked[post.Id]
+	}
+
+	return nil
+}
+
+// embedOriginals attaches the shared post to every repost and quote, marking
+// it unavailable once the original has been deleted.
+func (s *Server) embedOriginals(ctx context.Context, posts []*models.Post) error {
+	var originalIds []string
+	for _, post := range posts {
+		if id := sharedPostId(post); id != "" {
+			originalIds = append(originalIds, id)
+		}
+	}
+
+	if len(originalIds) == 0 {
+		return nil
+	}
+
+	originals, err := s.posts.GetPostsByIds(ctx, originalIds)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		id := sharedPostId(post)
+		if id == "" {
+			continue
+		}
+
+		original, ok := originals[id]
+		if !ok {
+			post.Original = &models.EmbeddedPost{Id: id, Unavailable: true}
+			continue
+		}
+
+		post.Original = &models.EmbeddedPost{
+			Id:        original.Id,
+			Author:    original.Author,
+			AuthorId:  original.AuthorId,
+			Content:   original.Content,
+			CreatedAt: &original.CreatedAt,
+		}
+	}
+
+	return nil
+}
+
+func sharedPostId(post *models.Post) string {
+	if post.RepostOf != "" {
+		return post.RepostOf
+	}
+	return post.QuoteOf
+}
+
+// summaryCache looks up each user shown on a page once.
+type summaryCache struct {
+	accounts repository.AccountRepository
+	users    map[string]*models.UserSummary
+}
+
+func (s *Server) userSummaries() *summaryCache {
+	return &summaryCache{accounts: s.accounts, users: make(map[string]*models.UserSummary)}
+}
+
+// lookup describes the users with ids, leaving out accounts that no longer
+// exist.
+func (c *summaryCache) lookup(ctx context.Context, ids []string) ([]models.UserSummary, error) {
+	summaries := make([]models.UserSummary, 0, len(ids))
+	for _, id := range ids {
+		summary, ok := c.users[id]
+		if !ok {
+			user, err := c.accounts.FindAccountByUuid(ctx, id)
+			if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+				return nil, err
+			}
+			if user != nil {
+				summary = &models.UserSummary{
+					Id:       user.Id,
+					Name:     user.FirstName + " " + user.LastName,
+					Username: user.Username,
+				}
+			}
+			c.users[id] = summary
+		}
+		if summary != nil {
+			summaries = append(summaries, *summary)
+		}
+	}
+	return summaries, nil
+}
@@ -0,0 +1,56 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"posts/entities"
+	"posts/models"
+	"posts/notifications"
+	"posts/repository"
+)
+
+// parseEntities finds the entities in content and resolves its @handles to
+// accounts. Handles nobody owns stay plain text.
+func (s *Server) parseEntities(ctx context.Context, content string) (models.Entities, error) {
+	parsed := entities.Parse(content)
+
+	owners := make(map[string]string)
+	for _, mention := range entities.Mentions(content) {
+		userId, seen := owners[mention.Handle]
+		if !seen {
+			user, err := s.accounts.FindAccountByUsername(ctx, mention.Handle)
+			if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+				return models.Entities{}, err
+			}
+			if user != nil {
+				userId = user.Id
+			}
+			owners[mention.Handle] = userId
+		}
+
+		if userId != "" {
+			parsed.Mentions = append(parsed.Mentions, models.MentionEntity{
+				UserId: userId,
+				Start:  mention.Start,
+				End:    mention.End,
+			})
+		}
+	}
+
+	return parsed, nil
+}
+
+// notifyMentions tells everyone post mentions, except anyone already
+// mentioned in previous, the entities of the post before an edit.
+func (s *Server) notifyMentions(ctx context.Context, post *models.Post, previous models.Entities) {
+	notified := make(map[string]bool)
+	for _, userId := range previous.MentionedUserIds() {
+		notified[userId] = true
+	}
+
+	for _, userId := range post.Entities.MentionedUserIds() {
+		if !notified[userId] {
+			s.notify(ctx, notifications.Mention, userId, post.AuthorId, post.Id)
+		}
+	}
+}
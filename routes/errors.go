@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"posts/repository"
+)
+
+var (
+	errInvalidLimit   = errors.New("limit must be a positive integer")
+	errTooManyMembers = errors.New("a group can have at most 100 members")
+	errUnverified     = errors.New("confirm your email address first")
+)
+
+// writeError answers with the status code that matches err and a JSON body
+// describing it. Details of server-side failures are logged, not sent.
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	message := err.Error()
+
+	if status >= http.StatusInternalServerError {
+		log.Println(err)
+		message = http.StatusText(status)
+	}
+
+	writeJSONError(w, status, message)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound), errors.Is(err, repository.ErrPostNotFound),
+		errors.Is(err, repository.ErrConversationNotFound), errors.Is(err, repository.ErrSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrInvalidCursor), errors.Is(err, errInvalidLimit),
+		errors.Is(err, errTooManyMembers), errors.Is(err, repository.ErrGroupFull),
+		errors.Is(err, repository.ErrInvalidToken),
+		errors.Is(err, repository.ErrInvalidCode):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnverified):
+		return http.StatusForbidden
+	case errors.Is(err, repository.ErrUserExists), errors.Is(err, repository.ErrUsernameTaken):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,28 +1,57 @@
 package routes
 
 import (
-	"html/template"
+	"errors"
 	"log"
 	"net/http"
 	"path"
-	"posts/firebase"
-	"posts/globals"
-	"strings"
+	"posts/entities"
+	"posts/models"
+	"posts/repository"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 type Username struct {
     Me string
+    // Id and Handle belong to the account the profile shows.
+    Id string
+    Handle string
     Name string
     IsMe bool
     IsFollowing bool
 }
 
-func ServeIndex(w http.ResponseWriter, r *http.Request) {
-	session, _ := globals.LoginCookie.Get(r, "login")
+type Feed struct {
+    Me string
+    // Name is the API feed the page loads: "timeline", "explore" or "tag".
+    Name string
+    // Tag is the normalized hashtag a "tag" feed shows.
+    Tag string
+}
+
+func (s *Server) ServeIndex(w http.ResponseWriter, r *http.Request) {
+    s.serveFeed(w, r, Feed{Name: "timeline"})
+}
+
+func (s *Server) ServeExplore(w http.ResponseWriter, r *http.Request) {
+    s.serveFeed(w, r, Feed{Name: "explore"})
+}
+
+func (s *Server) ServeTag(w http.ResponseWriter, r *http.Request) {
+    tag := entities.NormalizeTag(mux.Vars(r)["tag"])
+    if tag == "" {
+        http.NotFound(w, r)
+        return
+    }
+    s.serveFeed(w, r, Feed{Name: "tag", Tag: tag})
+}
 
-    if !isUserLoggedIn(w, r) {
+func (s *Server) serveFeed(w http.ResponseWriter, r *http.Request, feed Feed) {
+	session, _ := s.session(r)
+
+    if !s.isUserLoggedIn(w, r) {
         http.Redirect(w, r, "/login", http.StatusFound)
         return
     }
@@ -34,41 +63,27 @@ func ServeIndex(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    template := template.Must(template.ParseFiles(path.Join("public", "index.html")))
-
-    err := template.Execute(w, id)
+    feed.Me = id
+    err := s.templates.ExecuteTemplate(w, "index.html", feed)
     if err != nil {
         log.Println(err)
     }
 }
 
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
-    if !isUserLoggedIn(w, r) {
+func (s *Server) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+    if !s.isUserLoggedIn(w, r) {
         http.Redirect(w, r, "/login", http.StatusFound)
         return
     }
 
-    parts := strings.Split(r.URL.Path, "/")
-    if len(parts) != 3 {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
-        return
-    }
-    userId := parts[2]
-    _, err := uuid.Parse(userId)
-    if err != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
-        return
-    }
-
-    var account firebase.AccountRepository = &firebase.Account{}
-    user, err := account.FindAccountByUuid(userId)
-    if err != nil {
-        http.Redirect(w, r, "/media", http.StatusNotFound)
+    user, ok := s.profileUser(w, r)
+    if !ok {
         return
     }
+    userId := user.Id
 
     isMe := false
-    session, _ := globals.LoginCookie.Get(r, "login")
+    session, _ := s.session(r)
     id, ok := session.Values["id"].(string)
     if ok && id == userId {
         isMe = true
@@ -78,8 +93,8 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
     if isMe {
         isFollowing = true
     } else {
-        var account firebase.AccountRepository = &firebase.Account{}
-        isFollowing, err = account.IsFollowing(id, userId)
+        var err error
+        isFollowing, err = s.accounts.IsFollowing(r.Context(), id, userId)
         if err != nil {
             log.Println(err)
         }
@@ -88,67 +103,187 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 
     username := Username{
         Me: id,
+        Id: user.Id,
+        Handle: user.Username,
         Name: user.FirstName + " " + user.LastName,
         IsMe: isMe,
         IsFollowing: isFollowing,
     }
 
-    template := template.Must(template.ParseFiles(path.Join("public", "profile.html")))
-    err = template.Execute(w, username)
+    err := s.templates.ExecuteTemplate(w, "profile.html", username)
     if err != nil {
         log.Println(err)
     }
 }
 
-func SignupHandler(w http.ResponseWriter, r *http.Request) {
-	if isUserLoggedIn(w, r) {
+// profileUser finds the account a profile URL names, by id for
+// /profiles/{id} or by username for /@{username}. It answers the request
+// itself when there is nothing to show, sending old usernames on to the
+// account's current one.
+func (s *Server) profileUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+    vars := mux.Vars(r)
+
+    username, byUsername := vars["username"]
+    if !byUsername {
+        if _, err := uuid.Parse(vars["id"]); err != nil {
+            http.Redirect(w, r, "/media", http.StatusNotFound)
+            return nil, false
+        }
+    }
+
+    var user *models.User
+    var err error
+    if byUsername {
+        username = entities.NormalizeHandle(username)
+        user, err = s.accounts.FindAccountByUsername(r.Context(), username)
+        if errors.Is(err, repository.ErrUserNotFound) {
+            user, err = s.accounts.FindAccountByOldUsername(r.Context(), username)
+            if err == nil {
+                http.Redirect(w, r, "/@"+user.Username, http.StatusFound)
+                return nil, false
+            }
+        }
+    } else {
+        user, err = s.accounts.FindAccountByUuid(r.Context(), vars["id"])
+    }
+
+    if errors.Is(err, repository.ErrUserNotFound) {
+        http.Redirect(w, r, "/media", http.StatusNotFound)
+        return nil, false
+    }
+    if err != nil {
+        writeError(w, err)
+        return nil, false
+    }
+
+    return user, true
+}
+
+func (s *Server) NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+    userId, ok := s.sessionUserId(r)
+    if !ok || !s.isUserLoggedIn(w, r) {
+        http.Redirect(w, r, "/login", http.StatusFound)
+        return
+    }
+
+    err := s.templates.ExecuteTemplate(w, "notification.html", Feed{Me: userId})
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+func (s *Server) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+    userId, ok := s.sessionUserId(r)
+    if !ok || !s.isUserLoggedIn(w, r) {
+        http.Redirect(w, r, "/login", http.StatusFound)
+        return
+    }
+
+    err := s.templates.ExecuteTemplate(w, "messages.html", Feed{Me: userId})
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+    userId, ok := s.sessionUserId(r)
+    if !ok || !s.isUserLoggedIn(w, r) {
+        http.Redirect(w, r, "/login", http.StatusFound)
+        return
+    }
+
+    err := s.templates.ExecuteTemplate(w, "search.html", Feed{Me: userId})
+    if err != nil {
+        log.Println(err)
+    }
+}
+
+func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
+	if s.isUserLoggedIn(w, r) {
 		http.Redirect(w, r, "/media", http.StatusFound)
 		return
 	}
 
-	http.ServeFile(w, r, path.Join("public", "auth", "signup.html"))
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "signup.html"))
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if isUserLoggedIn(w, r) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if s.isUserLoggedIn(w, r) {
         http.Redirect(w, r, "/media", http.StatusFound)
         return
     }
 
-	http.ServeFile(w, r, path.Join("public", "auth", "login.html"))
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "login.html"))
+}
+
+// TwoFactorHandler asks for the code that finishes a login, if one is
+// waiting for it.
+func (s *Server) TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.session(r)
+	if _, ok := session.Values["pendingUserId"].(string); !ok {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "two-factor.html"))
 }
 
-func EditProfileHandler(w http.ResponseWriter, r *http.Request) {
-    if !isUserLoggedIn(w, r) {
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "forgot-password.html"))
+}
+
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "reset-password.html"))
+}
+
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, path.Join(s.config.PublicDir, "auth", "verify-email.html"))
+}
+
+func (s *Server) EditProfileHandler(w http.ResponseWriter, r *http.Request) {
+    userId, ok := s.sessionUserId(r)
+    if !ok || !s.isUserLoggedIn(w, r) {
         http.Redirect(w, r, "/login", http.StatusFound)
         return
     }
 
     type User struct {
         Email string
+        Username string
         FirstName string
         LastName string
+        Verified bool
+        PendingEmail string
     }
 
-    session, _ := globals.LoginCookie.Get(r, "login")
-    email := session.Values["email"].(string)
-    firstName := session.Values["firstName"].(string)
-    lastName := session.Values["lastName"].(string)
+    session, _ := s.session(r)
+    email, _ := session.Values["email"].(string)
+    username, _ := session.Values["username"].(string)
+    firstName, _ := session.Values["firstName"].(string)
+    lastName, _ := session.Values["lastName"].(string)
     user := User {
         Email: email,
+        Username: username,
         FirstName: firstName,
         LastName: lastName,
     }
 
-    template := template.Must(template.ParseFiles(path.Join("public", "editProfile", "edit-profile.html")))
-    err := template.Execute(w, user)
+    account, err := s.accounts.FindAccountByUuid(r.Context(), userId)
+    if err != nil {
+        writeError(w, err)
+        return
+    }
+    user.Verified = account.Verified
+    user.PendingEmail = account.PendingEmail
+
+    err = s.templates.ExecuteTemplate(w, "edit-profile.html", user)
     if err != nil {
         log.Println(err)
     }
 }
 
-func isUserLoggedIn(w http.ResponseWriter, r *http.Request) bool {
-    session, _ := globals.LoginCookie.Get(r, "login")
+func (s *Server) isUserLoggedIn(w http.ResponseWriter, r *http.Request) bool {
+    session, _ := s.session(r)
 
     if session.Values["authenticated"] == true {
         return true
@@ -0,0 +1,259 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"posts/models"
+	"posts/repository"
+
+	"github.com/gorilla/mux"
+)
+
+type membersBody struct {
+	UserIds []string `json:"userIds"`
+}
+
+// AddConversationMembers lets a group's admins bring more users into it.
+func (s *Server) AddConversationMembers(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if !conversation.IsAdmin(userId) {
+		writeJSONError(w, http.StatusForbidden, "only admins can add members")
+		return
+	}
+
+	var body membersBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid members body")
+		return
+	}
+
+	memberIds, err := s.newMemberIds(r.Context(), conversation, body.UserIds)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.messages.AddMembers(r.Context(), conversation.Id, memberIds, maxGroupMembers)
+	if errors.Is(err, repository.ErrGroupFull) {
+		// Others were added since the group was loaded.
+		err = errTooManyMembers
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, memberId := range memberIds {
+		s.systemMessage(r.Context(), conversation, userId, models.MemberAdded, memberId)
+		conversation.MemberIds = append(conversation.MemberIds, memberId)
+	}
+
+	s.writeConversation(w, r, userId, conversation.Id, http.StatusOK)
+}
+
+// RemoveConversationMember lets a group's admins take someone out of it.
+// Only the owner can remove another admin, and nobody can remove the owner.
+func (s *Server) RemoveConversationMember(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberGroup(w, r)
+	if !ok {
+		return
+	}
+
+	targetId := mux.Vars(r)["userId"]
+	switch {
+	case !conversation.IsAdmin(userId):
+		writeJSONError(w, http.StatusForbidden, "only admins can remove members")
+		return
+	case !conversation.HasMember(targetId):
+		writeJSONError(w, http.StatusNotFound, "user is not a member of this conversation")
+		return
+	case targetId == userId:
+		writeJSONError(w, http.StatusBadRequest, "leave the group to remove yourself")
+		return
+	case targetId == conversation.OwnerId:
+		writeJSONError(w, http.StatusForbidden, "the owner cannot be removed")
+		return
+	case conversation.IsAdmin(targetId) && userId != conversation.OwnerId:
+		writeJSONError(w, http.StatusForbidden, "only the owner can remove admins")
+		return
+	}
+
+	if err := s.messages.RemoveMember(r.Context(), conversation.Id, targetId); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.systemMessage(r.Context(), conversation, userId, models.MemberRemoved, targetId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveConversation takes the session user out of a group. If they owned
+// it, the repository hands it on and the group is told who has it now.
+func (s *Server) LeaveConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.messages.RemoveMember(r.Context(), conversation.Id, userId); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.systemMessage(r.Context(), conversation, userId, models.MemberLeft, "")
+
+	if conversation.OwnerId == userId {
+		updated, err := s.messages.GetConversation(r.Context(), conversation.Id)
+		if err != nil {
+			log.Println("Error loading conversation after owner left:", err)
+		} else if updated.OwnerId != "" {
+			s.systemMessage(r.Context(), updated, userId, models.OwnerChanged, updated.OwnerId)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddConversationAdmin lets the owner make a member an admin.
+func (s *Server) AddConversationAdmin(w http.ResponseWriter, r *http.Request) {
+	s.setConversationAdmin(w, r, true)
+}
+
+// RemoveConversationAdmin lets the owner take a member's admin role away.
+func (s *Server) RemoveConversationAdmin(w http.ResponseWriter, r *http.Request) {
+	s.setConversationAdmin(w, r, false)
+}
+
+func (s *Server) setConversationAdmin(w http.ResponseWriter, r *http.Request, admin bool) {
+	conversation, userId, ok := s.memberGroup(w, r)
+	if !ok {
+		return
+	}
+
+	targetId := mux.Vars(r)["userId"]
+	switch {
+	case userId != conversation.OwnerId:
+		writeJSONError(w, http.StatusForbidden, "only the owner can change admins")
+		return
+	case !conversation.HasMember(targetId):
+		writeJSONError(w, http.StatusNotFound, "user is not a member of this conversation")
+		return
+	case targetId == conversation.OwnerId:
+		writeJSONError(w, http.StatusBadRequest, "the owner is always an admin")
+		return
+	}
+
+	if conversation.IsAdmin(targetId) == admin {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := s.messages.SetAdmin(r.Context(), conversation.Id, targetId, admin); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	action := models.AdminRemoved
+	if admin {
+		action = models.AdminAdded
+	}
+	s.systemMessage(r.Context(), conversation, userId, action, targetId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberGroup is memberConversation for the handlers that only make sense
+// for group conversations.
+func (s *Server) memberGroup(w http.ResponseWriter, r *http.Request) (*models.Conversation, string, bool) {
+	conversation, userId, ok := s.memberConversation(w, r)
+	if !ok {
+		return nil, "", false
+	}
+
+	if conversation.Kind != models.GroupConversation {
+		writeJSONError(w, http.StatusBadRequest, "not a group conversation")
+		return nil, "", false
+	}
+
+	return conversation, userId, true
+}
+
+// newMemberIds checks that every user being added to conversation exists,
+// and returns those not already in it, without repeats.
+func (s *Server) newMemberIds(ctx context.Context, conversation *models.Conversation, userIds []string) ([]string, error) {
+	seen := make(map[string]bool, len(userIds))
+	var memberIds []string
+	for _, userId := range userIds {
+		if seen[userId] || conversation.HasMember(userId) {
+			continue
+		}
+		seen[userId] = true
+
+		if _, err := s.accounts.FindAccountByUuid(ctx, userId); err != nil {
+			return nil, err
+		}
+		memberIds = append(memberIds, userId)
+	}
+
+	if len(conversation.MemberIds)+len(memberIds) > maxGroupMembers {
+		return nil, errTooManyMembers
+	}
+
+	return memberIds, nil
+}
+
+// systemMessage records a change actorId made to the group and sends it to
+// everyone the change concerns, including a member who was just removed.
+// The change itself has already been saved, so a failure is only logged.
+func (s *Server) systemMessage(ctx context.Context, conversation *models.Conversation, actorId string, action string, targetId string) {
+	message := models.Message{
+		ConversationId: conversation.Id,
+		Kind:           models.SystemMessage,
+		SenderId:       actorId,
+		Action:         action,
+		TargetId:       targetId,
+	}
+
+	if err := s.messages.AddMessage(ctx, &message); err != nil {
+		log.Println("Error recording system message:", err)
+		return
+	}
+
+	if err := s.describeMessages(ctx, s.userSummaries(), []*models.Message{&message}); err != nil {
+		log.Println("Error describing system message:", err)
+	}
+
+	recipients := conversation.MemberIds
+	if targetId != "" && !conversation.HasMember(targetId) {
+		recipients = append(recipients[:len(recipients):len(recipients)], targetId)
+	}
+	for _, memberId := range recipients {
+		s.publish(ctx, userTopic(memberId), messageEvent, message)
+	}
+}
+
+// writeConversation answers with the conversation as it now stands.
+func (s *Server) writeConversation(w http.ResponseWriter, r *http.Request, userId string, conversationId string, status int) {
+	conversation, err := s.messages.GetConversation(r.Context(), conversationId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.describeConversations(r.Context(), userId, []*models.Conversation{conversation}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(conversation)
+}
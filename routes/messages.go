@@ -0,0 +1,365 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"posts/models"
+	"posts/repository"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type conversationBody struct {
+	// UserId is the other member of a direct conversation.
+	UserId string `json:"userId"`
+	// Name and MemberIds start a group conversation instead.
+	Name      string   `json:"name"`
+	MemberIds []string `json:"memberIds"`
+}
+
+// maxGroupName and maxGroupMembers bound what a group conversation may hold.
+const (
+	maxGroupName    = 80
+	maxGroupMembers = 100
+)
+
+// CreateConversation starts a direct conversation with another user, or
+// returns the one the two of them already have. A body with a name or
+// member ids starts a group owned by the session user instead.
+func (s *Server) CreateConversation(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	if !s.requireVerified(w, r, userId) {
+		return
+	}
+
+	var body conversationBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid conversation body")
+		return
+	}
+
+	if body.Name != "" || body.MemberIds != nil {
+		s.createGroup(w, r, userId, body)
+		return
+	}
+
+	if body.UserId == userId {
+		writeJSONError(w, http.StatusBadRequest, "cannot message yourself")
+		return
+	}
+
+	other, err := s.accounts.FindAccountByUuid(r.Context(), body.UserId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	conversation, err := s.messages.CreateDirectConversation(r.Context(), userId, other.Id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.describeConversations(r.Context(), userId, []*models.Conversation{conversation}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request, userId string, body conversationBody) {
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len([]rune(name)) > maxGroupName {
+		writeJSONError(w, http.StatusBadRequest, "group name must be 1 to 80 characters")
+		return
+	}
+
+	memberIds, err := s.newMemberIds(r.Context(), &models.Conversation{MemberIds: []string{userId}}, body.MemberIds)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	conversation, err := s.messages.CreateGroupConversation(r.Context(), userId, name, memberIds)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.systemMessage(r.Context(), conversation, userId, models.GroupCreated, "")
+
+	s.writeConversation(w, r, userId, conversation.Id, http.StatusCreated)
+}
+
+type conversationsPage struct {
+	*repository.ConversationPage
+	UnreadCount int `json:"unreadCount"`
+}
+
+// GetConversations serves the session user's conversations, most recently
+// active first, with their unread messages over all of them.
+func (s *Server) GetConversations(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	conversations, err := s.messages.ListConversations(r.Context(), userId, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unread, err := s.messages.UnreadMessageCount(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.describeConversations(r.Context(), userId, conversations.Conversations); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversationsPage{ConversationPage: conversations, UnreadCount: unread})
+}
+
+// GetMessages serves a page of a conversation, newest message first, from
+// when the session user joined it. Each message says which of the other
+// members have read it.
+func (s *Server) GetMessages(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberConversation(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	messages, err := s.messages.ListMessages(r.Context(), conversation.Id, conversation.JoinedAt[userId], page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.describeMessages(r.Context(), s.userSummaries(), messages.Messages); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, message := range messages.Messages {
+		for _, memberId := range conversation.MemberIds {
+			readAt, ok := conversation.ReadAt[memberId]
+			if memberId != message.SenderId && ok && !readAt.Before(message.CreatedAt) {
+				message.ReadBy = append(message.ReadBy, memberId)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+type messageBody struct {
+	Content string `json:"content"`
+}
+
+func (s *Server) SendMessage(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberConversation(w, r)
+	if !ok || !s.requireVerified(w, r, userId) {
+		return
+	}
+
+	var body messageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid message body")
+		return
+	}
+
+	if strings.TrimSpace(body.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "message is empty")
+		return
+	}
+
+	message := models.Message{
+		ConversationId: conversation.Id,
+		SenderId:       userId,
+		Content:        body.Content,
+	}
+
+	err := s.messages.AddMessage(r.Context(), &message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, memberId := range conversation.MemberIds {
+		s.publish(r.Context(), userTopic(memberId), messageEvent, message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+type readReceipt struct {
+	ConversationId string    `json:"conversationId"`
+	UserId         string    `json:"userId"`
+	ReadAt         time.Time `json:"readAt"`
+}
+
+// ReadConversation marks everything in a conversation read by the session
+// user and tells the other members.
+func (s *Server) ReadConversation(w http.ResponseWriter, r *http.Request) {
+	conversation, userId, ok := s.memberConversation(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	err := s.messages.MarkConversationRead(r.Context(), conversation.Id, userId, now)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for _, memberId := range conversation.MemberIds {
+		if memberId != userId {
+			s.publish(r.Context(), userTopic(memberId), readEvent, readReceipt{
+				ConversationId: conversation.Id,
+				UserId:         userId,
+				ReadAt:         now,
+			})
+		}
+	}
+
+	unread, err := s.messages.UnreadMessageCount(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unreadCount": unread})
+}
+
+// memberConversation loads the {conversationId} conversation for the
+// session user, answering the request itself when there is no such
+// conversation or they are not in it.
+func (s *Server) memberConversation(w http.ResponseWriter, r *http.Request) (*models.Conversation, string, bool) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return nil, "", false
+	}
+
+	conversation, err := s.messages.GetConversation(r.Context(), mux.Vars(r)["conversationId"])
+	if err != nil {
+		writeError(w, err)
+		return nil, "", false
+	}
+
+	if !conversation.HasMember(userId) {
+		writeJSONError(w, http.StatusForbidden, "you are not a member of this conversation")
+		return nil, "", false
+	}
+
+	return conversation, userId, true
+}
+
+// describeConversations fills in who is in each conversation and how many
+// of its messages viewerId has not read.
+func (s *Server) describeConversations(ctx context.Context, viewerId string, conversations []*models.Conversation) error {
+	users := s.userSummaries()
+	for _, conversation := range conversations {
+		members, err := users.lookup(ctx, conversation.MemberIds)
+		if err != nil {
+			return err
+		}
+		conversation.Members = members
+		conversation.UnreadCount = conversation.Unread[viewerId]
+
+		if conversation.LastMessage != nil {
+			err := s.describeMessages(ctx, users, []*models.Message{conversation.LastMessage})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// describeMessages writes out what happened in each system message.
+func (s *Server) describeMessages(ctx context.Context, users *summaryCache, messages []*models.Message) error {
+	for _, message := range messages {
+		if message.Kind != models.SystemMessage {
+			continue
+		}
+
+		ids := []string{message.SenderId}
+		if message.TargetId != "" {
+			ids = append(ids, message.TargetId)
+		}
+		described, err := users.lookup(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		names := make(map[string]string, len(described))
+		for _, user := range described {
+			names[user.Id] = user.Name
+		}
+		message.Content = systemText(message.Action, nameOr(names, message.SenderId), nameOr(names, message.TargetId))
+	}
+	return nil
+}
+
+// nameOr stands in for users who have since deleted their account.
+func nameOr(names map[string]string, userId string) string {
+	if name, ok := names[userId]; ok {
+		return name
+	}
+	return "Someone"
+}
+
+func systemText(action string, actor string, target string) string {
+	switch action {
+	case models.GroupCreated:
+		return actor + " created the group"
+	case models.MemberAdded:
+		return actor + " added " + target
+	case models.MemberRemoved:
+		return actor + " removed " + target
+	case models.MemberLeft:
+		return actor + " left"
+	case models.AdminAdded:
+		return actor + " made " + target + " an admin"
+	case models.AdminRemoved:
+		return actor + " removed " + target + " as an admin"
+	case models.OwnerChanged:
+		return target + " is now the owner"
+	default:
+		return ""
+	}
+}
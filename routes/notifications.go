@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"posts/models"
+	"posts/notifications"
+	"posts/repository"
+	"time"
+)
+
+// notify reports an event without failing the request that caused it, and
+// lets the recipient's open streams know.
+func (s *Server) notify(ctx context.Context, kind notifications.Kind, recipientId, actorId, postId string) {
+	event := notifications.Event{
+		Kind:        kind,
+		RecipientId: recipientId,
+		ActorId:     actorId,
+		PostId:      postId,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := s.notifier.Notify(ctx, event); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if recipientId != actorId {
+		s.publish(ctx, userTopic(recipientId), notificationEvent, pushedNotification{
+			Kind:    string(kind),
+			ActorId: actorId,
+			PostId:  postId,
+		})
+	}
+}
+
+// retract takes back a notification whose cause was undone, without failing
+// the request that undid it.
+func (s *Server) retract(ctx context.Context, kind notifications.Kind, recipientId, actorId, postId string) {
+	event := notifications.Event{
+		Kind:        kind,
+		RecipientId: recipientId,
+		ActorId:     actorId,
+		PostId:      postId,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := s.notifier.Retract(ctx, event); err != nil {
+		log.Println(err)
+	}
+}
+
+type pushedNotification struct {
+	Kind    string `json:"kind"`
+	ActorId string `json:"actorId"`
+	PostId  string `json:"postId,omitempty"`
+}
+
+type notificationsPage struct {
+	*repository.NotificationPage
+	UnreadCount int `json:"unreadCount"`
+}
+
+// GetNotifications serves a page of the session user's inbox, most recent
+// activity first, with the number of unread notifications.
+func (s *Server) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	inbox, err := s.inbox.List(r.Context(), userId, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unread, err := s.inbox.UnreadCount(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.describeNotifications(r.Context(), inbox.Notifications); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationsPage{NotificationPage: inbox, UnreadCount: unread})
+}
+
+// GetUnreadNotifications serves only the unread count, for badges that are
+// polled often.
+func (s *Server) GetUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	unread, err := s.inbox.UnreadCount(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unreadCount": unread})
+}
+
+type readBody struct {
+	// Ids lists the notifications to mark read; empty means all of them.
+	Ids []string `json:"ids"`
+}
+
+func (s *Server) ReadNotifications(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	var body readBody
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid read body")
+			return
+		}
+	}
+
+	err := s.inbox.MarkRead(r.Context(), userId, body.Ids, time.Now().UTC())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	unread, err := s.inbox.UnreadCount(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unreadCount": unread})
+}
+
+// shownActors is how many actors of a grouped notification are described.
+const shownActors = 3
+
+// describeNotifications fills in the names of each notification's most
+// recent actors and its one-line summary.
+func (s *Server) describeNotifications(ctx context.Context, inbox []*models.Notification) error {
+	users := s.userSummaries()
+	for _, notification := range inbox {
+		ids := notification.ActorIds
+		if len(ids) > shownActors {
+			ids = ids[:shownActors]
+		}
+
+		actors, err := users.lookup(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		notification.Actors = actors
+		notification.Summary = notifications.Summary(notification, notification.Actors)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"net/http"
+	"posts/repository"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageRequest reads the limit and cursor query parameters, clamping limit to
+// maxPageLimit.
+func pageRequest(r *http.Request) (repository.PageRequest, error) {
+	page := repository.PageRequest{
+		Limit:  defaultPageLimit,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return page, errInvalidLimit
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		page.Limit = limit
+	}
+
+	return page, nil
+}
@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"posts/mail"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePassword sets a new password for the logged-in user, who must give
+// their current one. Their other sessions end; this one carries on.
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
+	if err != nil || session.Values["authenticated"] != true {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	userId, _ := session.Values["id"].(string)
+
+	currentPassword := r.FormValue("current_password")
+	newPassword := r.FormValue("new_password")
+
+	if err := validatePassword(newPassword, r.FormValue("confirm_password")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)) != nil {
+		writeJSONError(w, http.StatusForbidden, "current password is wrong")
+		return
+	}
+
+	docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.accounts.UpdatePassword(r.Context(), docId, newPassword, now); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.sessions.RevokeOthers(r.Context(), userId, session); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.publishRevoked(r.Context(), userId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword emails a password reset link to the account with the given
+// address. The answer is the same whether or not there is one, so it can't
+// be used to find out who has an account.
+func (s *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := r.FormValue("email")
+	if !validateEmail(email) {
+		writeJSONError(w, http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	user, err := s.accounts.FindAccountByEmail(r.Context(), &email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ttl := time.Duration(s.config.PasswordResetMinutes) * time.Minute
+	secret, token, err := newToken(models.PasswordResetToken, user.Id, ttl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.tokens.CreateToken(r.Context(), token); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	link := s.config.BaseURL + "/reset-password?token=" + url.QueryEscape(secret)
+	err = s.mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. If it was you, follow this link within %d minutes to choose a new one:\n\n%s\n\nIf it wasn't, you can ignore this email; your password has not changed.\n",
+			s.config.PasswordResetMinutes, link),
+	})
+	if err != nil {
+		// Failing the request would tell the caller the account exists.
+		log.Println("Error sending password reset email:", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword uses an emailed token to set a new password. Every session
+// of the account ends, since one of them may be why it was reset.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	secret := r.FormValue("token")
+	newPassword := r.FormValue("new_password")
+
+	// The password is checked first so a typo doesn't use up the token.
+	if err := validatePassword(newPassword, r.FormValue("confirm_password")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	token, err := s.tokens.ConsumeToken(r.Context(), models.PasswordResetToken, hashToken(secret), now)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), token.UserId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// A link asked for before the password last changed is stale.
+	if user.PasswordChangedAt != nil && token.CreatedAt.Before(*user.PasswordChangedAt) {
+		writeError(w, repository.ErrInvalidToken)
+		return
+	}
+
+	docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), user.Id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.accounts.UpdatePassword(r.Context(), docId, newPassword, now); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.sessions.RevokeOthers(r.Context(), user.Id, nil); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.publishRevoked(r.Context(), user.Id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"posts/config"
+	"posts/ratelimit"
+	"posts/sessionstore"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountKey picks out the account a request acts for, or "" if it has
+// none, for per-account limits.
+type accountKey func(r *http.Request) string
+
+// formAccount keys a request by the email address in its form, for routes
+// used before anyone is logged in.
+func formAccount(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+}
+
+func (s *Server) sessionAccount(r *http.Request) string {
+	userId, _ := s.sessionUserId(r)
+	return userId
+}
+
+func rule(limit config.Limit) ratelimit.Rule {
+	return ratelimit.Rule{
+		Limit: limit.Requests,
+		Per:   time.Duration(limit.PeriodSeconds) * time.Second,
+		Burst: limit.Burst,
+	}
+}
+
+// limit wraps next in the rate limits configured for route, per client
+// address and, when account is given, per account.
+func (s *Server) limit(route string, account accountKey, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limits, ok := s.config.RateLimits[route]
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		now := time.Now()
+		wait, err := s.limiter.Take(r.Context(), route+":ip:"+sessionstore.ClientIP(r), rule(limits.PerIP), now)
+		if err == nil && wait == 0 && account != nil {
+			if key := account(r); key != "" {
+				wait, err = s.limiter.Take(r.Context(), route+":account:"+key, rule(limits.PerAccount), now)
+			}
+		}
+		// A limiter that can't be reached lets requests through rather than
+		// taking the site down with it.
+		if err != nil {
+			log.Println("Error checking rate limit:", err)
+		}
+		if wait > 0 {
+			writeTooManyRequests(w, wait)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeJSONError(w, http.StatusTooManyRequests, "too many requests, try again later")
+}
+
+func (s *Server) loginBackoff() ratelimit.Backoff {
+	backoff := s.config.LoginBackoff
+	return ratelimit.Backoff{
+		FreeFailures:    backoff.FreeFailures,
+		BaseDelay:       time.Duration(backoff.BaseDelaySeconds) * time.Second,
+		MaxDelay:        time.Duration(backoff.MaxDelaySeconds) * time.Second,
+		LockoutFailures: backoff.LockoutFailures,
+		Lockout:         time.Duration(backoff.LockoutMinutes) * time.Minute,
+	}
+}
+
+// loginKey counts failures per account and client address, so someone
+// guessing at an account from one address cannot lock its owner out of it
+// everywhere else. The login route's own limits throttle guessing from
+// many addresses.
+func loginKey(r *http.Request, email string) string {
+	return "login-failures:" + strings.ToLower(strings.TrimSpace(email)) + ":ip:" + sessionstore.ClientIP(r)
+}
+
+// loginBlocked answers with a 429 if failed logins to the account with
+// email from this client mean it has to wait.
+func (s *Server) loginBlocked(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, err := s.limiter.Blocked(r.Context(), loginKey(r, email), time.Now())
+	if err != nil {
+		log.Println("Error checking login backoff:", err)
+		return false
+	}
+	if wait > 0 {
+		writeTooManyRequests(w, wait)
+		return true
+	}
+	return false
+}
+
+// loginFailed counts a failed login, by password or two-factor code, to
+// the account with email from this client.
+func (s *Server) loginFailed(r *http.Request, email string) {
+	if _, err := s.limiter.Fail(r.Context(), loginKey(r, email), s.loginBackoff(), time.Now()); err != nil {
+		log.Println("Error recording failed login:", err)
+	}
+}
+
+func (s *Server) loginSucceeded(r *http.Request, email string) {
+	if err := s.limiter.Reset(r.Context(), loginKey(r, email)); err != nil {
+		log.Println("Error resetting login backoff:", err)
+	}
+}
@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"posts/models"
+	"posts/search"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxQueryLength bounds a search query, in characters.
+const maxQueryLength = 200
+
+// indexPost, unindexPost and indexUser bring the search index in line with
+// a change that has already been saved. Like notifications they are best
+// effort: a failure is logged, and running the server with -reindex puts
+// the index right.
+func (s *Server) indexPost(post *models.Post) {
+	if err := s.search.IndexPost(post); err != nil {
+		log.Println("Error indexing post:", err)
+	}
+}
+
+func (s *Server) unindexPost(postId string) {
+	if err := s.search.RemovePost(postId); err != nil {
+		log.Println("Error removing post from the search index:", err)
+	}
+}
+
+func (s *Server) indexUser(user *models.User) {
+	if err := s.search.IndexUser(user); err != nil {
+		log.Println("Error indexing user:", err)
+	}
+}
+
+type postResult struct {
+	*models.Post
+	Highlights []search.Highlight `json:"highlights"`
+}
+
+type userResult struct {
+	models.UserSummary
+	Highlights []search.Highlight `json:"highlights"`
+}
+
+type postResults struct {
+	Posts      []postResult `json:"posts"`
+	Total      int          `json:"total"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+type userResults struct {
+	Users      []userResult `json:"users"`
+	Total      int          `json:"total"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// Search serves the posts or users matching ?q, best match first. Each
+// result lists where in its text the query matched.
+func (s *Server) Search(w http.ResponseWriter, r *http.Request) {
+	viewerId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "search query is empty")
+		return
+	}
+	if utf8.RuneCountInString(query) > maxQueryLength {
+		writeJSONError(w, http.StatusBadRequest, "search query is too long")
+		return
+	}
+
+	kind := r.URL.Query().Get("type")
+	if kind == "" {
+		kind = search.PostsType
+	}
+	if kind != search.PostsType && kind != search.UsersType {
+		writeJSONError(w, http.StatusBadRequest, "type must be posts or users")
+		return
+	}
+
+	page, err := pageRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	results, err := s.search.Search(kind, query, page)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ids := make([]string, len(results.Hits))
+	for i, hit := range results.Hits {
+		ids[i] = hit.Id
+	}
+
+	var response interface{}
+	if kind == search.PostsType {
+		response, err = s.postResults(r, viewerId, results, ids)
+	} else {
+		response, err = s.userResults(r, results, ids)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// postResults loads the posts that were hit. One deleted since it was
+// indexed is left out.
+func (s *Server) postResults(r *http.Request, viewerId string, results *search.Results, ids []string) (*postResults, error) {
+	found, err := s.posts.GetPostsByIds(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &postResults{Posts: []postResult{}, Total: results.Total, NextCursor: results.NextCursor}
+	var posts []*models.Post
+	for _, hit := range results.Hits {
+		if post, ok := found[hit.Id]; ok {
+			response.Posts = append(response.Posts, postResult{Post: post, Highlights: hit.Highlights})
+			posts = append(posts, post)
+		}
+	}
+
+	if err := s.decoratePosts(r.Context(), viewerId, posts); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s *Server) userResults(r *http.Request, results *search.Results, ids []string) (*userResults, error) {
+	users, err := s.userSummaries().lookup(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]models.UserSummary, len(users))
+	for _, user := range users {
+		byId[user.Id] = user
+	}
+
+	response := &userResults{Users: []userResult{}, Total: results.Total, NextCursor: results.NextCursor}
+	for _, hit := range results.Hits {
+		if user, ok := byId[hit.Id]; ok {
+			response.Users = append(response.Users, userResult{UserSummary: user, Highlights: hit.Highlights})
+		}
+	}
+
+	return response, nil
+}
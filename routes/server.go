@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"html/template"
+	"net/http"
+	"path"
+	"posts/config"
+	"posts/mail"
+	"posts/notifications"
+	"posts/pubsub"
+	"posts/ratelimit"
+	"posts/repository"
+	"posts/search"
+	"posts/sessionstore"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// Server holds everything the handlers need, so each handler can be
+// exercised with fake repositories and several differently configured
+// servers can run side by side.
+type Server struct {
+	accounts  repository.AccountRepository
+	posts     repository.PostsRepository
+	likes     repository.LikesRepository
+	inbox     repository.NotificationsRepository
+	messages  repository.MessagesRepository
+	tokens    repository.TokensRepository
+	notifier  notifications.Notifier
+	broker    pubsub.Broker
+	search    search.Index
+	mailer    mail.Mailer
+	limiter   ratelimit.Store
+	sessions  *sessionstore.Store
+	templates *template.Template
+	config    *config.Config
+}
+
+func NewServer(repos *repository.Repositories, notifier notifications.Notifier, broker pubsub.Broker, index search.Index, mailer mail.Mailer, limiter ratelimit.Store, store *sessionstore.Store, cfg *config.Config) (*Server, error) {
+	templates, err := template.ParseFiles(
+		path.Join(cfg.PublicDir, "index.html"),
+		path.Join(cfg.PublicDir, "profile.html"),
+		path.Join(cfg.PublicDir, "notification.html"),
+		path.Join(cfg.PublicDir, "messages.html"),
+		path.Join(cfg.PublicDir, "search.html"),
+		path.Join(cfg.PublicDir, "editProfile", "edit-profile.html"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		accounts:  repos.Accounts,
+		posts:     repos.Posts,
+		likes:     repos.Likes,
+		inbox:     repos.Notifications,
+		messages:  repos.Messages,
+		tokens:    repos.Tokens,
+		notifier:  notifier,
+		broker:    broker,
+		search:    index,
+		mailer:    mailer,
+		limiter:   limiter,
+		sessions:  store,
+		templates: templates,
+		config:    cfg,
+	}, nil
+}
+
+func (s *Server) NewRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	router.PathPrefix("/public/").Handler(http.StripPrefix("/public/", http.FileServer(http.Dir(s.config.PublicDir))))
+
+	router.HandleFunc("/media", s.ServeIndex)
+	router.HandleFunc("/explore", s.ServeExplore)
+	router.HandleFunc("/tags/{tag}", s.ServeTag).Methods("GET")
+	router.HandleFunc("/", s.SignupHandler)
+	router.HandleFunc("/signup", s.SignupHandler)
+	router.HandleFunc("/login", s.LoginHandler)
+	router.HandleFunc("/login/two-factor", s.TwoFactorHandler).Methods("GET")
+	router.HandleFunc("/forgot-password", s.ForgotPasswordHandler).Methods("GET")
+	router.HandleFunc("/reset-password", s.ResetPasswordHandler).Methods("GET")
+	router.HandleFunc("/verify-email", s.VerifyEmailHandler).Methods("GET")
+	router.HandleFunc("/profiles/{id}", s.ProfileHandler).Methods("GET")
+	router.HandleFunc("/@{username}", s.ProfileHandler).Methods("GET")
+	router.HandleFunc("/settings/edit-profile", s.EditProfileHandler).Methods("GET")
+	router.HandleFunc("/notifications", s.NotificationsHandler).Methods("GET")
+	router.HandleFunc("/messages", s.MessagesHandler).Methods("GET")
+	router.HandleFunc("/search", s.SearchHandler).Methods("GET")
+
+	router.HandleFunc("/api/add-post", s.limit("add-post", s.sessionAccount, s.AddPost)).Methods("POST")
+	router.HandleFunc("/api/posts", s.GetPosts).Methods("GET")
+	router.HandleFunc("/api/timeline", s.GetTimeline).Methods("GET")
+	router.HandleFunc("/api/profile-details", s.GetProfileDetailsOnMediaPage).Methods("GET")
+	router.HandleFunc("/api/signup", s.limit("signup", nil, s.SignupAfterCheckingTheDatabase)).Methods("POST")
+	router.HandleFunc("/api/login", s.limit("login", formAccount, s.LoginAfterCheckingTheDatabase)).Methods("POST")
+	router.HandleFunc("/api/login/two-factor", s.TwoFactorLogin).Methods("POST")
+	router.HandleFunc("/api/users/{userId}/follow", s.FollowUser).Methods("POST")
+	router.HandleFunc("/api/users/{userId}/unfollow", s.UnfollowUser).Methods("POST")
+	router.HandleFunc("/api/logout", s.Logout)
+	router.HandleFunc("/api/posts/{userId}", s.GetProfilePosts).Methods("GET")
+	router.HandleFunc("/api/posts/{postId}", s.EditPost).Methods("PATCH")
+	router.HandleFunc("/api/posts/{postId}", s.DeletePost).Methods("DELETE")
+	router.HandleFunc("/api/posts/{postId}/like", s.LikePost).Methods("POST")
+	router.HandleFunc("/api/posts/{postId}/like", s.UnlikePost).Methods("DELETE")
+	router.HandleFunc("/api/posts/{postId}/replies", s.AddReply).Methods("POST")
+	router.HandleFunc("/api/posts/{postId}/repost", s.Repost).Methods("POST")
+	router.HandleFunc("/api/posts/{postId}/quote", s.QuotePost).Methods("POST")
+	router.HandleFunc("/api/posts/{postId}/thread", s.GetThread).Methods("GET")
+	router.HandleFunc("/api/tags/{tag}", s.GetTagPosts).Methods("GET")
+	router.HandleFunc("/api/settings/edit-profile", s.EditProfile).Methods("POST")
+	router.HandleFunc("/api/settings/password", s.ChangePassword).Methods("POST")
+	router.HandleFunc("/api/settings/two-factor", s.GetTwoFactor).Methods("GET")
+	router.HandleFunc("/api/settings/two-factor/setup", s.SetupTwoFactor).Methods("POST")
+	router.HandleFunc("/api/settings/two-factor/enable", s.EnableTwoFactor).Methods("POST")
+	router.HandleFunc("/api/settings/two-factor/disable", s.DisableTwoFactor).Methods("POST")
+	router.HandleFunc("/api/settings/sessions", s.GetSessions).Methods("GET")
+	router.HandleFunc("/api/settings/sessions", s.RevokeOtherSessions).Methods("DELETE")
+	router.HandleFunc("/api/settings/sessions/{sessionId}", s.RevokeSession).Methods("DELETE")
+	router.HandleFunc("/api/password/forgot", s.limit("forgot-password", formAccount, s.ForgotPassword)).Methods("POST")
+	router.HandleFunc("/api/password/reset", s.ResetPassword).Methods("POST")
+	router.HandleFunc("/api/settings/verify-email", s.limit("verify-email", s.sessionAccount, s.ResendVerification)).Methods("POST")
+	router.HandleFunc("/api/verify-email", s.VerifyEmail).Methods("POST")
+	router.HandleFunc("/api/search", s.Search).Methods("GET")
+	router.HandleFunc("/api/notifications", s.GetNotifications).Methods("GET")
+	router.HandleFunc("/api/notifications/unread", s.GetUnreadNotifications).Methods("GET")
+	router.HandleFunc("/api/notifications/read", s.ReadNotifications).Methods("POST")
+	router.HandleFunc("/api/stream", s.Stream).Methods("GET")
+	router.HandleFunc("/api/conversations", s.CreateConversation).Methods("POST")
+	router.HandleFunc("/api/conversations", s.GetConversations).Methods("GET")
+	router.HandleFunc("/api/conversations/{conversationId}/messages", s.GetMessages).Methods("GET")
+	router.HandleFunc("/api/conversations/{conversationId}/messages", s.SendMessage).Methods("POST")
+	router.HandleFunc("/api/conversations/{conversationId}/read", s.ReadConversation).Methods("POST")
+	router.HandleFunc("/api/conversations/{conversationId}/members", s.AddConversationMembers).Methods("POST")
+	router.HandleFunc("/api/conversations/{conversationId}/members/{userId}", s.RemoveConversationMember).Methods("DELETE")
+	router.HandleFunc("/api/conversations/{conversationId}/leave", s.LeaveConversation).Methods("POST")
+	router.HandleFunc("/api/conversations/{conversationId}/admins/{userId}", s.AddConversationAdmin).Methods("PUT")
+	router.HandleFunc("/api/conversations/{conversationId}/admins/{userId}", s.RemoveConversationAdmin).Methods("DELETE")
+
+	return router
+}
+
+func (s *Server) session(r *http.Request) (*sessions.Session, error) {
+	return s.sessions.Get(r, s.config.Cookie.Name)
+}
+
+// sessionUserId returns the id of the logged-in user, if any.
+func (s *Server) sessionUserId(r *http.Request) (string, bool) {
+	session, err := s.session(r)
+	if err != nil {
+		return "", false
+	}
+	id, ok := session.Values["id"].(string)
+	return id, ok && id != ""
+}
+
+// sessionAuthor returns the display name and id posts by the logged-in user
+// are stored under.
+func (s *Server) sessionAuthor(r *http.Request) (string, string, bool) {
+	session, err := s.session(r)
+	if err != nil {
+		return "", "", false
+	}
+	id, ok := session.Values["id"].(string)
+	if !ok || id == "" {
+		return "", "", false
+	}
+	firstName, _ := session.Values["firstName"].(string)
+	lastName, _ := session.Values["lastName"].(string)
+	return firstName + " " + lastName, id, true
+}
@@ -0,0 +1,559 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"posts/config"
+	"posts/mail"
+	"posts/memory"
+	"posts/models"
+	"posts/notifications"
+	"posts/pubsub"
+	"posts/ratelimit"
+	"posts/repository"
+	"posts/routes"
+	"posts/search"
+	"posts/sessionstore"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+const testPassword = "correct horse"
+
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+	repos   *repository.Repositories
+	config  *config.Config
+}
+
+// newTestServer serves the whole router from the memory backend, with the
+// given rate limits and emails written to a temporary directory.
+func newTestServer(t *testing.T, limits map[string]config.RouteLimit) *testServer {
+	t.Helper()
+
+	cfg := &config.Config{
+		Addr:          ":0",
+		PublicDir:     "../public",
+		Storage:       "memory",
+		SessionSecret: strings.Repeat("s", 32),
+		Cookie:        config.Cookie{Name: "login", MaxAge: 3600},
+		BaseURL:       "http://posts.test",
+		Mail:          config.Mail{Backend: "file", From: "posts@example.com", Dir: t.TempDir()},
+
+		PasswordResetMinutes:   30,
+		EmailVerificationHours: 24,
+		TOTPIssuer:             "Posts",
+		RateLimits:             limits,
+		LoginBackoff: config.LoginBackoff{
+			FreeFailures:     5,
+			BaseDelaySeconds: 1,
+			MaxDelaySeconds:  60,
+			LockoutFailures:  20,
+			LockoutMinutes:   15,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := memory.New().Repositories()
+	store := sessionstore.New(repos.Sessions, sessions.Options{Path: "/", MaxAge: cfg.Cookie.MaxAge, HttpOnly: true}, []byte(cfg.SessionSecret))
+	server, err := routes.NewServer(repos, notifications.NewInbox(repos.Notifications), pubsub.NewHub(16), search.New(),
+		mail.NewFileMailer(cfg.Mail.Dir, cfg.Mail.From), ratelimit.NewMemoryStore(), store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testServer{t: t, handler: server.NewRouter(), repos: repos, config: cfg}
+}
+
+// do sends a request, with form as its body when it is url.Values and as
+// JSON otherwise, and the session cookie if there is one.
+func (ts *testServer) do(method, target string, body interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.doFrom("", method, target, body, cookie)
+}
+
+// doFrom is do from the client address addr, or httptest's usual one when
+// addr is empty.
+func (ts *testServer) doFrom(addr, method, target string, body interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+	ts.t.Helper()
+
+	var reader io.Reader
+	contentType := ""
+	switch body := body.(type) {
+	case nil:
+	case url.Values:
+		reader = strings.NewReader(body.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = strings.NewReader(string(data))
+		contentType = "application/json"
+	}
+
+	r := httptest.NewRequest(method, target, reader)
+	if addr != "" {
+		r.RemoteAddr = addr
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	ts.handler.ServeHTTP(w, r)
+	return w
+}
+
+// createUser adds an account directly to storage, confirmed or not.
+func (ts *testServer) createUser(email, username string, verified bool) *models.User {
+	ts.t.Helper()
+	ctx := context.Background()
+
+	user := &models.User{Email: email, Username: username, Password: testPassword, FirstName: "Test", LastName: username}
+	if err := ts.repos.Accounts.CreateAccount(ctx, user); err != nil {
+		ts.t.Fatal(err)
+	}
+	if verified {
+		if err := ts.repos.Accounts.VerifyEmail(ctx, user.Id, email); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// login logs in through the API and returns the session cookie.
+func (ts *testServer) login(email string) *http.Cookie {
+	ts.t.Helper()
+
+	w := ts.do("POST", "/api/login", url.Values{"email": {email}, "password": {testPassword}}, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		ts.t.Fatalf("login %s: status %d, location %q", email, w.Code, w.Header().Get("Location"))
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ts.config.Cookie.Name {
+			return cookie
+		}
+	}
+	ts.t.Fatalf("login %s set no session cookie", email)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// lastEmailToken returns the token in the link of the newest email sent.
+func (ts *testServer) lastEmailToken() string {
+	ts.t.Helper()
+
+	entries, err := os.ReadDir(ts.config.Mail.Dir)
+	if err != nil || len(entries) == 0 {
+		ts.t.Fatalf("no email sent: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	data, err := os.ReadFile(filepath.Join(ts.config.Mail.Dir, names[len(names)-1]))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	match := tokenPattern.FindSubmatch(data)
+	if match == nil {
+		ts.t.Fatalf("no token in email:\n%s", data)
+	}
+	return string(match[1])
+}
+
+func expectStatus(t *testing.T, what string, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("%s: status %d, want %d; body %s", what, w.Code, status, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+func TestLoggedOutAndRevokedSessionsStopWorking(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("user@example.com", "user", true)
+
+	laptop := ts.login("user@example.com")
+	phone := ts.login("user@example.com")
+	expectStatus(t, "timeline on the laptop", ts.do("GET", "/api/timeline", nil, laptop), http.StatusOK)
+	expectStatus(t, "timeline on the phone", ts.do("GET", "/api/timeline", nil, phone), http.StatusOK)
+
+	expectStatus(t, "revoke other sessions", ts.do("DELETE", "/api/settings/sessions", nil, laptop), http.StatusNoContent)
+	expectStatus(t, "timeline on the revoked phone", ts.do("GET", "/api/timeline", nil, phone), http.StatusUnauthorized)
+	expectStatus(t, "timeline on the laptop after revoking", ts.do("GET", "/api/timeline", nil, laptop), http.StatusOK)
+
+	expectStatus(t, "logout", ts.do("GET", "/api/logout", nil, laptop), http.StatusSeeOther)
+	expectStatus(t, "timeline after logout", ts.do("GET", "/api/timeline", nil, laptop), http.StatusUnauthorized)
+}
+
+func TestPostPagesFollowTheCursor(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	const total = 7
+	for i := 0; i < total; i++ {
+		post := &models.Post{Content: "post " + strconv.Itoa(i)}
+		if err := ts.repos.Posts.AddPost(context.Background(), post, "Test author", "author"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var previous *models.Post
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > total {
+			t.Fatal("the cursor never ran out")
+		}
+
+		w := ts.do("GET", "/api/posts?limit=3&cursor="+url.QueryEscape(cursor), nil, nil)
+		expectStatus(t, "page "+strconv.Itoa(pages), w, http.StatusOK)
+		var page repository.PostPage
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Posts) > 3 {
+			t.Errorf("page %d has %d posts, more than the limit", pages, len(page.Posts))
+		}
+		for _, post := range page.Posts {
+			if seen[post.Id] {
+				t.Errorf("post %s on more than one page", post.Id)
+			}
+			seen[post.Id] = true
+			if previous != nil && post.CreatedAt.After(previous.CreatedAt) {
+				t.Errorf("post %s is newer than the one before it", post.Id)
+			}
+			previous = post
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	if len(seen) != total {
+		t.Errorf("pages held %d posts, want %d", len(seen), total)
+	}
+
+	expectStatus(t, "bad cursor", ts.do("GET", "/api/posts?cursor=garbage", nil, nil), http.StatusBadRequest)
+	expectStatus(t, "zero limit", ts.do("GET", "/api/posts?limit=0", nil, nil), http.StatusBadRequest)
+	expectStatus(t, "negative limit", ts.do("GET", "/api/posts?limit=-1", nil, nil), http.StatusBadRequest)
+}
+
+func TestRoutesNeedALoggedInUser(t *testing.T) {
+	ts := newTestServer(t, nil)
+	other := ts.createUser("other@example.com", "other", true)
+	post := &models.Post{Content: "hello"}
+	if err := ts.repos.Posts.AddPost(context.Background(), post, "Test other", other.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	forged := &http.Cookie{Name: ts.config.Cookie.Name, Value: "not-a-session"}
+	requests := []struct {
+		method, target string
+		body           interface{}
+	}{
+		{"POST", "/api/users/" + other.Id + "/follow", nil},
+		{"POST", "/api/users/" + other.Id + "/unfollow", nil},
+		{"POST", "/api/add-post", map[string]string{"content": "hi"}},
+		{"GET", "/api/timeline", nil},
+		{"GET", "/api/profile-details", nil},
+		{"POST", "/api/posts/" + post.Id + "/like", nil},
+		{"PATCH", "/api/posts/" + post.Id, map[string]string{"content": "changed"}},
+		{"DELETE", "/api/posts/" + post.Id, nil},
+		{"POST", "/api/settings/edit-profile", url.Values{"email": {"x@example.com"}, "username": {"someone"}}},
+		{"GET", "/api/settings/sessions", nil},
+		{"POST", "/api/settings/password", url.Values{"current_password": {testPassword}}},
+	}
+	for _, request := range requests {
+		what := request.method + " " + request.target
+		expectStatus(t, what, ts.do(request.method, request.target, request.body, nil), http.StatusUnauthorized)
+		expectStatus(t, what+" with a forged cookie", ts.do(request.method, request.target, request.body, forged), http.StatusUnauthorized)
+	}
+
+	stored, err := ts.repos.Posts.GetPost(context.Background(), post.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "hello" || stored.LikeCount != 0 {
+		t.Errorf("post changed by anonymous requests: %+v", stored)
+	}
+}
+
+func TestPostsNeedAConfirmedEmail(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("new@example.com", "newcomer", false)
+	cookie := ts.login("new@example.com")
+
+	expectStatus(t, "add post", ts.do("POST", "/api/add-post", map[string]string{"content": "hi"}, cookie), http.StatusForbidden)
+}
+
+func TestNewPostsNeedContent(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("user@example.com", "user", true)
+	cookie := ts.login("user@example.com")
+
+	expectStatus(t, "empty post", ts.do("POST", "/api/add-post", map[string]string{"content": ""}, cookie), http.StatusBadRequest)
+	expectStatus(t, "blank post", ts.do("POST", "/api/add-post", map[string]string{"content": " \n\t "}, cookie), http.StatusBadRequest)
+
+	page, err := ts.repos.Posts.ListPosts(context.Background(), repository.PageRequest{Limit: 10})
+	if err != nil || len(page.Posts) != 0 {
+		t.Errorf("posts after empty posts = %v, %v, want none", page, err)
+	}
+}
+
+func TestOnlyTheAuthorChangesAPost(t *testing.T) {
+	ts := newTestServer(t, nil)
+	author := ts.createUser("author@example.com", "author", true)
+	ts.createUser("reader@example.com", "reader", true)
+	post := &models.Post{Content: "mine"}
+	if err := ts.repos.Posts.AddPost(context.Background(), post, "Test author", author.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := ts.login("reader@example.com")
+	expectStatus(t, "edit someone else's post", ts.do("PATCH", "/api/posts/"+post.Id, map[string]string{"content": "theirs"}, reader), http.StatusForbidden)
+	expectStatus(t, "delete someone else's post", ts.do("DELETE", "/api/posts/"+post.Id, nil, reader), http.StatusForbidden)
+
+	stored, err := ts.repos.Posts.GetPost(context.Background(), post.Id)
+	if err != nil || stored.Content != "mine" {
+		t.Errorf("post after another user's edit = %+v, %v", stored, err)
+	}
+
+	own := ts.login("author@example.com")
+	expectStatus(t, "edit with no content", ts.do("PATCH", "/api/posts/"+post.Id, map[string]string{"content": "  "}, own), http.StatusBadRequest)
+	expectStatus(t, "edit own post", ts.do("PATCH", "/api/posts/"+post.Id, map[string]string{"content": "edited"}, own), http.StatusOK)
+	expectStatus(t, "delete own post", ts.do("DELETE", "/api/posts/"+post.Id, nil, own), http.StatusNoContent)
+	expectStatus(t, "delete it again", ts.do("DELETE", "/api/posts/"+post.Id, nil, own), http.StatusNotFound)
+}
+
+func TestLikingTwiceCountsOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+	author := ts.createUser("author@example.com", "author", true)
+	ts.createUser("fan@example.com", "fan", true)
+	post := &models.Post{Content: "like me"}
+	if err := ts.repos.Posts.AddPost(context.Background(), post, "Test author", author.Id); err != nil {
+		t.Fatal(err)
+	}
+	fan := ts.login("fan@example.com")
+
+	like := func(method string, wantCount int, wantLiked bool) {
+		t.Helper()
+		w := ts.do(method, "/api/posts/"+post.Id+"/like", nil, fan)
+		expectStatus(t, method+" like", w, http.StatusOK)
+		var state struct {
+			LikeCount int  `json:"likeCount"`
+			LikedByMe bool `json:"likedByMe"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+			t.Fatal(err)
+		}
+		if state.LikeCount != wantCount || state.LikedByMe != wantLiked {
+			t.Errorf("%s like = %d, %v, want %d, %v", method, state.LikeCount, state.LikedByMe, wantCount, wantLiked)
+		}
+	}
+
+	like("POST", 1, true)
+	like("POST", 1, true)
+	like("DELETE", 0, false)
+	like("DELETE", 0, false)
+
+	stored, err := ts.repos.Posts.GetPost(context.Background(), post.Id)
+	if err != nil || stored.LikeCount != 0 {
+		t.Errorf("stored post after liking and unliking = %+v, %v", stored, err)
+	}
+
+	expectStatus(t, "like without logging in", ts.do("POST", "/api/posts/"+post.Id+"/like", nil, nil), http.StatusUnauthorized)
+	expectStatus(t, "like a missing post", ts.do("POST", "/api/posts/missing/like", nil, fan), http.StatusNotFound)
+}
+
+func TestRepostingTwiceKeepsOneRepost(t *testing.T) {
+	ts := newTestServer(t, nil)
+	author := ts.createUser("author@example.com", "author", true)
+	ts.createUser("fan@example.com", "fan", true)
+	post := &models.Post{Content: "share me"}
+	if err := ts.repos.Posts.AddPost(context.Background(), post, "Test author", author.Id); err != nil {
+		t.Fatal(err)
+	}
+	fan := ts.login("fan@example.com")
+
+	repost := func(postId string, status int) models.Post {
+		t.Helper()
+		w := ts.do("POST", "/api/posts/"+postId+"/repost", nil, fan)
+		expectStatus(t, "repost "+postId, w, status)
+		var shared models.Post
+		if err := json.NewDecoder(w.Body).Decode(&shared); err != nil {
+			t.Fatal(err)
+		}
+		return shared
+	}
+
+	first := repost(post.Id, http.StatusCreated)
+	again := repost(post.Id, http.StatusOK)
+	// Reposting the repost shares the original, which fan has already shared.
+	viaRepost := repost(first.Id, http.StatusOK)
+	if again.Id != first.Id || viaRepost.Id != first.Id {
+		t.Errorf("reposts = %s, %s, %s, want one id", first.Id, again.Id, viaRepost.Id)
+	}
+
+	page, err := ts.repos.Posts.ListPosts(context.Background(), repository.PageRequest{Limit: 10})
+	if err != nil || len(page.Posts) != 2 {
+		t.Errorf("posts after reposting three times = %v, %v, want the post and one repost", page, err)
+	}
+}
+
+func TestRefusedProfileEditsChangeNothing(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("taken@example.com", "taken", true)
+	user := ts.createUser("user@example.com", "user", true)
+	cookie := ts.login("user@example.com")
+
+	edit := func(email, username string) {
+		t.Helper()
+		form := url.Values{"email": {email}, "username": {username}, "first_name": {"Test"}, "last_name": {"user"}}
+		expectStatus(t, "edit profile to "+email+" and "+username, ts.do("POST", "/api/settings/edit-profile", form, cookie), http.StatusConflict)
+	}
+	edit("taken@example.com", "newname")
+	edit("new@example.com", "taken")
+
+	stored, err := ts.repos.Accounts.FindAccountByUuid(context.Background(), user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Username != "user" || stored.PendingEmail != "" {
+		t.Errorf("after refused edits username = %q and pending email = %q, want both unchanged", stored.Username, stored.PendingEmail)
+	}
+}
+
+func TestEmailVerificationTokenWorksOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	w := ts.do("POST", "/api/signup", url.Values{
+		"email":            {"new@example.com"},
+		"username":         {"newcomer"},
+		"first_name":       {"New"},
+		"last_name":        {"Comer"},
+		"password":         {testPassword},
+		"confirm_password": {testPassword},
+	}, nil)
+	expectStatus(t, "signup", w, http.StatusSeeOther)
+	token := ts.lastEmailToken()
+
+	expectStatus(t, "wrong token", ts.do("POST", "/api/verify-email", url.Values{"token": {"wrong"}}, nil), http.StatusBadRequest)
+	expectStatus(t, "verify", ts.do("POST", "/api/verify-email", url.Values{"token": {token}}, nil), http.StatusNoContent)
+	expectStatus(t, "verify again", ts.do("POST", "/api/verify-email", url.Values{"token": {token}}, nil), http.StatusBadRequest)
+
+	email := "new@example.com"
+	user, err := ts.repos.Accounts.FindAccountByEmail(context.Background(), &email)
+	if err != nil || !user.Verified {
+		t.Errorf("account after verifying = %+v, %v", user, err)
+	}
+}
+
+func TestPasswordResetTokenWorksOnce(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("user@example.com", "user", true)
+	session := ts.login("user@example.com")
+
+	expectStatus(t, "forgot password", ts.do("POST", "/api/password/forgot", url.Values{"email": {"user@example.com"}}, nil), http.StatusAccepted)
+	token := ts.lastEmailToken()
+
+	reset := url.Values{"token": {token}, "new_password": {"a new password"}, "confirm_password": {"a new password"}}
+	mismatched := url.Values{"token": {token}, "new_password": {"a new password"}, "confirm_password": {"a typo"}}
+	expectStatus(t, "reset with a typo", ts.do("POST", "/api/password/reset", mismatched, nil), http.StatusBadRequest)
+	expectStatus(t, "reset", ts.do("POST", "/api/password/reset", reset, nil), http.StatusNoContent)
+	expectStatus(t, "reset again", ts.do("POST", "/api/password/reset", reset, nil), http.StatusBadRequest)
+
+	expectStatus(t, "timeline from before the reset", ts.do("GET", "/api/timeline", nil, session), http.StatusUnauthorized)
+
+	old := ts.do("POST", "/api/login", url.Values{"email": {"user@example.com"}, "password": {testPassword}}, nil)
+	if old.Code == http.StatusSeeOther {
+		t.Error("the old password still logs in")
+	}
+	ok := ts.do("POST", "/api/login", url.Values{"email": {"user@example.com"}, "password": {"a new password"}}, nil)
+	expectStatus(t, "login with the new password", ok, http.StatusSeeOther)
+}
+
+func TestFailedLoginsOnlyBlockTheAddressTheyCameFrom(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.createUser("user@example.com", "user", true)
+
+	login := func(addr, password string) *httptest.ResponseRecorder {
+		t.Helper()
+		return ts.doFrom(addr, "POST", "/api/login", url.Values{"email": {"user@example.com"}, "password": {password}}, nil)
+	}
+	// The test config lets five failures through, then blocks.
+	for i := 0; i < 6; i++ {
+		login("203.0.113.9:4000", "wrong")
+	}
+
+	expectStatus(t, "login from the guessing address", login("203.0.113.9:4000", testPassword), http.StatusTooManyRequests)
+	expectStatus(t, "login from the owner's address", login("198.51.100.7:5000", testPassword), http.StatusSeeOther)
+}
+
+func TestEmailSendingRoutesAreLimited(t *testing.T) {
+	ts := newTestServer(t, map[string]config.RouteLimit{
+		"forgot-password": {PerAccount: config.Limit{Requests: 1, PeriodSeconds: 3600}},
+		"verify-email":    {PerAccount: config.Limit{Requests: 1, PeriodSeconds: 3600}},
+	})
+	ts.createUser("user@example.com", "user", false)
+	session := ts.login("user@example.com")
+
+	forgot := url.Values{"email": {"user@example.com"}}
+	expectStatus(t, "first reset email", ts.do("POST", "/api/password/forgot", forgot, nil), http.StatusAccepted)
+	expectStatus(t, "second reset email", ts.do("POST", "/api/password/forgot", forgot, nil), http.StatusTooManyRequests)
+
+	expectStatus(t, "first verification email", ts.do("POST", "/api/settings/verify-email", nil, session), http.StatusAccepted)
+	expectStatus(t, "second verification email", ts.do("POST", "/api/settings/verify-email", nil, session), http.StatusTooManyRequests)
+}
+
+func TestRateLimitedRoutesSayWhenToRetry(t *testing.T) {
+	ts := newTestServer(t, map[string]config.RouteLimit{
+		"login":    {PerIP: config.Limit{Requests: 2, PeriodSeconds: 60}},
+		"add-post": {PerAccount: config.Limit{Requests: 1, PeriodSeconds: 30}},
+	})
+	ts.createUser("user@example.com", "user", true)
+
+	session := ts.login("user@example.com")
+	ts.login("user@example.com")
+	w := ts.do("POST", "/api/login", url.Values{"email": {"user@example.com"}, "password": {testPassword}}, nil)
+	expectStatus(t, "third login in a minute", w, http.StatusTooManyRequests)
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 60 {
+		t.Errorf("login Retry-After = %q, want 1 to 60 seconds", w.Header().Get("Retry-After"))
+	}
+
+	expectStatus(t, "first post", ts.do("POST", "/api/add-post", map[string]string{"content": "one"}, session), http.StatusOK)
+	w = ts.do("POST", "/api/add-post", map[string]string{"content": "two"}, session)
+	expectStatus(t, "second post", w, http.StatusTooManyRequests)
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 30 {
+		t.Errorf("add-post Retry-After = %q, want 1 to 30 seconds", w.Header().Get("Retry-After"))
+	}
+
+	page, err := ts.repos.Posts.ListPosts(context.Background(), repository.PageRequest{Limit: 10})
+	if err != nil || len(page.Posts) != 1 {
+		t.Errorf("posts after the limit = %v, %v, want 1", page, err)
+	}
+}
@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sessionInfo is what a user sees about one of their sessions.
+type sessionInfo struct {
+	Id         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	// Current marks the session the request was made with.
+	Current bool `json:"current"`
+}
+
+type sessionsList struct {
+	Sessions []sessionInfo `json:"sessions"`
+}
+
+// GetSessions lists where the logged-in user is logged in, most recently
+// used first.
+func (s *Server) GetSessions(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
+	if err != nil || session.Values["authenticated"] != true {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	userId, _ := session.Values["id"].(string)
+
+	stored, err := s.sessions.List(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	current := s.sessions.StoredId(session)
+	response := sessionsList{Sessions: make([]sessionInfo, 0, len(stored))}
+	for _, each := range stored {
+		response.Sessions = append(response.Sessions, sessionInfo{
+			Id:         each.Id,
+			Device:     deviceName(each.UserAgent),
+			UserAgent:  each.UserAgent,
+			IP:         each.IP,
+			CreatedAt:  each.CreatedAt,
+			LastSeenAt: each.LastSeenAt,
+			Current:    each.Id == current,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeSession logs one of the user's sessions out. It may be the current
+// one.
+func (s *Server) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	if err := s.sessions.Revoke(r.Context(), userId, mux.Vars(r)["sessionId"]); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.publishRevoked(r.Context(), userId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions logs the user out everywhere but here.
+func (s *Server) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
+	if err != nil || session.Values["authenticated"] != true {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	userId, _ := session.Values["id"].(string)
+
+	if err := s.sessions.RevokeOthers(r.Context(), userId, session); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.publishRevoked(r.Context(), userId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// browserNames are checked in order, since most browsers also claim to
+// be the ones before them: Edge says it is Chrome, and Chrome Safari.
+var (
+	browserNames = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systemNames = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// deviceName describes a user agent in a few words, like "Firefox on
+// Linux", for telling sessions apart.
+func deviceName(userAgent string) string {
+	browser, system := "", ""
+	for _, each := range browserNames {
+		if strings.Contains(userAgent, each.token) {
+			browser = each.name
+			break
+		}
+	}
+	for _, each := range systemNames {
+		if strings.Contains(userAgent, each.token) {
+			system = each.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
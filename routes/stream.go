@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"posts/models"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// heartbeatInterval keeps idle streams from being closed by proxies. Each
+// heartbeat also checks the session is still there, so a revoked session's
+// stream ends within one interval.
+const heartbeatInterval = 15 * time.Second
+
+// Events pushed on /api/stream.
+const (
+	// postEvent carries a new top-level post by someone the user follows.
+	postEvent = "post"
+	// notificationEvent tells the user something new is in their inbox.
+	notificationEvent = "notification"
+	// followerEvent reports someone following or unfollowing the user.
+	followerEvent = "follower"
+	// followingEvent reports the user following or unfollowing someone,
+	// possibly from another tab, so the stream can change what it carries.
+	followingEvent = "following"
+	// messageEvent carries a new message in one of the user's conversations.
+	messageEvent = "message"
+	// readEvent reports a member reading a conversation, for read receipts.
+	readEvent = "read"
+	// revokedEvent tells the user's streams some of their sessions were
+	// revoked. It is not passed on: each stream checks its own session.
+	revokedEvent = "revoked"
+)
+
+// userTopic carries everything addressed to one user.
+func userTopic(userId string) string {
+	return "user:" + userId
+}
+
+// postsTopic carries the new posts of one author.
+func postsTopic(authorId string) string {
+	return "posts:" + authorId
+}
+
+type followChange struct {
+	UserId    string `json:"userId"`
+	Following bool   `json:"following"`
+}
+
+// publish sends a live event without failing the request that caused it.
+func (s *Server) publish(ctx context.Context, topic string, event string, data interface{}) {
+	if err := s.broker.Publish(ctx, topic, event, data); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *Server) publishPost(ctx context.Context, post *models.Post) {
+	s.publish(ctx, postsTopic(post.AuthorId), postEvent, post)
+}
+
+// publishFollow tells both sides of a follow or unfollow.
+func (s *Server) publishFollow(ctx context.Context, followerId string, userId string, following bool) {
+	s.publish(ctx, userTopic(userId), followerEvent, followChange{UserId: followerId, Following: following})
+	s.publish(ctx, userTopic(followerId), followingEvent, followChange{UserId: userId, Following: following})
+}
+
+// publishRevoked makes the user's open streams check their sessions now
+// rather than at the next heartbeat.
+func (s *Server) publishRevoked(ctx context.Context, userId string) {
+	s.publish(ctx, userTopic(userId), revokedEvent, nil)
+}
+
+// Stream pushes Server-Sent Events to the session user: new posts for their
+// timeline, notifications and follower changes. A reconnecting client sends
+// Last-Event-ID and gets what it missed, or a "reset" event when that is no
+// longer possible.
+func (s *Server) Stream(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+	session, err := s.session(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	following, err := s.accounts.GetFollowingIds(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	topics := []string{userTopic(userId), postsTopic(userId)}
+	for _, id := range following {
+		topics = append(topics, postsTopic(id))
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	sub, err := s.broker.Subscribe(r.Context(), topics, lastEventId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !s.streamActive(r.Context(), session) {
+				return
+			}
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			if msg.Event == revokedEvent {
+				if !s.streamActive(r.Context(), session) {
+					return
+				}
+				continue
+			}
+			if msg.Event == followingEvent {
+				var change followChange
+				if err := json.Unmarshal(msg.Data, &change); err == nil {
+					if change.Following {
+						sub.Add(postsTopic(change.UserId))
+					} else {
+						sub.Remove(postsTopic(change.UserId))
+					}
+				}
+			}
+			if msg.Id != "" {
+				fmt.Fprintf(w, "id: %s\n", msg.Id)
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Event, msg.Data)
+		}
+		flusher.Flush()
+	}
+}
+
+// streamActive reports whether a stream's session may go on receiving
+// events. A failed check keeps the stream open; the next one decides.
+func (s *Server) streamActive(ctx context.Context, session *sessions.Session) bool {
+	active, err := s.sessions.Active(ctx, session)
+	if err != nil {
+		log.Println("Error checking session:", err)
+		return true
+	}
+	return active
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"posts/models"
+	"time"
+)
+
+// tokenBytes is how much randomness goes into an emailed token.
+const tokenBytes = 32
+
+// newToken makes a token for userId that works for ttl. The secret goes in
+// the link that is emailed; only its hash is stored.
+func newToken(purpose string, userId string, ttl time.Duration) (string, *models.Token, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	return secret, &models.Token{
+		Hash:      hashToken(secret),
+		Purpose:   purpose,
+		UserId:    userId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"posts/models"
+	"posts/repository"
+	"posts/totp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// twoFactorWindow is how long after the password step the code may be
+	// given before the login has to start over.
+	twoFactorWindow = 5 * time.Minute
+	// maxTwoFactorAttempts is how many wrong codes end a login.
+	maxTwoFactorAttempts = 5
+
+	recoveryCodeCount = 10
+	// recoveryAlphabet leaves out letters easily mistaken for digits. It has
+	// 32 characters, so each random byte maps onto it evenly.
+	recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+)
+
+// startTwoFactor puts session halfway through logging in as user. It holds
+// the user in pendingUserId rather than id, so nothing treats it as logged
+// in yet.
+func (s *Server) startTwoFactor(session *sessions.Session, user *models.User) {
+	session.Values["pendingUserId"] = user.Id
+	session.Values["pendingSince"] = time.Now().Unix()
+	session.Values["pendingAttempts"] = 0
+	session.Options.MaxAge = int(twoFactorWindow / time.Second)
+}
+
+// newRecoveryCodes returns fresh recovery codes, as shown to the user, and
+// their hashes, as stored.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		for j, b := range raw {
+			raw[j] = recoveryAlphabet[int(b)%len(recoveryAlphabet)]
+		}
+		codes[i] = string(raw[:5]) + "-" + string(raw[5:])
+		hashes[i] = hashToken(string(raw))
+	}
+	return codes, hashes, nil
+}
+
+// useSecondFactor uses up code for user: an authenticator code if it looks
+// like one, and otherwise one of their recovery codes. A wrong or reused
+// code fails with ErrInvalidCode.
+func (s *Server) useSecondFactor(ctx context.Context, user *models.User, code string) error {
+	docId, err := s.accounts.GetDocumentIdByUuid(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return repository.ErrInvalidCode
+		}
+		return s.accounts.UseTOTPStep(ctx, docId, step)
+	}
+
+	return s.accounts.UseRecoveryCode(ctx, docId, hashToken(code))
+}
+
+// TwoFactorLogin finishes a login that is waiting for a two-factor code.
+func (s *Server) TwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	session, err := s.session(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	userId, _ := session.Values["pendingUserId"].(string)
+	since, _ := session.Values["pendingSince"].(int64)
+	if userId == "" || time.Since(time.Unix(since, 0)) > twoFactorWindow {
+		writeJSONError(w, http.StatusUnauthorized, "log in again")
+		return
+	}
+
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if s.loginBlocked(w, r, user.Email) {
+		return
+	}
+
+	err = s.useSecondFactor(r.Context(), user, r.FormValue("code"))
+	if errors.Is(err, repository.ErrInvalidCode) {
+		s.loginFailed(r, user.Email)
+		attempts, _ := session.Values["pendingAttempts"].(int)
+		attempts++
+		session.Values["pendingAttempts"] = attempts
+		message := "invalid code"
+		if attempts >= maxTwoFactorAttempts {
+			delete(session.Values, "pendingUserId")
+			message = "too many wrong codes, log in again"
+		}
+		if err := session.Save(r, w); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSONError(w, http.StatusUnauthorized, message)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Logging in for real gets a session of its own.
+	if err := s.sessions.Renew(r.Context(), session); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.startSession(session, user)
+	s.loginSucceeded(r, user.Email)
+	if err := session.Save(r, w); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticatedUser returns the logged-in user, answering 401 if there is
+// none.
+func (s *Server) authenticatedUser(w http.ResponseWriter, r *http.Request) (*sessions.Session, *models.User, bool) {
+	session, err := s.session(r)
+	if err != nil || session.Values["authenticated"] != true {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return nil, nil, false
+	}
+
+	userId, _ := session.Values["id"].(string)
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return nil, nil, false
+	}
+
+	return session, user, true
+}
+
+type twoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+func (s *Server) GetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := s.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(twoFactorStatus{
+		Enabled:           user.TOTPSecret != "",
+		RecoveryCodesLeft: len(user.RecoveryCodes),
+	})
+}
+
+type twoFactorSetup struct {
+	Secret string `json:"secret"`
+	// URI is what the QR code for authenticator apps encodes.
+	URI string `json:"uri"`
+}
+
+// SetupTwoFactor starts enrolling: it makes a secret for the user to add to
+// their authenticator app. Nothing changes until EnableTwoFactor sees a code
+// made from it.
+func (s *Server) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := s.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPSecret != "" {
+		writeJSONError(w, http.StatusBadRequest, "two-factor login is already on")
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The session is kept on the server, so the secret never reaches the
+	// cookie.
+	session.Values["totpSetup"] = secret
+	if err := session.Save(r, w); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(twoFactorSetup{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(s.config.TOTPIssuer, user.Email, secret),
+	})
+}
+
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// EnableTwoFactor turns two-factor login on once the user shows a code from
+// the secret SetupTwoFactor gave them, and answers with their recovery
+// codes. They are only ever shown this once.
+func (s *Server) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := s.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, _ := session.Values["totpSetup"].(string)
+	if secret == "" {
+		writeJSONError(w, http.StatusBadRequest, "start setting up two-factor login first")
+		return
+	}
+
+	step, ok := totp.Validate(secret, r.FormValue("code"), time.Now())
+	if !ok {
+		writeError(w, repository.ErrInvalidCode)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), user.Id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.accounts.EnableTOTP(r.Context(), docId, secret, hashes); err != nil {
+		writeError(w, err)
+		return
+	}
+	// The code just shown cannot be used again to log in.
+	if err := s.accounts.UseTOTPStep(r.Context(), docId, step); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	delete(session.Values, "totpSetup")
+	if err := session.Save(r, w); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns two-factor login off. Being logged in is not
+// enough: the user must give their password and a current code again.
+func (s *Server) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := s.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPSecret == "" {
+		writeJSONError(w, http.StatusBadRequest, "two-factor login is already off")
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(r.FormValue("password"))) != nil {
+		writeJSONError(w, http.StatusForbidden, "password is wrong")
+		return
+	}
+
+	err := s.useSecondFactor(r.Context(), user, r.FormValue("code"))
+	if errors.Is(err, repository.ErrInvalidCode) {
+		writeJSONError(w, http.StatusForbidden, "invalid code")
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), user.Id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.accounts.DisableTOTP(r.Context(), docId); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
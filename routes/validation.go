@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+)
+
+// validateEmail accepts a bare address such as "jane@example.com", rejecting
+// display-name forms like "Jane <jane@example.com>".
+func validateEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,29}$`)
+
+// validateUsername accepts 3 to 30 lower-case letters, digits and
+// underscores starting with a letter, which is also what an @mention can
+// refer to. Callers normalize the case first.
+func validateUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// minPasswordLength is the shortest password an account may have.
+const minPasswordLength = 8
+
+// validatePassword checks a new password and its confirmation.
+func validatePassword(password string, confirmPassword string) error {
+	if password != confirmPassword {
+		return errors.New("passwords do not match")
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
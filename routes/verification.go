@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"posts/mail"
+	"posts/models"
+	"posts/repository"
+	"time"
+)
+
+// sendVerification emails a link that confirms email for userId.
+func (s *Server) sendVerification(ctx context.Context, userId string, email string) error {
+	ttl := time.Duration(s.config.EmailVerificationHours) * time.Hour
+	secret, token, err := newToken(models.EmailVerificationToken, userId, ttl)
+	if err != nil {
+		return err
+	}
+	token.Email = email
+
+	if err := s.tokens.CreateToken(ctx, token); err != nil {
+		return err
+	}
+
+	link := s.config.BaseURL + "/verify-email?token=" + url.QueryEscape(secret)
+	return s.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Follow this link within %d hours to confirm this is your email address:\n\n%s\n\nIf you didn't sign up or change your email, you can ignore this email.\n",
+			s.config.EmailVerificationHours, link),
+	})
+}
+
+// requireVerified answers with a 403 unless userId has confirmed their email
+// address, which is needed before they can post or send messages.
+func (s *Server) requireVerified(w http.ResponseWriter, r *http.Request, userId string) bool {
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return false
+	}
+
+	if !user.Verified {
+		writeError(w, errUnverified)
+		return false
+	}
+
+	return true
+}
+
+// VerifyEmail uses an emailed token to confirm an address: the one the
+// account signed up with, or the one it is changing to.
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token, err := s.tokens.ConsumeToken(r.Context(), models.EmailVerificationToken, hashToken(r.FormValue("token")), time.Now().UTC())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	docId, err := s.accounts.GetDocumentIdByUuid(r.Context(), token.UserId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.accounts.VerifyEmail(r.Context(), docId, token.Email)
+	if errors.Is(err, repository.ErrUserExists) {
+		writeJSONError(w, http.StatusConflict, "another account has taken that email address")
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.sessions.SetUserValues(r.Context(), token.UserId, map[interface{}]interface{}{"email": token.Email})
+	if err != nil {
+		log.Println("Error updating sessions:", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification emails a new confirmation link, to the pending address
+// if there is one and otherwise to the unconfirmed current one.
+func (s *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.sessionUserId(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return
+	}
+
+	user, err := s.accounts.FindAccountByUuidUncached(r.Context(), userId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	email := user.PendingEmail
+	if email == "" {
+		if user.Verified {
+			writeJSONError(w, http.StatusBadRequest, "email address is already confirmed")
+			return
+		}
+		email = user.Email
+	}
+
+	if err := s.sendVerification(r.Context(), user.Id, email); err != nil {
+		log.Println("Error sending verification email:", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "could not send the email, try again later")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,81 @@
+// Package search answers full-text queries over posts and users from an
+// inverted index the handlers keep up to date as things change.
+package search
+
+import (
+	"encoding/base64"
+	"posts/models"
+	"posts/repository"
+	"strconv"
+)
+
+// Types of document that can be searched for.
+const (
+	PostsType = "posts"
+	UsersType = "users"
+)
+
+// Index is what the handlers search and keep current. Every method is safe
+// to call from many requests at once.
+type Index interface {
+	// IndexPost adds the post or replaces what was indexed for it.
+	IndexPost(post *models.Post) error
+	RemovePost(postId string) error
+	// IndexUser adds the user or replaces what was indexed for them.
+	IndexUser(user *models.User) error
+	// Search ranks the documents of kind that match every word of query,
+	// best first. Only the last word may match as a prefix, so results keep
+	// up with someone still typing.
+	Search(kind string, query string, page repository.PageRequest) (*Results, error)
+}
+
+// Field names that highlights point into.
+const (
+	ContentField  = "content"
+	NameField     = "name"
+	UsernameField = "username"
+)
+
+// Highlight marks a matched word in one of a hit's fields, in code points.
+type Highlight struct {
+	Field string `json:"field"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+type Hit struct {
+	Id         string      `json:"id"`
+	Score      float64     `json:"score"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+type Results struct {
+	Hits []Hit
+	// Total is how many documents matched, over all pages.
+	Total      int
+	NextCursor string
+}
+
+// Search results are ranked rather than ordered by time, so their cursor is
+// simply how many hits came before the next page.
+func encodeOffset(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeOffset(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, repository.ErrInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, repository.ErrInvalidCursor
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,570 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"posts/models"
+	"posts/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BM25 tuning, at the values most engines default to.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// prefixWeight discounts a word that only starts with the last query word,
+// so exact matches rank first.
+const prefixWeight = 0.5
+
+// fieldBoosts weights a word by the field it is found in. A user found by
+// their username is a better match than one found by a word of their name.
+var fieldBoosts = map[string]float64{
+	ContentField:  1,
+	NameField:     2,
+	UsernameField: 3,
+}
+
+// fieldOrder is the order highlights are listed in.
+var fieldOrder = []string{UsernameField, NameField, ContentField}
+
+// document is everything the index keeps about one post or user.
+type document struct {
+	Kind   string            `json:"kind"`
+	Id     string            `json:"id"`
+	Fields map[string]string `json:"fields"`
+	// CreatedAt breaks ties between equally good posts, newest first.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (d *document) key() string {
+	return d.Kind + "/" + d.Id
+}
+
+type kindStats struct {
+	docs   int
+	length int
+}
+
+// InvertedIndex is an Index held in memory. Only the documents are saved,
+// one record each in a BoltDB file, and the postings are rebuilt from them
+// on Open. Changes are written in the background, a batch at a time, so
+// indexing never waits on the disk; a crash can lose the last second of
+// them, which -reindex puts right.
+type InvertedIndex struct {
+	mu sync.RWMutex
+	db *bolt.DB
+	// pending holds the documents changed since they were last written, by
+	// key, with nil for removed ones.
+	pending map[string]*document
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	docs map[string]*document
+	// postings maps each term to the documents it is in and how often,
+	// weighted by field.
+	postings map[string]map[string]float64
+	lengths  map[string]int
+	stats    map[string]*kindStats
+}
+
+// documentsBucket holds every indexed document, by key.
+var documentsBucket = []byte("documents")
+
+// flushInterval is how long a change waits before it is written, so a
+// burst of them goes out in one write.
+const flushInterval = time.Second
+
+// New returns an empty index that is never saved.
+func New() *InvertedIndex {
+	return &InvertedIndex{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]float64),
+		lengths:  make(map[string]int),
+		stats:    make(map[string]*kindStats),
+	}
+}
+
+// Open loads the index saved at path, or starts an empty one there if
+// there is nothing yet. An empty path gives an index that is never saved.
+func Open(path string) (*InvertedIndex, error) {
+	index := New()
+	if path == "" {
+		return index, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(documentsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			var doc document
+			if err := json.Unmarshal(value, &doc); err != nil {
+				return fmt.Errorf("reading search document %q: %w", key, err)
+			}
+			index.add(&doc)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	index.db = db
+	index.pending = make(map[string]*document)
+	index.wake = make(chan struct{}, 1)
+	index.done = make(chan struct{})
+	index.stopped = make(chan struct{})
+	go index.flushLoop()
+
+	return index, nil
+}
+
+// Close writes what is still pending and closes the file behind the index,
+// if there is one.
+func (i *InvertedIndex) Close() error {
+	if i.db == nil {
+		return nil
+	}
+	close(i.done)
+	<-i.stopped
+	return i.db.Close()
+}
+
+// Empty reports whether nothing has been indexed, as on a first start.
+func (i *InvertedIndex) Empty() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.docs) == 0
+}
+
+func postDocument(post *models.Post) *document {
+	return &document{
+		Kind:      PostsType,
+		Id:        post.Id,
+		Fields:    map[string]string{ContentField: post.Content},
+		CreatedAt: post.CreatedAt,
+	}
+}
+
+func userDocument(user *models.User) *document {
+	return &document{
+		Kind: UsersType,
+		Id:   user.Id,
+		Fields: map[string]string{
+			NameField:     strings.TrimSpace(user.FirstName + " " + user.LastName),
+			UsernameField: user.Username,
+		},
+	}
+}
+
+// IndexPost leaves out deleted posts and plain reposts, which have no text
+// of their own.
+func (i *InvertedIndex) IndexPost(post *models.Post) error {
+	if post.DeletedAt != nil || post.Content == "" {
+		return i.RemovePost(post.Id)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	doc := postDocument(post)
+	i.add(doc)
+	i.queue(doc.key(), doc)
+	return nil
+}
+
+func (i *InvertedIndex) RemovePost(postId string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	key := (&document{Kind: PostsType, Id: postId}).key()
+	if _, ok := i.docs[key]; !ok {
+		return nil
+	}
+
+	i.remove(key)
+	i.queue(key, nil)
+	return nil
+}
+
+func (i *InvertedIndex) IndexUser(user *models.User) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	doc := userDocument(user)
+	i.add(doc)
+	i.queue(doc.key(), doc)
+	return nil
+}
+
+// Rebuild replaces everything in the index with what is in the primary
+// store, then saves it once. Anything indexed while it runs is lost, so it
+// is meant for startup and the -reindex command.
+func (i *InvertedIndex) Rebuild(ctx context.Context, accounts repository.AccountRepository, posts repository.PostsRepository) error {
+	fresh := New()
+
+	err := accounts.EachAccount(ctx, func(user *models.User) error {
+		fresh.add(userDocument(user))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = posts.EachPost(ctx, func(post *models.Post) error {
+		if post.Content != "" {
+			fresh.add(postDocument(post))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.docs, i.postings, i.lengths, i.stats = fresh.docs, fresh.postings, fresh.lengths, fresh.stats
+	if i.db == nil {
+		return nil
+	}
+
+	i.pending = make(map[string]*document)
+	return i.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(documentsBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(documentsBucket)
+		if err != nil {
+			return err
+		}
+		for key, doc := range i.docs {
+			if err := putDocument(bucket, key, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// add indexes doc in place of any earlier version. Callers must hold i.mu.
+func (i *InvertedIndex) add(doc *document) {
+	key := doc.key()
+	i.remove(key)
+
+	length := 0
+	for field, text := range doc.Fields {
+		for _, token := range tokenize(text) {
+			if i.postings[token.Term] == nil {
+				i.postings[token.Term] = make(map[string]float64)
+			}
+			i.postings[token.Term][key] += fieldBoosts[field]
+			length++
+		}
+	}
+
+	stats := i.stats[doc.Kind]
+	if stats == nil {
+		stats = &kindStats{}
+		i.stats[doc.Kind] = stats
+	}
+	stats.docs++
+	stats.length += length
+
+	i.docs[key] = doc
+	i.lengths[key] = length
+}
+
+// remove drops the document at key if there is one. Callers must hold i.mu.
+func (i *InvertedIndex) remove(key string) {
+	doc, ok := i.docs[key]
+	if !ok {
+		return
+	}
+
+	for _, text := range doc.Fields {
+		for _, term := range terms(text) {
+			delete(i.postings[term], key)
+			if len(i.postings[term]) == 0 {
+				delete(i.postings, term)
+			}
+		}
+	}
+
+	stats := i.stats[doc.Kind]
+	stats.docs--
+	stats.length -= i.lengths[key]
+
+	delete(i.docs, key)
+	delete(i.lengths, key)
+}
+
+// queue marks the document at key to be written, or deleted if doc is nil.
+// Callers must hold i.mu.
+func (i *InvertedIndex) queue(key string, doc *document) {
+	if i.db == nil {
+		return
+	}
+
+	i.pending[key] = doc
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flushLoop writes pending changes a moment after the first of them, and
+// once more when the index is closed.
+func (i *InvertedIndex) flushLoop() {
+	defer close(i.stopped)
+
+	for {
+		select {
+		case <-i.wake:
+			timer := time.NewTimer(flushInterval)
+			select {
+			case <-timer.C:
+			case <-i.done:
+				timer.Stop()
+			}
+		case <-i.done:
+			i.flush()
+			return
+		}
+		i.flush()
+	}
+}
+
+// flush writes the pending changes in one transaction. If that fails they
+// are kept for the next try, unless they have been changed again since.
+func (i *InvertedIndex) flush() {
+	i.mu.Lock()
+	pending := i.pending
+	i.pending = make(map[string]*document)
+	i.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	// Documents are never changed once made, so they can be encoded
+	// without the lock.
+	err := i.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(documentsBucket)
+		for key, doc := range pending {
+			if doc == nil {
+				if err := bucket.Delete([]byte(key)); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := putDocument(bucket, key, doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+
+	log.Println("Error saving search index:", err)
+	i.mu.Lock()
+	for key, doc := range pending {
+		if _, ok := i.pending[key]; !ok {
+			i.pending[key] = doc
+		}
+	}
+	i.mu.Unlock()
+}
+
+func putDocument(bucket *bolt.Bucket, key string, doc *document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), data)
+}
+
+type match struct {
+	doc   *document
+	score float64
+	// terms are the indexed words that matched, for highlighting.
+	terms map[string]bool
+}
+
+func (i *InvertedIndex) Search(kind string, query string, page repository.PageRequest) (*Results, error) {
+	offset, err := decodeOffset(page.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	words := terms(query)
+	if len(words) == 0 {
+		return &Results{Hits: []Hit{}}, nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	stats := i.stats[kind]
+	if stats == nil || stats.docs == 0 {
+		return &Results{Hits: []Hit{}}, nil
+	}
+	avgLength := float64(stats.length) / float64(stats.docs)
+	if avgLength == 0 {
+		avgLength = 1
+	}
+
+	var matches map[string]*match
+	for n, word := range words {
+		scores := i.scoreWord(kind, word, n == len(words)-1, stats.docs, avgLength)
+
+		if matches == nil {
+			matches = scores
+			continue
+		}
+		for key, m := range matches {
+			found, ok := scores[key]
+			if !ok {
+				delete(matches, key)
+				continue
+			}
+			m.score += found.score
+			for term := range found.terms {
+				m.terms[term] = true
+			}
+		}
+	}
+
+	ranked := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		ranked = append(ranked, m)
+	}
+	sort.Slice(ranked, func(x, y int) bool {
+		first, second := ranked[x], ranked[y]
+		if first.score != second.score {
+			return first.score > second.score
+		}
+		if !first.doc.CreatedAt.Equal(second.doc.CreatedAt) {
+			return first.doc.CreatedAt.After(second.doc.CreatedAt)
+		}
+		return first.doc.Id < second.doc.Id
+	})
+
+	results := &Results{Hits: []Hit{}, Total: len(ranked)}
+	if offset >= len(ranked) {
+		return results, nil
+	}
+	end := offset + page.Limit
+	if end < len(ranked) {
+		results.NextCursor = encodeOffset(end)
+	} else {
+		end = len(ranked)
+	}
+
+	for _, m := range ranked[offset:end] {
+		results.Hits = append(results.Hits, Hit{
+			Id:         m.doc.Id,
+			Score:      m.score,
+			Highlights: highlight(m.doc, m.terms),
+		})
+	}
+
+	return results, nil
+}
+
+// scoreWord finds the documents of kind that contain word, or for the last
+// word of a query anything starting with it, and scores each with BM25.
+// A document matching several ways keeps its best score.
+func (i *InvertedIndex) scoreWord(kind string, word string, last bool, docs int, avgLength float64) map[string]*match {
+	scores := make(map[string]*match)
+
+	score := func(term string, weight float64) {
+		postings := i.postings[term]
+
+		df := 0
+		for key := range postings {
+			if i.docs[key].Kind == kind {
+				df++
+			}
+		}
+		idf := math.Log(1 + (float64(docs)-float64(df)+0.5)/(float64(df)+0.5))
+
+		for key, tf := range postings {
+			doc := i.docs[key]
+			if doc.Kind != kind {
+				continue
+			}
+
+			norm := 1 - b + b*float64(i.lengths[key])/avgLength
+			s := weight * idf * tf * (k1 + 1) / (tf + k1*norm)
+
+			m, ok := scores[key]
+			if !ok {
+				m = &match{doc: doc, terms: make(map[string]bool)}
+				scores[key] = m
+			}
+			m.terms[term] = true
+			if s > m.score {
+				m.score = s
+			}
+		}
+	}
+
+	if _, ok := i.postings[word]; ok {
+		score(word, 1)
+	}
+	// A single letter would match too much of the index to be useful.
+	if last && utf8.RuneCountInString(word) > 1 {
+		for term := range i.postings {
+			if term != word && strings.HasPrefix(term, word) {
+				score(term, prefixWeight)
+			}
+		}
+	}
+
+	return scores
+}
+
+func highlight(doc *document, matched map[string]bool) []Highlight {
+	highlights := []Highlight{}
+	for _, field := range fieldOrder {
+		text, ok := doc.Fields[field]
+		if !ok {
+			continue
+		}
+		for _, token := range tokenize(text) {
+			if matched[token.Term] {
+				highlights = append(highlights, Highlight{Field: field, Start: token.Start, End: token.End})
+			}
+		}
+	}
+	return highlights
+}
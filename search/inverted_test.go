@@ -0,0 +1,206 @@
+package search_test
+
+import (
+	"errors"
+	"path/filepath"
+	"posts/models"
+	"posts/repository"
+	"posts/search"
+	"testing"
+	"time"
+)
+
+func hitIds(results *search.Results) []string {
+	ids := []string{}
+	for _, hit := range results.Hits {
+		ids = append(ids, hit.Id)
+	}
+	return ids
+}
+
+func expectHits(t *testing.T, index search.Index, kind string, query string, want ...string) *search.Results {
+	t.Helper()
+	results, err := index.Search(kind, query, repository.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := hitIds(results)
+	if len(got) != len(want) {
+		t.Errorf("search %q = %v, want %v", query, got, want)
+		return results
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("search %q = %v, want %v", query, got, want)
+			break
+		}
+	}
+	return results
+}
+
+func TestSearchMatchesEveryWord(t *testing.T) {
+	index := search.New()
+	now := time.Now()
+	for _, post := range []*models.Post{
+		{Id: "both", Content: "Gophers love Go", CreatedAt: now},
+		{Id: "one", Content: "Gophers dig tunnels", CreatedAt: now},
+		{Id: "go", Content: "go go go", CreatedAt: now},
+	} {
+		if err := index.IndexPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectHits(t, index, search.PostsType, "go gophers", "both")
+	expectHits(t, index, search.PostsType, "TUNNELS", "one")
+	expectHits(t, index, search.PostsType, "")
+	expectHits(t, index, search.UsersType, "gophers")
+}
+
+func TestSearchOnlyLastWordMatchesAsAPrefix(t *testing.T) {
+	index := search.New()
+	now := time.Now()
+	for _, post := range []*models.Post{
+		{Id: "typing", Content: "typing slowly", CreatedAt: now},
+		{Id: "typed", Content: "typed quickly", CreatedAt: now},
+	} {
+		if err := index.IndexPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectHits(t, index, search.PostsType, "typ", "typed", "typing")
+	expectHits(t, index, search.PostsType, "typ slowly")
+	expectHits(t, index, search.PostsType, "slowly typ", "typing")
+	// A single letter matches nothing but itself.
+	expectHits(t, index, search.PostsType, "t")
+}
+
+func TestSearchRanksUsernamesAboveNames(t *testing.T) {
+	index := search.New()
+	for _, user := range []*models.User{
+		{Id: "named", FirstName: "Ada", LastName: "Lovelace", Username: "countess"},
+		{Id: "handle", FirstName: "Someone", LastName: "Else", Username: "ada"},
+	} {
+		if err := index.IndexUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := expectHits(t, index, search.UsersType, "ada", "handle", "named")
+	if len(results.Hits) > 0 {
+		want := []search.Highlight{{Field: search.UsernameField, Start: 0, End: 3}}
+		got := results.Hits[0].Highlights
+		if len(got) != 1 || got[0] != want[0] {
+			t.Errorf("highlights of the best hit = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestSearchForgetsRemovedPosts(t *testing.T) {
+	index := search.New()
+	post := &models.Post{Id: "p", Content: "temporary thought", CreatedAt: time.Now()}
+	if err := index.IndexPost(post); err != nil {
+		t.Fatal(err)
+	}
+
+	post.Content = "lasting thought"
+	if err := index.IndexPost(post); err != nil {
+		t.Fatal(err)
+	}
+	expectHits(t, index, search.PostsType, "temporary")
+	expectHits(t, index, search.PostsType, "lasting", "p")
+
+	deletedAt := time.Now()
+	post.DeletedAt = &deletedAt
+	if err := index.IndexPost(post); err != nil {
+		t.Fatal(err)
+	}
+	expectHits(t, index, search.PostsType, "thought")
+	if !index.Empty() {
+		t.Error("index is not empty after its only post was deleted")
+	}
+}
+
+func TestSearchPagesFollowTheCursor(t *testing.T) {
+	index := search.New()
+	start := time.Now()
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		post := &models.Post{Id: id, Content: "same words", CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := index.IndexPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("the cursor never ran out")
+		}
+		results, err := index.Search(search.PostsType, "words", repository.PageRequest{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.Total != 5 {
+			t.Errorf("total = %d, want 5", results.Total)
+		}
+		got = append(got, hitIds(results)...)
+		if results.NextCursor == "" {
+			break
+		}
+		cursor = results.NextCursor
+	}
+
+	// Equally good posts come newest first.
+	want := []string{"e", "d", "c", "b", "a"}
+	if len(got) != len(want) {
+		t.Fatalf("pages held %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pages held %v, want %v", got, want)
+		}
+	}
+
+	if _, err := index.Search(search.PostsType, "words", repository.PageRequest{Limit: 2, Cursor: "garbage"}); !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("bad cursor: err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestOpenKeepsTheIndexAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "search.db")
+	index, err := search.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, post := range []*models.Post{
+		{Id: "kept", Content: "kept around", CreatedAt: now},
+		{Id: "gone", Content: "gone soon", CreatedAt: now},
+	} {
+		if err := index.IndexPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.IndexUser(&models.User{Id: "alice", FirstName: "Alice", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.RemovePost("gone"); err != nil {
+		t.Fatal(err)
+	}
+	// Close writes whatever is still waiting to be saved.
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err = search.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	expectHits(t, index, search.PostsType, "kept", "kept")
+	expectHits(t, index, search.PostsType, "gone")
+	expectHits(t, index, search.UsersType, "alice", "alice")
+}
@@ -0,0 +1,58 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// token is one indexed word and where it sits in its field, counted in code
+// points like post entities are.
+type token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// tokenize splits text into lower-case runs of letters, digits and
+// underscores, so "#Go_lang" and "@go_lang" both index as "go_lang".
+func tokenize(text string) []token {
+	var tokens []token
+	var word []rune
+	start := 0
+
+	flush := func(end int) {
+		if len(word) > 0 {
+			tokens = append(tokens, token{Term: strings.ToLower(string(word)), Start: start, End: end})
+			word = word[:0]
+		}
+	}
+
+	i := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if len(word) == 0 {
+				start = i
+			}
+			word = append(word, r)
+		} else {
+			flush(i)
+		}
+		i++
+	}
+	flush(i)
+
+	return tokens
+}
+
+// terms is tokenize without the offsets, with repeats dropped.
+func terms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, token := range tokenize(text) {
+		if !seen[token.Term] {
+			seen[token.Term] = true
+			terms = append(terms, token.Term)
+		}
+	}
+	return terms
+}
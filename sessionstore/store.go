@@ -0,0 +1,297 @@
+// Package sessionstore keeps sessions on the server, so they can be listed
+// and revoked, with only a random session id in the cookie.
+package sessionstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"posts/models"
+	"posts/repository"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// idBytes is how much randomness goes into a session id.
+const idBytes = 32
+
+// touchInterval is how stale a session's last-seen time may get before a
+// request updates it, so most requests don't write to storage.
+const touchInterval = time.Minute
+
+// encoder turns session values into what is stored.
+var encoder securecookie.GobEncoder
+
+// userIdKey is the session value a session's owner is read from.
+const userIdKey = "id"
+
+// Store is a sessions.Store backed by a SessionsRepository. The cookie holds
+// the session id, signed like a gorilla cookie; what is stored is keyed by
+// the id's hash, so a leaked copy of storage cannot be used to sign in.
+type Store struct {
+	repo    repository.SessionsRepository
+	codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+// New returns a store whose cookies are signed with keyPairs, as for
+// sessions.NewCookieStore, and use options unless a session overrides them.
+func New(repo repository.SessionsRepository, options sessions.Options, keyPairs ...[]byte) *Store {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			// The stored session's expiry is what counts.
+			sc.MaxAge(0)
+		}
+	}
+	return &Store{repo: repo, codecs: codecs, Options: &options}
+}
+
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A cookie that does
+// not decode, or names a session that has expired or been revoked, gives a
+// new empty session rather than an error, so it simply reads as logged out.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	stored, err := s.repo.GetSession(r.Context(), hashId(id))
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if err := encoder.Deserialize(stored.Values, &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+
+	s.touch(r, stored)
+
+	return session, nil
+}
+
+// touch records when and where the session was last used. Failing to is
+// not worth failing the request over.
+func (s *Store) touch(r *http.Request, stored *models.Session) {
+	now := time.Now().UTC()
+	ip := ClientIP(r)
+	if now.Sub(stored.LastSeenAt) < touchInterval && ip == stored.IP && r.UserAgent() == stored.UserAgent {
+		return
+	}
+
+	if err := s.repo.TouchSession(r.Context(), stored.Id, now, ip, r.UserAgent()); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		log.Println("Error updating session:", err)
+	}
+}
+
+// Save stores the session and sets its cookie, or with a negative MaxAge
+// deletes it and clears the cookie.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			err := s.repo.DeleteSession(r.Context(), hashId(session.ID))
+			if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	values, err := encoder.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = s.Options.MaxAge
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(time.Duration(maxAge) * time.Second)
+
+	if session.IsNew || session.ID == "" {
+		err = s.create(r, session, values, now, expiresAt)
+	} else {
+		err = s.repo.UpdateSession(r.Context(), hashId(session.ID), values, expiresAt)
+	}
+	// A session revoked while the request ran stays revoked.
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", &sessions.Options{Path: session.Options.Path, MaxAge: -1}))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *Store) create(r *http.Request, session *sessions.Session, values []byte, now time.Time, expiresAt time.Time) error {
+	raw := make([]byte, idBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	userId, _ := session.Values[userIdKey].(string)
+	err := s.repo.CreateSession(r.Context(), &models.Session{
+		Id:         hashId(id),
+		UserId:     userId,
+		Values:     values,
+		UserAgent:  r.UserAgent(),
+		IP:         ClientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return nil
+}
+
+// Renew deletes what is stored for session and empties it, and it gets a
+// fresh id when next saved. Logging in renews the session, so a session id
+// planted in someone's browser before they log in never becomes theirs.
+func (s *Store) Renew(ctx context.Context, session *sessions.Session) error {
+	if session.ID != "" {
+		err := s.repo.DeleteSession(ctx, hashId(session.ID))
+		if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	session.Values = make(map[interface{}]interface{})
+	return nil
+}
+
+// StoredId returns the id session is stored and listed under, or "" if it
+// has not been saved.
+func (s *Store) StoredId(session *sessions.Session) string {
+	if session.ID == "" {
+		return ""
+	}
+	return hashId(session.ID)
+}
+
+// Active reports whether session is still stored, that is has not expired
+// or been revoked since it was loaded. Long-lived requests check it now and
+// then.
+func (s *Store) Active(ctx context.Context, session *sessions.Session) (bool, error) {
+	if session.ID == "" {
+		return false, nil
+	}
+	_, err := s.repo.GetSession(ctx, hashId(session.ID))
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the user's sessions, most recently seen first.
+func (s *Store) List(ctx context.Context, userId string) ([]*models.Session, error) {
+	return s.repo.GetUserSessions(ctx, userId)
+}
+
+// Revoke deletes the user's session with the stored id. Another user's
+// session is reported as not found.
+func (s *Store) Revoke(ctx context.Context, userId string, id string) error {
+	stored, err := s.repo.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if stored.UserId != userId {
+		return repository.ErrSessionNotFound
+	}
+	return s.repo.DeleteSession(ctx, id)
+}
+
+// RevokeOthers deletes every session of the user except keep, which may be
+// nil to delete them all.
+func (s *Store) RevokeOthers(ctx context.Context, userId string, keep *sessions.Session) error {
+	except := ""
+	if keep != nil {
+		except = s.StoredId(keep)
+	}
+	return s.repo.DeleteUserSessions(ctx, userId, except)
+}
+
+// SetUserValues sets values in every session of the user, so a change to
+// what sessions hold, like the user's name, reaches all their devices.
+func (s *Store) SetUserValues(ctx context.Context, userId string, values map[interface{}]interface{}) error {
+	stored, err := s.repo.GetUserSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range stored {
+		decoded := make(map[interface{}]interface{})
+		if err := encoder.Deserialize(session.Values, &decoded); err != nil {
+			return err
+		}
+		for key, value := range values {
+			decoded[key] = value
+		}
+		encoded, err := encoder.Serialize(decoded)
+		if err != nil {
+			return err
+		}
+
+		err = s.repo.UpdateSession(ctx, session.Id, encoded, session.ExpiresAt)
+		if err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClientIP returns the address a request came from.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func hashId(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,148 @@
+package totp_test
+
+import (
+	"context"
+	"errors"
+	"posts/memory"
+	"posts/models"
+	"posts/repository"
+	"posts/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of RFC 6238 Appendix B, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The Appendix B SHA1 test vectors. The RFC lists eight digits; six-digit
+// codes are their last six.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	for _, vector := range rfcVectors {
+		at := time.Unix(vector.unix, 0)
+		want := vector.code[len(vector.code)-totp.Digits:]
+
+		got, err := totp.Code(rfcSecret, totp.Step(at))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", vector.unix, err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", vector.unix, got, want)
+		}
+
+		step, ok := totp.Validate(rfcSecret, want, at)
+		if !ok || step != totp.Step(at) {
+			t.Errorf("Validate(%s) at %d = %d, %v, want %d, true", want, vector.unix, step, ok, totp.Step(at))
+		}
+	}
+}
+
+func TestCodeAcceptsLowerCaseSecret(t *testing.T) {
+	want, _ := totp.Code(rfcSecret, 1)
+	got, err := totp.Code(strings.ToLower(rfcSecret), 1)
+	if err != nil || got != want {
+		t.Errorf("Code with a lower-case secret = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestValidateAllowsOneStepOfSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totp.Step(now)
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, test := range tests {
+		code, err := totp.Code(rfcSecret, current+test.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := totp.Validate(rfcSecret, code, now)
+		if ok != test.ok {
+			t.Errorf("code %+d steps away: ok = %v, want %v", test.offset, ok, test.ok)
+		}
+		if ok && step != current+test.offset {
+			t.Errorf("code %+d steps away: step = %d, want %d", test.offset, step, current+test.offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, ok := totp.Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted", code)
+		}
+	}
+	if _, ok := totp.Validate(rfcSecret, "287 082", now); !ok {
+		t.Error("Validate rejected a code with a space in it")
+	}
+}
+
+// A code is only good once: after its step is used, it and any earlier
+// code still inside the skew window are refused.
+func TestUsedStepIsNotAcceptedAgain(t *testing.T) {
+	ctx := context.Background()
+	accounts := memory.New().Accounts()
+
+	user := &models.User{Email: "totp@example.com", Password: "password1"}
+	if err := accounts.CreateAccount(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.EnableTOTP(ctx, user.Id, rfcSecret, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1234567890, 0)
+	code, _ := totp.Code(rfcSecret, totp.Step(now))
+	earlier, _ := totp.Code(rfcSecret, totp.Step(now)-1)
+
+	step, ok := totp.Validate(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("current code rejected")
+	}
+	if err := accounts.UseTOTPStep(ctx, user.Id, step); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	if err := accounts.UseTOTPStep(ctx, user.Id, step); !errors.Is(err, repository.ErrInvalidCode) {
+		t.Errorf("replayed code: err = %v, want ErrInvalidCode", err)
+	}
+
+	step, ok = totp.Validate(rfcSecret, earlier, now)
+	if !ok {
+		t.Fatal("previous code rejected inside the skew window")
+	}
+	if err := accounts.UseTOTPStep(ctx, user.Id, step); !errors.Is(err, repository.ErrInvalidCode) {
+		t.Errorf("earlier code after a later one: err = %v, want ErrInvalidCode", err)
+	}
+
+	stored, err := accounts.FindAccountByUuid(ctx, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TOTPLastStep != totp.Step(now) {
+		t.Errorf("TOTPLastStep = %d, want %d", stored.TOTPLastStep, totp.Step(now))
+	}
+}